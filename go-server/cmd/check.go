@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/store"
+)
+
+// runCheck verifies the catalog before a deploy and prints a JSON report.
+// It exits with 1 when the report contains errors.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	root := fs.String("root", hls.DefaultContentsRoot, "contents root")
+	catalogKind := fs.String("catalog", string(hls.CatalogJSON), "catalog to check (json, dir, sqlite)")
+	catalogPath := fs.String("catalog-path", "", "index.json for the json catalog (default: index.json under -root)")
+	dbPath := fs.String("db", "", "SQLite database for the sqlite catalog")
+	layout := fs.String("content-layout", hls.LayoutID, "how tracks map to files: id or path")
+	tolerance := fs.Float64("tolerance", 1, "allowed difference in seconds between a track's length and its EXTINF sum")
+	inspect := fs.Bool("inspect", false, "also inspect every TS segment (reads the whole library)")
+	output := fs.String("o", "", "write the report to this file instead of stdout")
+	_ = fs.Parse(args)
+
+	var catalog hls.Catalog
+	switch *catalogKind {
+	case string(hls.CatalogJSON):
+		path := *catalogPath
+		if path == "" {
+			path = filepath.Join(*root, "index.json")
+		}
+		catalog, _ = hls.NewJSONCatalog(path, 0) // 読み込みエラーはレポートに含まれる
+	case string(hls.CatalogDir):
+		catalog, _ = hls.NewDirCatalog(*root, 0)
+	case catalogSQLite:
+		if *dbPath == "" {
+			fmt.Fprintln(os.Stderr, "the sqlite catalog requires -db")
+			return 2
+		}
+		db, err := store.Open(*dbPath, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+		catalog = db
+	default:
+		fmt.Fprintf(os.Stderr, "unknown catalog kind %q\n", *catalogKind)
+		return 2
+	}
+
+	formatter, err := hls.NewContentFormatter(*layout, *root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := hls.CheckCatalog(catalog, hls.CheckConfig{
+		Root:            *root,
+		Formatter:       formatter,
+		LengthTolerance: *tolerance,
+		Inspect:         *inspect,
+		Validation:      hls.DefaultValidationConfig(),
+	})
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data = append(data, '\n')
+	if *output != "" {
+		err = os.WriteFile(*output, data, 0644)
+	} else {
+		_, err = os.Stdout.Write(data)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !report.OK {
+		fmt.Fprintf(os.Stderr, "check failed: %d errors, %d warnings in %d tracks\n", report.Errors, report.Warnings, report.Tracks)
+		return 1
+	}
+	return 0
+}
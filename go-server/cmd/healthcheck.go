@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runHealthcheck probes the readiness endpoint so that container healthchecks
+// work on the scratch image, which has no curl or wget
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	url := fs.String("url", "http://127.0.0.1:8080/api/health/ready", "health endpoint to probe")
+	timeout := fs.Duration("timeout", 3*time.Second, "request timeout")
+	_ = fs.Parse(args)
+
+	client := http.Client{Timeout: *timeout}
+	resp, err := client.Get(*url)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "healthcheck failed:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "healthcheck failed:", resp.Status)
+		return 1
+	}
+	return 0
+}
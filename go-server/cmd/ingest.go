@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/ingest"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/logging"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/store"
+)
+
+// runIngest adds audio files or prepared HLS folders to the contents tree:
+//
+//	server ingest [flags] <file or folder>...
+func runIngest(args []string) int {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	root := fs.String("root", hls.DefaultContentsRoot, "contents root")
+	contentType := fs.String("type", "music", "content type directory")
+	id := fs.String("id", "", "content id (default: next free numeric id; only with a single input)")
+	title := fs.String("title", "", "track title (default: input file name; only with a single input)")
+	artist := fs.String("artist", "", "track artist")
+	tags := fs.String("tags", "", "comma separated track tags")
+	catalogKind := fs.String("catalog", string(hls.CatalogJSON), "catalog to register the track in (json, dir, sqlite)")
+	catalogPath := fs.String("catalog-path", "", "index.json for the json catalog (default: index.json under -root)")
+	dbPath := fs.String("db", "", "SQLite database for the sqlite catalog")
+	defaultEncoder := ingest.DefaultFFmpegEncoder()
+	ffmpegPath := fs.String("ffmpeg", defaultEncoder.Path, "ffmpeg binary used to segment audio files")
+	segmentDuration := fs.Int("segment-duration", defaultEncoder.SegmentDuration, "target segment length in seconds")
+	bitrate := fs.String("bitrate", defaultEncoder.Bitrate, "AAC bitrate")
+	_ = fs.Parse(args)
+
+	logger, _ := logging.New(os.Stderr, slog.LevelInfo, logging.FormatText)
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: server ingest [flags] <file or folder>...")
+		return 2
+	}
+	if len(inputs) > 1 && (*id != "" || *title != "") {
+		fmt.Fprintln(os.Stderr, "-id and -title can only be used with a single input")
+		return 2
+	}
+
+	config := ingest.Config{
+		Root: *root,
+		Type: *contentType,
+		Encoder: ingest.FFmpegEncoder{
+			Path:            *ffmpegPath,
+			SegmentDuration: *segmentDuration,
+			Bitrate:         *bitrate,
+			SampleRate:      defaultEncoder.SampleRate,
+		},
+		Logger: logger,
+	}
+	switch *catalogKind {
+	case string(hls.CatalogJSON):
+		path := *catalogPath
+		if path == "" {
+			path = filepath.Join(*root, "index.json")
+		}
+		catalog, err := hls.NewJSONCatalog(path, 0)
+		if err == nil {
+			config.Catalog = catalog
+		}
+		config.Registrar = ingest.NewIndexFile(path)
+	case string(hls.CatalogDir):
+		catalog, err := hls.NewDirCatalog(*root, 0)
+		if err == nil {
+			config.Catalog = catalog
+		}
+	case catalogSQLite:
+		if *dbPath == "" {
+			fmt.Fprintln(os.Stderr, "the sqlite catalog requires -db")
+			return 2
+		}
+		db, err := store.Open(*dbPath, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+		config.Catalog = db
+		config.Registrar = ingest.RegistrarFunc(db.PutTrack)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown catalog kind %q\n", *catalogKind)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var tagList []string
+	if *tags != "" {
+		tagList = strings.Split(*tags, ",")
+	}
+	in := ingest.New(config)
+	status := 0
+	for _, input := range inputs {
+		track, err := in.Ingest(ctx, ingest.Request{
+			Input:  input,
+			ID:     *id,
+			Title:  *title,
+			Artist: *artist,
+			Tags:   tagList,
+		})
+		if err != nil {
+			logger.Error("failed to ingest", "input", input, "error", err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s\t%s\t%ds\n", track.ID, track.Title, track.Length)
+	}
+	return status
+}
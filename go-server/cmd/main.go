@@ -1,17 +1,147 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/furudenipa/hls-radio-server/go-server/internal/auth"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/health"
 	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/icecast"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/listeners"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/logging"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/podcast"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/store"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/urlsign"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "healthcheck":
+			os.Exit(runHealthcheck(os.Args[2:]))
+		case "ingest":
+			os.Exit(runIngest(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		}
+	}
+
+	defaultBuffer := hls.DefaultManagerConfig()
+	highWaterMark := flag.Float64("buffer-high", defaultBuffer.HighWaterMark, "buffered seconds above which the dj waits before queueing the next content")
+	lowWaterMark := flag.Float64("buffer-low", defaultBuffer.LowWaterMark, "buffered seconds the queue drains to before the dj queues again")
+	logLevel := flag.String("log-level", "info", "minimum log level (debug, info, warn, error)")
+	logFormat := flag.String("log-format", string(logging.FormatText), "log output format (text, json)")
+	catalogKind := flag.String("catalog", string(hls.CatalogJSON), "catalog implementation (json, dir, sqlite)")
+	catalogPath := flag.String("catalog-path", "", "index.json for the json catalog or contents root for the dir catalog (default: under /srv/radio/contents)")
+	contentLayout := flag.String("content-layout", hls.LayoutID, "how catalog tracks map to files: id ({type}/{id}/{m3u8}) or path (m3u8 relative to the contents root)")
+	catalogSeed := flag.String("catalog-seed", "", "index.json imported into the sqlite catalog when it is empty")
+	dbPath := flag.String("db", "", "SQLite database for the sqlite catalog and play history (empty disables both)")
+	catalogCompare := flag.String("catalog-compare", "", "index.json whose lengths are checked against the dir catalog at startup")
+	catalogPoll := flag.Duration("catalog-poll", 30*time.Second, "interval for checking the catalog for changes (0 disables)")
+	segmentBaseURL := flag.String("segment-base-url", "", "origin or path prefix prepended to segment URIs, e.g. https://cdn.example.com (empty serves them from /contents)")
+	segmentStripPrefix := flag.String("segment-strip-prefix", "", "prefix removed from segment URIs before -segment-base-url is prepended, e.g. /contents")
+	signingKeys := flag.String("url-signing-keys", "", "JSON keyring for signing segment URLs with expiring tokens (empty disables signing)")
+	defaultSigning := urlsign.DefaultConfig()
+	signingTTL := flag.Duration("url-signing-ttl", defaultSigning.TTL, "lifetime of signed URLs; must cover the segment buffer and the live window")
+	signingSkew := flag.Duration("url-signing-skew", defaultSigning.Skew, "clock difference tolerated when verifying signed URLs")
+	signPlaylists := flag.Bool("sign-playlists", false, "also require a signed URL for the station playlist (see /api/stations/{name}/stream-url)")
+	authConfigPath := flag.String("auth-config", "", "JSON file with API keys, JWT key, admin users and station policies (empty leaves every route open)")
+	encrypt := flag.Bool("encrypt", false, "encrypt published segments with rotating AES-128 keys (requires -url-signing-keys, which keeps the plain /contents URLs and the keys closed)")
+	keyRotation := flag.Int("key-rotation", 10, "segments encrypted with one key before it is rotated")
+	keyDir := flag.String("key-dir", "/srv/radio/keys", "directory the encryption keys are stored in")
+	listenerWindow := flag.Duration("listener-window", time.Minute, "time since the last playlist request after which a listener session ends")
+	listenerLog := flag.String("listener-log", "", "file ended listener sessions are appended to as JSON lines (default: the -db database when set)")
+	dvrWindow := flag.Duration("dvr-window", 2*time.Hour, "how far back listeners can rewind through /stations/proseka/dvr.m3u8 (0 disables)")
+	archiveRetention := flag.Duration("archive-retention", 7*24*time.Hour, "how long published segments are kept in the -db archive for replays (0 keeps them forever)")
+	podcastConfigPath := flag.String("podcast-config", "", "JSON file with the podcast feeds and show schedules of the stations (needs -db for the archive)")
+	podcastDir := flag.String("podcast-dir", "/srv/radio/podcasts", "directory podcast episodes are assembled in")
+	dashManifest := flag.Bool("dash", false, "also serve the live playlist as an MPEG-DASH manifest at /stations/proseka/stream.mpd (only fMP4 contents, whose m3u8 has an EXT-X-MAP, are listed)")
+	icecastStream := flag.Bool("icecast", false, "serve the station as an Icecast compatible AAC stream at /stations/proseka/stream.aac")
+	icecastMaxListeners := flag.Int("icecast-max-listeners", 0, "listeners the Icecast stream accepts at once (0 is unlimited)")
+	preflight := flag.Bool("preflight", true, "inspect the TS segments of every content before queueing it and skip broken ones")
+	preflightTolerance := flag.Float64("preflight-tolerance", hls.DefaultValidationConfig().DurationTolerance, "allowed difference in seconds between EXTINF and the measured segment duration")
+	silenceFiller := flag.Bool("silence-filler", true, "publish generated silence segments when the buffer runs dry")
+	defaultSupervisor := hls.DefaultSupervisorConfig()
+	restartBackoff := flag.Duration("dj-restart-backoff", defaultSupervisor.InitialBackoff, "initial delay before restarting a failed dj")
+	restartMaxBackoff := flag.Duration("dj-restart-max-backoff", defaultSupervisor.MaxBackoff, "maximum delay between dj restarts")
+	fallbackRetry := flag.Duration("dj-fallback-retry", defaultSupervisor.RetryPrimaryAfter, "how often the catalog is tried again while the emergency playlist plays")
+	fallbackAfter := flag.Int("dj-fallback-after", defaultSupervisor.FallbackAfter, "consecutive dj failures before playing the emergency playlist")
+	emergencyPlaylist := flag.String("emergency-playlist", "", "m3u8 relative to the contents root played when the catalog is unusable")
+	maxUpdateAge := flag.Duration("health-max-update-age", 30*time.Second, "playlist staleness after which a station is reported as not ready")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger, err := logging.New(os.Stderr, level, logging.Format(*logFormat))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	var guard *auth.Guard
+	var hasAdmins bool
+	if *authConfigPath != "" {
+		authConfig, err := auth.LoadConfig(*authConfigPath)
+		if err == nil {
+			guard, err = auth.NewGuard(authConfig)
+		}
+		if err != nil {
+			logger.Error("invalid auth config", "error", err)
+			os.Exit(2)
+		}
+		guard.SetLogger(logger)
+		hasAdmins = len(authConfig.Admins) > 0
+	}
+	// listenerOnly applies the station policy; admin protects operator endpoints when admins are configured
+	listenerOnly := func(station string, h http.Handler) http.Handler {
+		if guard == nil {
+			return h
+		}
+		return guard.Station(station, h)
+	}
+	// stationListener applies the policy of the station named in the request path. Unknown
+	// stations are answered with 404 before authentication, so that arbitrary names do not
+	// add realms to the auth metrics.
+	stationNames := []string{"proseka"}
+	stationListener := func(h http.Handler) http.Handler {
+		guarded := make(map[string]http.Handler, len(stationNames))
+		for _, name := range stationNames {
+			guarded[name] = listenerOnly(name, h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g, ok := guarded[r.PathValue("name")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			g.ServeHTTP(w, r)
+		})
+	}
+	admin := func(h http.Handler) http.Handler {
+		if !hasAdmins {
+			return h
+		}
+		return guard.Admin(h)
+	}
+
 	p := hls.NewPlaylist(
 		hls.PlaylistConfig{
 			MaxSegments:    6,
@@ -19,36 +149,369 @@ func main() {
 		},
 	)
 
-	manager := hls.NewPlaylistManager(p)
-	dj := hls.NewProsekaDJ(manager)
-	go dj.Start()
+	managerConfig := hls.ManagerConfig{
+		HighWaterMark: *highWaterMark,
+		LowWaterMark:  *lowWaterMark,
+	}
+	var rewriters hls.URLRewriters
+	if *segmentBaseURL != "" {
+		base, err := hls.NewSegmentURLBase(*segmentBaseURL, *segmentStripPrefix)
+		if err != nil {
+			logger.Error("invalid segment URL base", "error", err)
+			os.Exit(2)
+		}
+		rewriters = append(rewriters, base)
+	}
+	var signer *urlsign.Signer
+	if *signingKeys != "" {
+		keyring, err := urlsign.LoadKeyring(*signingKeys)
+		if err != nil {
+			logger.Error("failed to load url signing keys", "error", err)
+			os.Exit(2)
+		}
+		signer = urlsign.New(keyring, urlsign.Config{TTL: *signingTTL, Skew: *signingSkew})
+		signer.SetLogger(logger)
+		// CDN の URL に書き換えた後のパスに署名する
+		rewriters = append(rewriters, signer)
+	}
+	if len(rewriters) > 0 {
+		managerConfig.URLRewriter = rewriters
+	}
+	const (
+		keysPath     = "/stations/proseka/keys"
+		segmentsPath = "/stations/proseka/segments"
+	)
+	var keys *hls.KeyRotator
+	if *encrypt {
+		// 署名がないと /contents の平文セグメントも鍵も誰でも取れて、暗号化が意味をなさない
+		if signer == nil {
+			logger.Error("-encrypt requires -url-signing-keys: without signed URLs the clear segments under /contents and the keys are open to anyone")
+			os.Exit(2)
+		}
+		if err := os.MkdirAll(*keyDir, 0700); err != nil {
+			logger.Error("failed to create key directory", "error", err)
+			os.Exit(2)
+		}
+		encryption := hls.EncryptionConfig{
+			Station:          "proseka",
+			Storage:          hls.NewFileStorage(hls.DefaultFileSystem{}, *keyDir),
+			RotateEvery:      *keyRotation,
+			KeyURLPrefix:     keysPath,
+			SegmentURLPrefix: segmentsPath,
+			KeyURLRewriter:   signer,
+			// DVR のセグメントが参照する鍵と、署名の有効期限内の URL が使う鍵を残す
+			KeyRetention: max(*dvrWindow, *signingTTL) + time.Hour,
+		}
+		keys, err = hls.NewKeyRotator(encryption)
+		if err != nil {
+			logger.Error("invalid encryption config", "error", err)
+			os.Exit(2)
+		}
+		keys.SetLogger(logger)
+		managerConfig.Encryption = keys
+	}
+	if *preflight {
+		managerConfig.Preflight = hls.NewPreflight(hls.ValidationConfig{DurationTolerance: *preflightTolerance})
+	}
+	const silencePath = "/stations/proseka/silence.ts"
+	var silence []byte
+	if *silenceFiller {
+		var duration float64
+		silence, duration, err = mpegts.GenerateSilence(mpegts.DefaultSilenceConfig())
+		if err != nil {
+			logger.Error("failed to generate silence segment", "error", err)
+			os.Exit(1)
+		}
+		http.HandleFunc(silencePath, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			_, _ = w.Write(silence)
+		})
+		managerConfig.FillerURI = silencePath
+		managerConfig.FillerDuration = duration
+	}
+
+	manager := hls.NewPlaylistManager(p, managerConfig)
+
+	var db *store.Store
+	if *dbPath != "" {
+		db, err = store.Open(*dbPath, *catalogPoll)
+		if err != nil {
+			logger.Error("failed to open database", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	var catalog hls.Catalog
+	if *catalogKind == catalogSQLite {
+		catalog, err = openSQLiteCatalog(db, *catalogSeed, logger)
+	} else {
+		catalog, err = hls.OpenCatalog(hls.CatalogKind(*catalogKind), *catalogPath, *catalogPoll)
+	}
+	if catalog == nil {
+		logger.Error("failed to open catalog", "error", err)
+		os.Exit(2)
+	}
+	if *contentLayout != hls.LayoutID {
+		if err := hls.SetContentLayout(catalog, *contentLayout); err != nil {
+			logger.Error("cannot apply content layout", "layout", *contentLayout, "error", err)
+			os.Exit(2)
+		}
+	}
+	if err != nil {
+		// 読み込みに失敗してもカタログの変更を待つ（healthでnot readyになる）
+		logger.Error("failed to load catalog", "error", err)
+	}
+	if dc, ok := catalog.(*hls.DirCatalog); ok {
+		for _, problem := range dc.Problems() {
+			logger.Warn("skipped content", "error", problem)
+		}
+		if *catalogCompare != "" {
+			reference, err := hls.NewJSONCatalog(*catalogCompare, 0)
+			if err != nil {
+				logger.Error("failed to load reference catalog", "error", err)
+			}
+			for _, m := range hls.CompareCatalogs(reference, dc, 1) {
+				logger.Warn("catalog mismatch", "content_id", m.ID, "reason", m.Reason)
+			}
+		}
+	}
+
+	station := hls.NewStation("proseka", p, manager, hls.NewCatalogDJ(manager, catalog, logger),
+		hls.SupervisorConfig{
+			InitialBackoff:    *restartBackoff,
+			MaxBackoff:        *restartMaxBackoff,
+			FallbackAfter:     *fallbackAfter,
+			EmergencyPlaylist: *emergencyPlaylist,
+			RetryPrimaryAfter: *fallbackRetry,
+		},
+		logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// flushing tracks the goroutines that write their queues to the database or the
+	// listener log once ctx is done
+	var flushing sync.WaitGroup
+	if keys != nil {
+		go keys.Run(ctx)
+	}
+	if db != nil {
+		history := store.NewHistoryRecorder(db, logger)
+		station.Observe(history)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			history.Run(ctx)
+		}()
+		http.Handle("GET /api/stations/{name}/history", stationListener(db.HistoryHandler()))
+
+		archive := store.NewArchiveRecorder(db, *archiveRetention, logger)
+		station.Observe(archive)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			archive.Run(ctx)
+		}()
+		// 再生時に署名し直すので、アーカイブの期限は署名の TTL に縛られない
+		http.Handle("GET /stations/{name}/archive.m3u8", stationListener(db.ArchivePlaylistHandler(rewriters)))
+		http.Handle("GET /api/stations/{name}/archive", stationListener(db.ArchiveIndexHandler()))
+	}
+	if *podcastConfigPath != "" {
+		if db == nil {
+			logger.Error("podcasts are built from the archive, which needs -db")
+			os.Exit(2)
+		}
+		podcastConfig, err := podcast.LoadConfig(*podcastConfigPath)
+		if err != nil {
+			logger.Error("invalid podcast config", "error", err)
+			os.Exit(2)
+		}
+		publisher, err := podcast.NewPublisher(podcastConfig, podcast.PublisherConfig{
+			Archive:   db,
+			Dir:       *podcastDir,
+			Root:      hls.DefaultContentsRoot,
+			URLPrefix: hls.DefaultContentsURLPrefix,
+			Logger:    logger,
+			Private: func(station string) bool {
+				return guard != nil && guard.Policy(station) != auth.PolicyPublic
+			},
+		})
+		if err != nil {
+			logger.Error("invalid podcast config", "error", err)
+			os.Exit(2)
+		}
+		go publisher.Run(ctx)
+		http.Handle("GET /stations/{name}/podcast.xml", stationListener(publisher.FeedHandler()))
+		http.Handle("GET /stations/{name}/episodes/{file}", stationListener(publisher.EpisodeHandler()))
+	}
+	if signer != nil {
+		go signer.WatchKeyring(ctx, *signingKeys, 30*time.Second)
+	}
+
+	trackerConfig := listeners.Config{Window: *listenerWindow, Logger: logger}
+	var sink *listeners.FileSink
+	if *listenerLog != "" {
+		sink, err = listeners.NewFileSink(*listenerLog)
+		if err != nil {
+			logger.Error("failed to open listener log", "error", err)
+			os.Exit(2)
+		}
+		trackerConfig.Sink = sink
+	} else if db != nil {
+		trackerConfig.Sink = db
+		http.Handle("GET /api/stations/{name}/listeners/daily", admin(db.ListenerReportHandler()))
+	}
+	tracker := listeners.NewTracker(trackerConfig)
+	flushing.Add(1)
+	go func() {
+		defer flushing.Done()
+		tracker.Run(ctx)
+	}()
+	http.Handle("GET /api/stations/{name}/listeners", tracker.Handler())
+
+	var dvr *hls.DVR
+	if *dvrWindow > 0 {
+		// the DVR signs its segments again whenever it is rendered, so the window may
+		// outlast -url-signing-ttl
+		dvr = station.EnableDVR(*dvrWindow)
+	}
+
+	var dash *hls.DASH
+	if *dashManifest {
+		dash = station.EnableDASH(nil)
+	}
+
+	var icecastStreamer *icecast.Stream
+	if *icecastStream {
+		icecastStreamer = icecast.NewStream(icecast.Config{
+			Station:      "proseka",
+			Filler:       silence,
+			Tracks:       catalog,
+			MaxListeners: *icecastMaxListeners,
+			Logger:       logger,
+		})
+		station.Observe(icecastStreamer)
+		go icecastStreamer.Run(ctx)
+	}
+
+	go station.Start(ctx)
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-stopChan
-		manager.Kill()
+		cancel()
+		station.Kill()
+		// 終了前に視聴中のセッションと、キューに残った再生履歴・アーカイブを書き出す
+		flushed := make(chan struct{})
+		go func() {
+			flushing.Wait()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-time.After(5 * time.Second):
+			logger.Warn("gave up waiting for queued writes before exiting")
+		}
+		if sink != nil {
+			if err := sink.Close(); err != nil {
+				logger.Error("failed to close listener log", "error", err)
+			}
+		}
+		if db != nil {
+			if err := db.Close(); err != nil {
+				logger.Error("failed to close database", "error", err)
+			}
+		}
 		os.Exit(0)
 	}()
 
-	pFormatter := hls.DefaultPlaylistFormatter{}
+	registry := metrics.NewRegistry()
+	registry.Register(hls.NewStationsCollector(station))
+	registry.Register(tracker)
+	if signer != nil {
+		registry.Register(signer)
+	}
+	if keys != nil {
+		registry.Register(keys)
+	}
+	if icecastStreamer != nil {
+		registry.Register(icecastStreamer)
+	}
+	if guard != nil {
+		registry.Register(guard)
+	}
 
 	// 例: 動作確認用の簡単なエンドポイント
 	http.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "OK")
 	})
 
-	http.HandleFunc("/stations/proseka/stream.m3u8", func(w http.ResponseWriter, r *http.Request) {
-		c, err := pFormatter.Format(p)
-		if err != nil {
-			http.Error(w, "Failed to format playlist", http.StatusInternalServerError)
-			return
+	checker := health.NewChecker(*maxUpdateAge, station)
+	http.Handle("/api/health/live", checker.LiveHandler())
+	http.Handle("/api/health/ready", checker.ReadyHandler())
+
+	http.Handle("/metrics", admin(registry.Handler()))
+
+	const (
+		playlistPath = "/stations/proseka/stream.m3u8"
+		dvrPath      = "/stations/proseka/dvr.m3u8"
+		dashPath     = "/stations/proseka/stream.mpd"
+	)
+	// 認証の内側で数えて、認証済みリスナーを記録する
+	listenerPlaylist := func(h http.Handler) http.Handler {
+		h = tracker.Middleware("proseka", h)
+		// 署名付きURLはstream-urlで認可済みのリスナーにだけ発行される
+		if signer != nil && *signPlaylists {
+			return signer.Middleware(h)
 		}
-		if _, err := w.Write(c.Bytes()); err != nil {
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
-			return
+		return listenerOnly("proseka", h)
+	}
+	http.Handle(playlistPath, listenerPlaylist(station.PlaylistHandler()))
+	if dvr != nil {
+		http.Handle(dvrPath, listenerPlaylist(dvr.Handler()))
+	}
+	if dash != nil {
+		http.Handle(dashPath, listenerPlaylist(dash.Handler()))
+	}
+	if icecastStreamer != nil {
+		// 車載機などはヘッダーを付けられないので、鍵は api_key で渡す
+		http.Handle("GET /stations/proseka/stream.aac", listenerOnly("proseka", icecastStreamer.Handler()))
+	}
+
+	if keys != nil {
+		// 鍵とセグメントの URL は署名付きでしか発行されない
+		http.Handle("GET "+keysPath+"/{kid}", signer.Middleware(keys.KeyHandler()))
+		http.Handle("GET "+segmentsPath+"/{kid}/{path...}", signer.Middleware(keys.SegmentHandler()))
+	}
+
+	// nginx の auth_request から呼ばれる。署名が無効なら常に許可する
+	if signer != nil {
+		http.Handle("/api/auth/segment", signer.AuthRequestHandler())
+	} else {
+		http.HandleFunc("/api/auth/segment", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	http.Handle("GET /api/stations/proseka/stream-url", listenerOnly("proseka", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urls := map[string]string{"url": playlistPath}
+		if dvr != nil {
+			urls["dvr_url"] = dvrPath
 		}
-	})
+		if dash != nil {
+			urls["dash_url"] = dashPath
+		}
+		if signer != nil && *signPlaylists {
+			for k, u := range urls {
+				urls[k] = signer.RewriteURL(u)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(urls)
+	})))
 
-	fmt.Println("Go server listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	logger.Info("Go server listening", "addr", ":8080")
+	if err := http.ListenAndServe(":8080", nil); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
 }
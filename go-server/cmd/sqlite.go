@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/store"
+)
+
+// catalogSQLite is handled here rather than by hls.OpenCatalog because the store package depends on hls
+const catalogSQLite = "sqlite"
+
+// openSQLiteCatalog uses db as the catalog, importing seedPath first if the catalog is empty
+func openSQLiteCatalog(db *store.Store, seedPath string, logger *slog.Logger) (hls.Catalog, error) {
+	if db == nil {
+		return nil, errors.New("the sqlite catalog requires -db")
+	}
+	if seedPath == "" || len(db.List()) > 0 {
+		return db, db.Err()
+	}
+
+	seed, err := hls.NewJSONCatalog(seedPath, 0)
+	if err != nil {
+		return db, fmt.Errorf("failed to load catalog seed: %w", err)
+	}
+	tracks := seed.List()
+	if err := db.ImportTracks(context.Background(), tracks); err != nil {
+		return db, err
+	}
+	logger.Info("imported catalog seed", "path", seedPath, "tracks", len(tracks))
+	return db, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+// runValidate inspects every m3u8 under the contents root and its TS segments:
+//
+//	server validate [flags] [m3u8...]
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	root := fs.String("root", hls.DefaultContentsRoot, "contents root to validate when no m3u8 is given")
+	tolerance := fs.Float64("tolerance", hls.DefaultValidationConfig().DurationTolerance, "allowed difference in seconds between EXTINF and the measured PTS duration")
+	verbose := fs.Bool("v", false, "print every segment, not only broken ones")
+	_ = fs.Parse(args)
+
+	config := hls.ValidationConfig{DurationTolerance: *tolerance}
+	var results []hls.PlaylistValidation
+	if fs.NArg() > 0 {
+		for _, path := range fs.Args() {
+			results = append(results, hls.ValidateMediaPlaylist(path, config))
+		}
+	} else {
+		var err error
+		results, err = hls.ValidateTree(*root, config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if err := r.Err(); err != nil {
+			failed++
+			fmt.Printf("FAIL %s\n", r.Path)
+			for _, p := range r.Problems {
+				fmt.Printf("  %v\n", p)
+			}
+			for _, seg := range r.Segments {
+				for _, p := range seg.Problems {
+					fmt.Printf("  %s: %v\n", seg.URI, p)
+				}
+			}
+			continue
+		}
+		fmt.Printf("ok   %s\n", r.Path)
+		if *verbose {
+			for _, seg := range r.Segments {
+				fmt.Printf("  %s: extinf %.3fs, measured %.3fs\n", seg.URI, seg.Extinf, seg.Measured)
+			}
+		}
+	}
+	fmt.Printf("%d playlists, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials means the request carries no credentials the authenticator understands
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means the request carries credentials that were rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Roles granted to principals
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject string
+	// Method is how the caller authenticated: "api_key", "jwt" or "basic"
+	Method string
+	Roles  []string
+}
+
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Authenticator finds and checks the credentials of a request. It returns
+// ErrNoCredentials when the request has none of its kind, so that authenticators can
+// be chained.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Chain tries authenticators in order; the first that finds credentials decides
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal a Guard attached to the request context
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or of the token
+// query parameter for players that cannot set headers
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
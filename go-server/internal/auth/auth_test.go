@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("0123456789abcdef0123456789abcdef")
+	testNow    = time.Unix(1_700_000_000, 0)
+)
+
+func signHS256(t *testing.T, header string, claims map[string]any) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerifier(t *testing.T) {
+	v, err := NewJWTVerifier(testSecret, JWTConfig{Issuer: "app", Audience: "radio", Now: func() time.Time { return testNow }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	valid := map[string]any{"sub": "u1", "iss": "app", "aud": []string{"radio", "web"}, "exp": testNow.Add(time.Hour).Unix(), "roles": []string{RoleMember}}
+	with := func(key string, value any) map[string]any {
+		claims := make(map[string]any)
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: signHS256(t, hs256, valid)},
+		{name: "string audience", token: signHS256(t, hs256, with("aud", "radio"))},
+		{name: "expired within leeway", token: signHS256(t, hs256, with("exp", testNow.Add(-10*time.Second).Unix()))},
+		{name: "expired", token: signHS256(t, hs256, with("exp", testNow.Add(-time.Minute).Unix())), wantErr: true},
+		{name: "no exp", token: signHS256(t, hs256, with("exp", nil)), wantErr: true},
+		{name: "not yet valid", token: signHS256(t, hs256, with("nbf", testNow.Add(time.Minute).Unix())), wantErr: true},
+		{name: "other issuer", token: signHS256(t, hs256, with("iss", "evil")), wantErr: true},
+		{name: "other audience", token: signHS256(t, hs256, with("aud", "tv")), wantErr: true},
+		{name: "alg none", token: strings.Join(strings.Split(signHS256(t, `{"alg":"none"}`, valid), ".")[:2], ".") + ".", wantErr: true},
+		{name: "tampered", token: strings.Replace(signHS256(t, hs256, valid), ".", ".eyJzdWIiOiJ4In0", 1), wantErr: true},
+		{name: "malformed", token: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != "u1" {
+				t.Errorf("Subject = %q, want u1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestJWTVerifier_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewJWTVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), JWTConfig{Now: func() time.Time { return testNow }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, _ := json.Marshal(map[string]any{"sub": "u2", "exp": testNow.Add(time.Hour).Unix()})
+	input := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(input + "." + base64.RawURLEncoding.EncodeToString(sig)); err != nil {
+		t.Errorf("Verify(RS256) error = %v", err)
+	}
+	// HS256 signed with the public key must not be accepted (algorithm confusion)
+	if _, err := v.Verify(signHS256(t, `{"alg":"HS256"}`, map[string]any{"sub": "u2", "exp": testNow.Add(time.Hour).Unix()})); err == nil {
+		t.Error("Verify(HS256) succeeded with an RSA key")
+	}
+}
+
+func TestGuard(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "jwt.key"), testSecret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	adminHash := sha256.Sum256([]byte("s3cret"))
+	config := Config{
+		APIKeys: []APIKey{
+			{Key: "member-key-0123456789", Subject: "ios"},
+			{Key: "guest-key-0123456789", Subject: "guest", Roles: []string{"guest"}},
+		},
+		JWTKeyFile: filepath.Join(dir, "jwt.key"),
+		Admins:     map[string]string{"admin": "sha256:" + hex.EncodeToString(adminHash[:])},
+		Stations:   map[string]Policy{"members": PolicyMembers},
+	}
+	g, err := NewGuard(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subject string
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFrom(r.Context())
+		subject = p.Subject
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/public", g.Station("public", ok))
+	mux.Handle("/members", g.Station("members", ok))
+	mux.Handle("/admin", g.Admin(ok))
+
+	memberJWT := signHS256(t, `{"alg":"HS256"}`, map[string]any{"sub": "u1", "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{RoleMember}})
+	tests := []struct {
+		name        string
+		target      string
+		header      http.Header
+		basic       [2]string
+		wantCode    int
+		wantSubject string
+	}{
+		{name: "public without credentials", target: "/public", wantCode: http.StatusOK},
+		{name: "public keeps the principal", target: "/public?api_key=member-key-0123456789", wantCode: http.StatusOK, wantSubject: "ios"},
+		{name: "members without credentials", target: "/members", wantCode: http.StatusUnauthorized},
+		{name: "members with api key header", target: "/members", header: http.Header{"X-Api-Key": {"member-key-0123456789"}}, wantCode: http.StatusOK, wantSubject: "ios"},
+		{name: "members with api key query", target: "/members?api_key=member-key-0123456789", wantCode: http.StatusOK, wantSubject: "ios"},
+		{name: "members with unknown key", target: "/members?api_key=nope", wantCode: http.StatusUnauthorized},
+		{name: "members with guest key", target: "/members?api_key=guest-key-0123456789", wantCode: http.StatusForbidden},
+		{name: "members with jwt", target: "/members", header: http.Header{"Authorization": {"Bearer " + memberJWT}}, wantCode: http.StatusOK, wantSubject: "u1"},
+		{name: "members with jwt query", target: "/members?token=" + memberJWT, wantCode: http.StatusOK, wantSubject: "u1"},
+		{name: "admin without credentials", target: "/admin", wantCode: http.StatusUnauthorized},
+		{name: "admin with wrong password", target: "/admin", basic: [2]string{"admin", "nope"}, wantCode: http.StatusUnauthorized},
+		{name: "admin with unknown user", target: "/admin", basic: [2]string{"root", "s3cret"}, wantCode: http.StatusUnauthorized},
+		{name: "admin with listener key", target: "/admin?api_key=member-key-0123456789", wantCode: http.StatusUnauthorized},
+		{name: "admin", target: "/admin", basic: [2]string{"admin", "s3cret"}, wantCode: http.StatusOK, wantSubject: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject = ""
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if tt.basic[0] != "" {
+				req.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("principal = %q, want %q", subject, tt.wantSubject)
+			}
+		})
+	}
+
+	counts := map[string]float64{}
+	for _, m := range g.Collect() {
+		for _, s := range m.Samples {
+			counts[s.Labels[0].Value+"/"+s.Labels[1].Value] = s.Value
+		}
+	}
+	want := map[string]float64{
+		"public/ok": 2, "members/ok": 4, "members/unauthorized": 2, "members/forbidden": 1,
+		"admin/ok": 1, "admin/unauthorized": 4,
+	}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Errorf("auth requests %s = %v, want %v", k, counts[k], v)
+		}
+	}
+}
+
+func TestNewGuard_InvalidConfig(t *testing.T) {
+	for name, config := range map[string]Config{
+		"short api key":   {APIKeys: []APIKey{{Key: "short", Subject: "x"}}},
+		"plain password":  {Admins: map[string]string{"admin": "password"}},
+		"unknown policy":  {Stations: map[string]Policy{"proseka": "vip"}},
+		"missing jwt key": {JWTKeyFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := NewGuard(config); err == nil {
+			t.Errorf("%s: NewGuard() succeeded", name)
+		}
+	}
+}
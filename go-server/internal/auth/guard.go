@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+)
+
+// Policy decides who may listen to a station
+type Policy string
+
+const (
+	// PolicyPublic lets anyone listen
+	PolicyPublic Policy = "public"
+	// PolicyMembers requires an API key or JWT with the member role
+	PolicyMembers Policy = "members"
+)
+
+// Config is the auth configuration file
+type Config struct {
+	APIKeys []APIKey `json:"api_keys,omitempty"`
+	// JWTKeyFile is an HMAC secret or PEM encoded RSA public key; relative paths are
+	// resolved against the directory of the config file. Empty disables JWTs.
+	JWTKeyFile string    `json:"jwt_key_file,omitempty"`
+	JWT        JWTConfig `json:"jwt"`
+	// Admins maps user names to "sha256:<hex digest>" passwords for the admin routes
+	Admins map[string]string `json:"admins,omitempty"`
+	// Stations maps station names to their policy; DefaultPolicy applies to the others
+	Stations      map[string]Policy `json:"stations,omitempty"`
+	DefaultPolicy Policy            `json:"default_policy,omitempty"`
+}
+
+// LoadConfig reads a Config from a JSON file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read auth config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse auth config %s: %w", path, err)
+	}
+	if config.JWTKeyFile != "" && !filepath.IsAbs(config.JWTKeyFile) {
+		config.JWTKeyFile = filepath.Join(filepath.Dir(path), config.JWTKeyFile)
+	}
+	return config, nil
+}
+
+type result int
+
+const (
+	resultOK result = iota
+	resultUnauthorized
+	resultForbidden
+	numResults
+)
+
+var resultNames = [numResults]string{"ok", "unauthorized", "forbidden"}
+
+// Guard applies station policies and admin authentication to routes
+type Guard struct {
+	listeners     Authenticator
+	admins        Authenticator
+	policies      map[string]Policy
+	defaultPolicy Policy
+	logger        *slog.Logger
+
+	mu       sync.Mutex
+	requests map[string]*[numResults]atomic.Int64 // by realm
+}
+
+func NewGuard(config Config) (*Guard, error) {
+	var listeners Chain
+	if len(config.APIKeys) > 0 {
+		keys, err := NewAPIKeys(config.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, keys)
+	}
+	if config.JWTKeyFile != "" {
+		key, err := os.ReadFile(config.JWTKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key: %w", err)
+		}
+		verifier, err := NewJWTVerifier(key, config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, verifier)
+	}
+	admins, err := NewBasicAuth(config.Admins)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DefaultPolicy == "" {
+		config.DefaultPolicy = PolicyPublic
+	}
+	for station, policy := range config.Stations {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("station %s: %w", station, err)
+		}
+	}
+	if err := config.DefaultPolicy.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Guard{
+		listeners:     listeners,
+		admins:        admins,
+		policies:      config.Stations,
+		defaultPolicy: config.DefaultPolicy,
+		logger:        slog.Default(),
+		requests:      make(map[string]*[numResults]atomic.Int64),
+	}, nil
+}
+
+func (p Policy) validate() error {
+	switch p {
+	case PolicyPublic, PolicyMembers:
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q", p)
+	}
+}
+
+func (g *Guard) SetLogger(logger *slog.Logger) {
+	g.logger = logger
+}
+
+// Policy returns the policy of a station
+func (g *Guard) Policy(station string) Policy {
+	if p, ok := g.policies[station]; ok {
+		return p
+	}
+	return g.defaultPolicy
+}
+
+// Station enforces the policy of station on next. Authenticated principals are attached
+// to the request context (see PrincipalFrom), also on public stations.
+func (g *Guard) Station(station string, next http.Handler) http.Handler {
+	counts := g.counter(station)
+	challenge := fmt.Sprintf("Bearer realm=%q", station)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := g.listeners.Authenticate(r)
+		if g.Policy(station) == PolicyPublic {
+			if err == nil {
+				r = r.WithContext(WithPrincipal(r.Context(), p))
+			}
+			counts[resultOK].Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		g.serve(w, r, station, counts, challenge, p, err, RoleMember, next)
+	})
+}
+
+// Admin requires HTTP basic authentication of a configured admin on next
+func (g *Guard) Admin(next http.Handler) http.Handler {
+	counts := g.counter(RoleAdmin)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := g.admins.Authenticate(r)
+		g.serve(w, r, RoleAdmin, counts, `Basic realm="admin"`, p, err, RoleAdmin, next)
+	})
+}
+
+// serve answers 401 when authentication failed and 403 when p lacks role
+func (g *Guard) serve(w http.ResponseWriter, r *http.Request, realm string, counts *[numResults]atomic.Int64,
+	challenge string, p Principal, err error, role string, next http.Handler) {
+	switch {
+	case err != nil:
+		counts[resultUnauthorized].Add(1)
+		if !errors.Is(err, ErrNoCredentials) {
+			g.logger.Info("rejected credentials", "realm", realm, "path", r.URL.Path, "error", err)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case !p.HasRole(role):
+		counts[resultForbidden].Add(1)
+		g.logger.Info("forbidden", "realm", realm, "path", r.URL.Path, "subject", p.Subject, "method", p.Method)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		counts[resultOK].Add(1)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+}
+
+func (g *Guard) counter(realm string) *[numResults]atomic.Int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, ok := g.requests[realm]
+	if !ok {
+		c = new([numResults]atomic.Int64)
+		g.requests[realm] = c
+	}
+	return c
+}
+
+// Collect implements metrics.Collector
+func (g *Guard) Collect() []metrics.Metric {
+	g.mu.Lock()
+	realms := make([]string, 0, len(g.requests))
+	for realm := range g.requests {
+		realms = append(realms, realm)
+	}
+	g.mu.Unlock()
+	sort.Strings(realms)
+
+	requests := metrics.Metric{Name: "hlsradio_auth_requests_total", Help: "Requests to guarded routes by realm (station or admin) and result.", Type: metrics.TypeCounter}
+	for _, realm := range realms {
+		counts := g.counter(realm)
+		for r := result(0); r < numResults; r++ {
+			requests.Samples = append(requests.Samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "realm", Value: realm}, {Name: "result", Value: resultNames[r]}},
+				Value:  float64(counts[r].Load()),
+			})
+		}
+	}
+	return []metrics.Metric{requests}
+}
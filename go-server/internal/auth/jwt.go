@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultJWTLeeway = 30 * time.Second
+
+// JWTConfig configures JWTVerifier
+type JWTConfig struct {
+	// Issuer and Audience are checked against iss and aud when set
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	// Leeway is the clock difference tolerated for exp and nbf; defaultJWTLeeway when zero
+	Leeway time.Duration `json:"-"`
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time `json:"-"`
+}
+
+// JWTVerifier authenticates listeners by JWTs issued by our app backend, verified with a
+// local key: an HMAC secret (HS256) or a PEM encoded RSA public key (RS256). Tokens are
+// read from "Authorization: Bearer" or the token query parameter.
+type JWTVerifier struct {
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+	config JWTConfig
+}
+
+// NewJWTVerifier uses key as an RSA public key when it is PEM encoded and as an HMAC
+// secret otherwise
+func NewJWTVerifier(key []byte, config JWTConfig) (*JWTVerifier, error) {
+	if config.Leeway == 0 {
+		config.Leeway = defaultJWTLeeway
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	if block, _ := pem.Decode(key); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+		}
+		public, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("JWT public key must be RSA, got %T", parsed)
+		}
+		return &JWTVerifier{alg: "RS256", public: public, config: config}, nil
+	}
+	if len(key) < 32 {
+		return nil, errors.New("JWT HMAC secret must be at least 32 bytes")
+	}
+	return &JWTVerifier{alg: "HS256", secret: key, config: config}, nil
+}
+
+// Claims are the registered and custom claims we read from a token
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	Roles     []string `json:"roles"`
+}
+
+// audience accepts both forms of the aud claim: a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{Subject: claims.Subject, Method: "jwt", Roles: claims.Roles}, nil
+}
+
+// Verify checks the signature and the time, issuer and audience claims of token
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	// 鍵の種類で決まるアルゴリズム以外（none を含む）は受け付けない
+	if header.Alg != v.alg {
+		return Claims{}, fmt.Errorf("%w: unexpected alg %q", ErrInvalidCredentials, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+	if err := v.verifySignature(parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	now := v.config.Now()
+	switch {
+	case claims.ExpiresAt == nil:
+		return Claims{}, fmt.Errorf("%w: token has no exp", ErrInvalidCredentials)
+	case now.After(unixTime(*claims.ExpiresAt).Add(v.config.Leeway)):
+		return Claims{}, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	case claims.NotBefore != nil && now.Add(v.config.Leeway).Before(unixTime(*claims.NotBefore)):
+		return Claims{}, fmt.Errorf("%w: token not valid yet", ErrInvalidCredentials)
+	case v.config.Issuer != "" && claims.Issuer != v.config.Issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidCredentials, claims.Issuer)
+	case v.config.Audience != "" && !slices.Contains(claims.Audience, v.config.Audience):
+		return Claims{}, fmt.Errorf("%w: token is not for %q", ErrInvalidCredentials, v.config.Audience)
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(signingInput string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch v.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+		}
+	case "RS256":
+		if err := rsa.VerifyPKCS1v15(v.public, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token: %v", ErrInvalidCredentials, err)
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
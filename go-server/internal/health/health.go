@@ -0,0 +1,149 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+// StationReport is the health of a single station as served over JSON
+type StationReport struct {
+	Name                   string     `json:"name"`
+	Status                 string     `json:"status"`
+	BufferedSeconds        float64    `json:"buffered_seconds"`
+	Underrun               bool       `json:"underrun"`
+	SecondsSinceLastUpdate *float64   `json:"seconds_since_last_update"` // null until the first update
+	CatalogSize            int        `json:"catalog_size"`
+	DJRunning              bool       `json:"dj_running"`
+	DJRestarts             int64      `json:"dj_restarts"`
+	DJFallback             bool       `json:"dj_fallback"`
+	LastDJError            string     `json:"last_dj_error,omitempty"`
+	LastDJErrorAt          *time.Time `json:"last_dj_error_at,omitempty"`
+	Ready                  bool       `json:"ready"`
+	Problems               []string   `json:"problems,omitempty"`
+}
+
+// Report is the health of all stations
+type Report struct {
+	Status   string          `json:"status"`
+	Stations []StationReport `json:"stations"`
+}
+
+// Checker evaluates station health; a station is starving when its playlist
+// has not advanced, or has only advanced with filler, for longer than maxUpdateAge
+type Checker struct {
+	stations     []*hls.Station
+	maxUpdateAge time.Duration
+	now          func() time.Time
+}
+
+func NewChecker(maxUpdateAge time.Duration, stations ...*hls.Station) *Checker {
+	return &Checker{
+		stations:     stations,
+		maxUpdateAge: maxUpdateAge,
+		now:          time.Now,
+	}
+}
+
+// Check returns the health of every station and whether all of them are ready
+func (c *Checker) Check() (Report, bool) {
+	now := c.now()
+	allReady := true
+	report := Report{Stations: make([]StationReport, 0, len(c.stations))}
+	for _, s := range c.stations {
+		sr := evaluate(s.Stats(), now, c.maxUpdateAge)
+		allReady = allReady && sr.Ready
+		report.Stations = append(report.Stations, sr)
+	}
+	return report, allReady
+}
+
+// LiveHandler reports station health but only fails if the process cannot answer at all
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, _ := c.Check()
+		report.Status = "ok"
+		writeReport(w, http.StatusOK, report)
+	})
+}
+
+// ReadyHandler responds 503 when any station is not ready to serve listeners
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ready := c.Check()
+		if !ready {
+			report.Status = "unavailable"
+			writeReport(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		report.Status = "ready"
+		writeReport(w, http.StatusOK, report)
+	})
+}
+
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Failed to encode health report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func evaluate(stats hls.StationStats, now time.Time, maxUpdateAge time.Duration) StationReport {
+	sr := StationReport{
+		Name:            stats.Name,
+		Status:          stats.Manager.Status.String(),
+		BufferedSeconds: stats.Manager.BufferedSeconds,
+		Underrun:        !stats.Manager.UnderrunSince.IsZero(),
+		CatalogSize:     stats.CatalogSize,
+		DJRunning:       stats.DJRunning,
+		DJRestarts:      stats.DJRestarts,
+		DJFallback:      stats.DJFallback,
+	}
+	if stats.LastDJError != nil {
+		sr.LastDJError = stats.LastDJError.Error()
+		at := stats.LastDJErrorAt
+		sr.LastDJErrorAt = &at
+	}
+	if !stats.LastUpdate.IsZero() {
+		since := now.Sub(stats.LastUpdate).Seconds()
+		sr.SecondsSinceLastUpdate = &since
+	}
+
+	if stats.Manager.Status != hls.StatusStreaming {
+		sr.Problems = append(sr.Problems, fmt.Sprintf("manager is %s", stats.Manager.Status))
+	}
+	if !stats.DJRunning {
+		sr.Problems = append(sr.Problems, "dj is not running")
+	}
+	if stats.CatalogSize == 0 {
+		sr.Problems = append(sr.Problems, "catalog is empty")
+	}
+
+	// 一度も更新されていなければ起動時刻から数える
+	lastProgress := stats.LastUpdate
+	if lastProgress.IsZero() {
+		lastProgress = stats.StartedAt
+	}
+	if lastProgress.IsZero() {
+		sr.Problems = append(sr.Problems, "station has not been started")
+	} else if age := now.Sub(lastProgress); age > maxUpdateAge {
+		sr.Problems = append(sr.Problems, fmt.Sprintf("playlist has not advanced for %.0fs", age.Seconds()))
+	}
+	// 無音のフィラーでも LastUpdate は進むので、曲間の一瞬の枯渇は許してそれ以上続いたら不調とする
+	if since := stats.Manager.UnderrunSince; !since.IsZero() {
+		if age := now.Sub(since); age > maxUpdateAge {
+			sr.Problems = append(sr.Problems, fmt.Sprintf("only filler has aired for %.0fs", age.Seconds()))
+		}
+	}
+
+	sr.Ready = len(sr.Problems) == 0
+	return sr
+}
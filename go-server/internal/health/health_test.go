@@ -0,0 +1,115 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	healthy := hls.StationStats{
+		Name:        "proseka",
+		Manager:     hls.ManagerStats{Status: hls.StatusStreaming, BufferedSeconds: 80},
+		LastUpdate:  now.Add(-5 * time.Second),
+		StartedAt:   now.Add(-time.Hour),
+		CatalogSize: 10,
+		DJRunning:   true,
+	}
+
+	tests := []struct {
+		name         string
+		modify       func(*hls.StationStats)
+		wantReady    bool
+		wantProblems int
+	}{
+		{
+			name:      "healthy station",
+			modify:    func(s *hls.StationStats) {},
+			wantReady: true,
+		},
+		{
+			name: "dj exited with empty catalog",
+			modify: func(s *hls.StationStats) {
+				s.DJRunning = false
+				s.CatalogSize = 0
+				s.LastDJError = errors.New("contents is empty")
+				s.LastDJErrorAt = now.Add(-time.Minute)
+			},
+			wantReady:    false,
+			wantProblems: 2,
+		},
+		{
+			name: "starving playlist",
+			modify: func(s *hls.StationStats) {
+				s.LastUpdate = now.Add(-2 * time.Minute)
+			},
+			wantReady:    false,
+			wantProblems: 1,
+		},
+		{
+			name: "airing only filler",
+			modify: func(s *hls.StationStats) {
+				// filler keeps the playlist advancing
+				s.Manager.UnderrunSince = now.Add(-2 * time.Minute)
+			},
+			wantReady:    false,
+			wantProblems: 1,
+		},
+		{
+			name: "filler between contents",
+			modify: func(s *hls.StationStats) {
+				s.Manager.UnderrunSince = now.Add(-5 * time.Second)
+			},
+			wantReady: true,
+		},
+		{
+			name: "killed manager",
+			modify: func(s *hls.StationStats) {
+				s.Manager.Status = hls.StatusKilled
+			},
+			wantReady:    false,
+			wantProblems: 1,
+		},
+		{
+			name: "just started without updates",
+			modify: func(s *hls.StationStats) {
+				s.LastUpdate = time.Time{}
+				s.StartedAt = now.Add(-time.Second)
+			},
+			wantReady: true,
+		},
+		{
+			name: "never started",
+			modify: func(s *hls.StationStats) {
+				s.LastUpdate = time.Time{}
+				s.StartedAt = time.Time{}
+			},
+			wantReady:    false,
+			wantProblems: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := healthy
+			tt.modify(&stats)
+
+			got := evaluate(stats, now, 30*time.Second)
+			if got.Ready != tt.wantReady {
+				t.Errorf("Ready = %v, want %v (problems: %v)", got.Ready, tt.wantReady, got.Problems)
+			}
+			if len(got.Problems) != tt.wantProblems {
+				t.Errorf("problems = %v, want %d", got.Problems, tt.wantProblems)
+			}
+			if stats.LastDJError != nil && got.LastDJError != stats.LastDJError.Error() {
+				t.Errorf("LastDJError = %q, want %q", got.LastDJError, stats.LastDJError.Error())
+			}
+			if stats.LastUpdate.IsZero() != (got.SecondsSinceLastUpdate == nil) {
+				t.Errorf("SecondsSinceLastUpdate = %v, want nil only when never updated", got.SecondsSinceLastUpdate)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTrackNotFound = errors.New("track not found")
+
+// Catalog is a source of tracks that a dj can choose from
+type Catalog interface {
+	List() []Track
+	Get(id string) (Track, error)
+	// Search returns tracks whose ID, title or artist contains query (case-insensitive)
+	Search(query string) []Track
+	// Watch returns a channel that receives a value whenever the catalog changes.
+	// The channel is closed when ctx is done.
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// reloadableCatalog is implemented by catalogs loaded from storage that may fail to load
+type reloadableCatalog interface {
+	Catalog
+	Reload() error
+	// Err returns the error of the last load, or nil if it succeeded
+	Err() error
+}
+
+// =======================
+// == gpt 4o no copy-pe ==
+// =======================
+type Track struct {
+	ID     string   `json:"id"`
+	Title  string   `json:"title"`
+	Length int      `json:"length"`
+	Artist string   `json:"artist"`
+	M3U8   string   `json:"m3u8"`
+	Type   string   `json:"type,omitempty"` // content type directory; "music" when empty
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func (t Track) contentType() ContentType {
+	if t.Type == "" {
+		return audio
+	}
+	return ContentType(t.Type)
+}
+
+// newContentFromTrack converts a catalog track into a playable content laid out by formatter
+func newContentFromTrack(t Track, formatter ContentFormatter) (content, error) {
+	if err := checkPathElement("content id", t.ID); err != nil {
+		return content{}, err
+	}
+	// Type も root/type/id のディレクトリ名になる
+	if err := checkPathElement("content type", string(t.contentType())); err != nil {
+		return content{}, err
+	}
+	return content{
+		id:          t.ID,
+		contentType: t.contentType(),
+		m3u8:        t.M3U8,
+		isTmp:       false,
+		length:      t.Length,
+		formatter:   formatter,
+	}, nil
+}
+
+// checkPathElement rejects values that cannot be used as a single path element
+func checkPathElement(what string, s string) error {
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, "/\\") {
+		return fmt.Errorf("invalid %s %q", what, s)
+	}
+	return nil
+}
+
+// trackSet is the shared, concurrency-safe storage behind catalog implementations
+type trackSet struct {
+	mu        sync.RWMutex
+	tracks    []Track
+	byID      map[string]int
+	formatter ContentFormatter
+}
+
+// SetFormatter sets how the tracks of this catalog are resolved to files and URLs
+func (s *trackSet) SetFormatter(f ContentFormatter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.formatter = f
+}
+
+// Formatter returns the formatter set with SetFormatter, or DefaultContentFormatter
+func (s *trackSet) Formatter() ContentFormatter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.formatter == nil {
+		return DefaultContentFormatter{}
+	}
+	return s.formatter
+}
+
+// replace swaps in tracks and reports whether anything changed
+func (s *trackSet) replace(tracks []Track) bool {
+	byID := make(map[string]int, len(tracks))
+	for i, t := range tracks {
+		byID[t.ID] = i
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := !equalTracks(s.tracks, tracks)
+	s.tracks = tracks
+	s.byID = byID
+	return changed
+}
+
+func (s *trackSet) List() []Track {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tracks := make([]Track, len(s.tracks))
+	copy(tracks, s.tracks)
+	return tracks
+}
+
+func (s *trackSet) Get(id string) (Track, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byID[id]
+	if !ok {
+		return Track{}, fmt.Errorf("%w: %s", ErrTrackNotFound, id)
+	}
+	return s.tracks[i], nil
+}
+
+func (s *trackSet) Search(query string) []Track {
+	query = strings.ToLower(query)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found []Track
+	for _, t := range s.tracks {
+		if strings.Contains(strings.ToLower(t.ID), query) ||
+			strings.Contains(strings.ToLower(t.Title), query) ||
+			strings.Contains(strings.ToLower(t.Artist), query) {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+func equalTracks(a, b []Track) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Title != b[i].Title || a[i].Length != b[i].Length ||
+			a[i].Artist != b[i].Artist || a[i].M3U8 != b[i].M3U8 || a[i].Type != b[i].Type ||
+			!slices.Equal(a[i].Tags, b[i].Tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortTracks orders tracks by ID so that scans are deterministic
+func sortTracks(tracks []Track) {
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].ID < tracks[j].ID })
+}
+
+// watchers fans out change notifications to Watch subscribers. When poll is set, it is
+// called every interval by a single goroutine while there are subscribers, however many
+// there are.
+type watchers struct {
+	interval time.Duration
+	poll     func()
+
+	mu       sync.Mutex
+	chans    map[chan struct{}]struct{}
+	stopPoll context.CancelFunc // nil while nobody polls
+}
+
+func (w *watchers) subscribe(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	if w.chans == nil {
+		w.chans = make(map[chan struct{}]struct{})
+	}
+	w.chans[ch] = struct{}{}
+	if w.poll != nil && w.interval > 0 && w.stopPoll == nil {
+		var pollCtx context.Context
+		pollCtx, w.stopPoll = context.WithCancel(context.Background())
+		go pollUntilDone(pollCtx, w.interval, w.poll)
+	}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		delete(w.chans, ch)
+		close(ch)
+		// 最後の購読者がいなくなったらポーリングも止める
+		if len(w.chans) == 0 && w.stopPoll != nil {
+			w.stopPoll()
+			w.stopPoll = nil
+		}
+		w.mu.Unlock()
+	}()
+	return ch
+}
+
+func (w *watchers) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.chans {
+		select {
+		case ch <- struct{}{}:
+		default: // 未処理の通知が残っていれば十分
+		}
+	}
+}
+
+// formattedCatalog is implemented by catalogs that carry their own ContentFormatter
+type formattedCatalog interface {
+	Formatter() ContentFormatter
+}
+
+// catalogFormatter returns the formatter of catalog, or DefaultContentFormatter
+func catalogFormatter(catalog Catalog) ContentFormatter {
+	if fc, ok := catalog.(formattedCatalog); ok {
+		if f := fc.Formatter(); f != nil {
+			return f
+		}
+	}
+	return DefaultContentFormatter{}
+}
+
+// SetContentLayout makes catalog resolve its tracks with the formatter of layout (see
+// NewContentFormatter). Files stay under the root the catalog resolved them under, e.g.
+// the scan root of a DirCatalog.
+func SetContentLayout(catalog Catalog, layout string) error {
+	fc, ok := catalog.(interface{ SetFormatter(ContentFormatter) })
+	if !ok {
+		return fmt.Errorf("catalog %T does not support content layouts", catalog)
+	}
+	var root string
+	switch f := catalogFormatter(catalog).(type) {
+	case DefaultContentFormatter:
+		root = f.Root
+	case PathContentFormatter:
+		root = f.Root
+	}
+	formatter, err := NewContentFormatter(layout, root)
+	if err != nil {
+		return err
+	}
+	fc.SetFormatter(formatter)
+	return nil
+}
+
+// catalogLogic chooses a random playable track from a catalog on every call,
+// so catalog changes are picked up without restarting the dj
+type catalogLogic struct {
+	catalog Catalog
+}
+
+func (l catalogLogic) Choice() (Content, error) {
+	tracks := l.catalog.List()
+	if len(tracks) == 0 {
+		if rc, ok := l.catalog.(reloadableCatalog); ok && rc.Err() != nil {
+			return nil, fmt.Errorf("contents is empty: %w", rc.Err())
+		}
+		return nil, fmt.Errorf("contents is empty")
+	}
+
+	formatter := catalogFormatter(l.catalog)
+	start := rand.Intn(len(tracks))
+	var errs []error
+	for i := range tracks {
+		c, err := newContentFromTrack(tracks[(start+i)%len(tracks)], formatter)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("no playable track in catalog: %w", errors.Join(errs...))
+}
+
+func (l catalogLogic) Len() int {
+	return len(l.catalog.List())
+}
+
+// CatalogKind selects a Catalog implementation in OpenCatalog
+type CatalogKind string
+
+const (
+	CatalogJSON CatalogKind = "json" // index.json
+	CatalogDir  CatalogKind = "dir"  // scan of {type}/{id}/{id}.m3u8
+)
+
+// OpenCatalog opens a catalog of the given kind; an empty path selects the default location.
+// Like the constructors it wraps, it returns a usable catalog together with any load error.
+func OpenCatalog(kind CatalogKind, path string, pollInterval time.Duration) (Catalog, error) {
+	switch kind {
+	case CatalogJSON:
+		if path == "" {
+			path = prosekaIndexPath
+		}
+		return NewJSONCatalog(path, pollInterval)
+	case CatalogDir:
+		if path == "" {
+			path = contentsRootDir
+		}
+		return NewDirCatalog(path, pollInterval)
+	default:
+		return nil, fmt.Errorf("unknown catalog kind %q", kind)
+	}
+}
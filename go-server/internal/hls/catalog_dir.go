@@ -0,0 +1,251 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DirCatalog is a Catalog discovered by scanning a contents root laid out as
+// {root}/{type}/{id}/{id}.m3u8, the layout DefaultContentFormatter expects.
+// Track lengths are derived from the EXTINF durations of each m3u8, and title,
+// artist and tags are read from an optional {id}.json sidecar next to it.
+// Its formatter resolves contents under root.
+type DirCatalog struct {
+	trackSet
+	watchers watchers
+
+	root string
+
+	mu       sync.Mutex
+	loadErr  error
+	problems []error
+	cache    map[string]scannedTrack // keyed by m3u8 path
+}
+
+// Sidecar is the optional per-track metadata file ({id}.json) read by DirCatalog
+type Sidecar struct {
+	Title  string   `json:"title"`
+	Artist string   `json:"artist"`
+	Tags   []string `json:"tags"`
+}
+
+type scannedTrack struct {
+	m3u8    fileStamp
+	sidecar fileStamp
+	track   Track
+	err     error
+}
+
+// NewDirCatalog scans root. Watch rescans it every pollInterval (zero disables polling).
+func NewDirCatalog(root string, pollInterval time.Duration) (*DirCatalog, error) {
+	c := &DirCatalog{
+		watchers: watchers{interval: pollInterval},
+		root:     root,
+		cache:    make(map[string]scannedTrack),
+	}
+	c.watchers.poll = func() { _ = c.Reload() }
+	c.SetFormatter(DefaultContentFormatter{Root: root})
+	return c, c.Reload()
+}
+
+// Reload rescans the contents root and notifies watchers if the tracks changed.
+// Tracks that cannot be parsed are skipped and reported by Problems; if the root
+// itself cannot be read the previously scanned tracks are kept.
+func (c *DirCatalog) Reload() error {
+	c.mu.Lock()
+	tracks, problems, err := c.scan()
+	c.loadErr = err
+	if err == nil {
+		c.problems = problems
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if c.replace(tracks) {
+		c.watchers.notify()
+	}
+	return nil
+}
+
+func (c *DirCatalog) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadErr
+}
+
+// Problems returns why tracks were skipped during the last successful scan
+func (c *DirCatalog) Problems() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]error(nil), c.problems...)
+}
+
+func (c *DirCatalog) Watch(ctx context.Context) <-chan struct{} {
+	return c.watchers.subscribe(ctx)
+}
+
+// scan walks the contents root; the caller must hold mu
+func (c *DirCatalog) scan() ([]Track, []error, error) {
+	types, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan contents root %s: %w", c.root, err)
+	}
+
+	var tracks []Track
+	var problems []error
+	seen := make(map[string]bool)
+	for _, typeDir := range types {
+		if !typeDir.IsDir() {
+			continue
+		}
+		ids, err := os.ReadDir(filepath.Join(c.root, typeDir.Name()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan %s: %w", typeDir.Name(), err)
+		}
+		for _, idDir := range ids {
+			if !idDir.IsDir() {
+				continue
+			}
+			dir := ContentDir(c.root, typeDir.Name(), idDir.Name())
+			m3u8Path := filepath.Join(dir, idDir.Name()+".m3u8")
+			m3u8Stamp, err := statFile(m3u8Path)
+			if err != nil {
+				continue
+			}
+			seen[m3u8Path] = true
+
+			sidecarStamp, _ := statFile(filepath.Join(dir, idDir.Name()+".json"))
+			cached, ok := c.cache[m3u8Path]
+			if !ok || cached.m3u8 != m3u8Stamp || cached.sidecar != sidecarStamp {
+				track, err := scanTrack(typeDir.Name(), idDir.Name(), dir)
+				cached = scannedTrack{m3u8: m3u8Stamp, sidecar: sidecarStamp, track: track, err: err}
+				c.cache[m3u8Path] = cached
+			}
+
+			if cached.err != nil {
+				problems = append(problems, cached.err)
+				continue
+			}
+			tracks = append(tracks, cached.track)
+		}
+	}
+
+	for path := range c.cache {
+		if !seen[path] {
+			delete(c.cache, path)
+		}
+	}
+
+	sortTracks(tracks)
+	return tracks, problems, nil
+}
+
+// scanTrack builds a track from {dir}/{id}.m3u8 and its optional sidecar
+func scanTrack(contentType string, id string, dir string) (Track, error) {
+	m3u8 := id + ".m3u8"
+	length, err := m3u8Duration(filepath.Join(dir, m3u8))
+	if err != nil {
+		return Track{}, fmt.Errorf("%s/%s: %w", contentType, id, err)
+	}
+
+	track := Track{
+		ID:     id,
+		Title:  id,
+		Length: int(math.Round(length)),
+		M3U8:   m3u8,
+		Type:   contentType,
+	}
+
+	sidecar, err := readSidecar(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return Track{}, fmt.Errorf("%s/%s: %w", contentType, id, err)
+	}
+	if sidecar.Title != "" {
+		track.Title = sidecar.Title
+	}
+	track.Artist = sidecar.Artist
+	track.Tags = sidecar.Tags
+	return track, nil
+}
+
+// m3u8Duration returns the sum of the EXTINF durations of the playlist at path
+func m3u8Duration(path string) (float64, error) {
+	segments, err := ReadMediaPlaylist(path)
+	if err != nil {
+		return 0, err
+	}
+	total, err := MediaPlaylistDuration(segments)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return total, nil
+}
+
+// readSidecar reads the metadata file at path; a missing file yields empty metadata
+func readSidecar(path string) (Sidecar, error) {
+	var sidecar Sidecar
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sidecar, nil
+	}
+	if err != nil {
+		return sidecar, err
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return sidecar, fmt.Errorf("failed to unmarshal sidecar %s: %w", path, err)
+	}
+	return sidecar, nil
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// TrackMismatch describes a disagreement between two catalogs about one track
+type TrackMismatch struct {
+	ID     string
+	Reason string
+}
+
+func (m TrackMismatch) String() string {
+	return m.ID + ": " + m.Reason
+}
+
+// CompareCatalogs reports tracks whose length differs by more than toleranceSeconds
+// between reference (e.g. index.json) and actual (e.g. a directory scan), and tracks
+// that only exist in one of them
+func CompareCatalogs(reference Catalog, actual Catalog, toleranceSeconds int) []TrackMismatch {
+	var mismatches []TrackMismatch
+	for _, ref := range reference.List() {
+		got, err := actual.Get(ref.ID)
+		if err != nil {
+			mismatches = append(mismatches, TrackMismatch{ID: ref.ID, Reason: "listed in reference but not found"})
+			continue
+		}
+		if diff := got.Length - ref.Length; diff > toleranceSeconds || -diff > toleranceSeconds {
+			mismatches = append(mismatches, TrackMismatch{
+				ID:     ref.ID,
+				Reason: fmt.Sprintf("length %ds in reference but %ds in m3u8", ref.Length, got.Length),
+			})
+		}
+	}
+	for _, got := range actual.List() {
+		if _, err := reference.Get(got.ID); err != nil {
+			mismatches = append(mismatches, TrackMismatch{ID: got.ID, Reason: "found but not listed in reference"})
+		}
+	}
+	return mismatches
+}
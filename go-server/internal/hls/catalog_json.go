@@ -0,0 +1,133 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSONCatalog is a Catalog backed by an index.json file (a JSON array of Track)
+type JSONCatalog struct {
+	trackSet
+	watchers watchers
+
+	path string
+
+	stampMu sync.Mutex
+	stamp   fileStamp
+	loadErr error
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewJSONCatalog loads the index at path. The catalog is returned even when loading fails,
+// so that it can recover once the file is fixed; Watch polls the file every pollInterval
+// (zero disables polling).
+func NewJSONCatalog(path string, pollInterval time.Duration) (*JSONCatalog, error) {
+	c := &JSONCatalog{
+		watchers: watchers{interval: pollInterval},
+		path:     path,
+	}
+	c.watchers.poll = c.reloadIfModified
+	return c, c.Reload()
+}
+
+func (c *JSONCatalog) Path() string {
+	return c.path
+}
+
+// Reload re-reads the index file and notifies watchers if the tracks changed.
+// On failure the previously loaded tracks are kept.
+func (c *JSONCatalog) Reload() error {
+	tracks, stamp, err := c.load()
+
+	c.stampMu.Lock()
+	c.loadErr = err
+	if err == nil {
+		c.stamp = stamp
+	}
+	c.stampMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if c.replace(tracks) {
+		c.watchers.notify()
+	}
+	return nil
+}
+
+func (c *JSONCatalog) load() ([]Track, fileStamp, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, fileStamp{}, fmt.Errorf("failed to read contents index %s: %w", c.path, err)
+	}
+	tracks, err := loadTracksFile(c.path)
+	if err != nil {
+		return nil, fileStamp{}, err
+	}
+	return tracks, fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func (c *JSONCatalog) Err() error {
+	c.stampMu.Lock()
+	defer c.stampMu.Unlock()
+	return c.loadErr
+}
+
+func (c *JSONCatalog) Watch(ctx context.Context) <-chan struct{} {
+	return c.watchers.subscribe(ctx)
+}
+
+func (c *JSONCatalog) reloadIfModified() {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return
+	}
+	c.stampMu.Lock()
+	unchanged := c.stamp == fileStamp{modTime: info.ModTime(), size: info.Size()}
+	c.stampMu.Unlock()
+	if unchanged {
+		return
+	}
+	// 読み込みに失敗したら前回のトラックを使い続ける
+	_ = c.Reload()
+}
+
+// loadTracksFile reads an index.json file
+func loadTracksFile(path string) ([]Track, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contents index %s: %w", path, err)
+	}
+
+	var tracks []Track
+	if err := json.Unmarshal(data, &tracks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal contents index %s: %w", path, err)
+	}
+	return tracks, nil
+}
+
+// pollUntilDone calls f every interval until ctx is done
+func pollUntilDone(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 止められた後に ticker が先に選ばれても呼ばない
+			if ctx.Err() != nil {
+				return
+			}
+			f()
+		}
+	}
+}
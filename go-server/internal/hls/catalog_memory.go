@@ -0,0 +1,52 @@
+package hls
+
+import (
+	"context"
+)
+
+// MemoryCatalog is an in-memory Catalog, mainly for tests and fixed line-ups
+type MemoryCatalog struct {
+	trackSet
+	watchers watchers
+}
+
+func NewMemoryCatalog(tracks ...Track) *MemoryCatalog {
+	c := &MemoryCatalog{}
+	c.replace(append([]Track(nil), tracks...))
+	return c
+}
+
+// Put adds or replaces a track
+func (c *MemoryCatalog) Put(t Track) {
+	tracks := c.List()
+	replaced := false
+	for i := range tracks {
+		if tracks[i].ID == t.ID {
+			tracks[i] = t
+			replaced = true
+		}
+	}
+	if !replaced {
+		tracks = append(tracks, t)
+	}
+	if c.replace(tracks) {
+		c.watchers.notify()
+	}
+}
+
+// Remove deletes the track with id, if present
+func (c *MemoryCatalog) Remove(id string) {
+	var tracks []Track
+	for _, t := range c.List() {
+		if t.ID != id {
+			tracks = append(tracks, t)
+		}
+	}
+	if c.replace(tracks) {
+		c.watchers.notify()
+	}
+}
+
+func (c *MemoryCatalog) Watch(ctx context.Context) <-chan struct{} {
+	return c.watchers.subscribe(ctx)
+}
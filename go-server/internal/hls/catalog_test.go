@@ -0,0 +1,294 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCatalog(t *testing.T) {
+	c := NewMemoryCatalog(
+		Track{ID: "1", Title: "Tell Your World", Artist: "livetune"},
+		Track{ID: "2", Title: "Senbonzakura", Artist: "kurousa-P"},
+	)
+
+	if got := len(c.List()); got != 2 {
+		t.Errorf("List() length = %v, want 2", got)
+	}
+	if tr, err := c.Get("2"); err != nil || tr.Title != "Senbonzakura" {
+		t.Errorf("Get(2) = %v, %v", tr, err)
+	}
+	if _, err := c.Get("3"); !errors.Is(err, ErrTrackNotFound) {
+		t.Errorf("Get(3) error = %v, want ErrTrackNotFound", err)
+	}
+	if got := c.Search("LIVETUNE"); len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("Search(LIVETUNE) = %v, want track 1", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := c.Watch(ctx)
+
+	c.Put(Track{ID: "3", Title: "Melt"})
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("Put did not notify watchers")
+	}
+	c.Remove("1")
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("Remove did not notify watchers")
+	}
+	if got := len(c.List()); got != 2 {
+		t.Errorf("List() length after Put/Remove = %v, want 2", got)
+	}
+
+	cancel()
+	deadline := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-changes:
+			closed = !ok
+		case <-deadline:
+			t.Fatal("Watch channel was not closed after ctx is done")
+		}
+	}
+}
+
+func TestJSONCatalog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.json")
+
+	c, err := NewJSONCatalog(path, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("NewJSONCatalog() should fail for a missing file")
+	}
+	if c == nil || c.Err() == nil || len(c.List()) != 0 {
+		t.Fatalf("catalog should be usable and empty with Err set, got %v", c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Watch(ctx)
+
+	index := `[{"id": "27714925", "title": "Cyberpunk Dead Boy", "length": 227, "artist": "", "m3u8": "27714925.m3u8"}]`
+	if err := os.WriteFile(path, []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("catalog did not pick up the new index")
+	}
+
+	if c.Err() != nil {
+		t.Errorf("Err() = %v, want nil after a successful reload", c.Err())
+	}
+	tr, err := c.Get("27714925")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if tr.Length != 227 || tr.contentType() != audio {
+		t.Errorf("Get() = %+v, want length 227 of type music", tr)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Error("Reload() should fail for invalid JSON")
+	}
+	if len(c.List()) != 1 {
+		t.Error("a failed reload should keep the previous tracks")
+	}
+}
+
+func TestWatchersSharePoller(t *testing.T) {
+	calls := make(chan struct{})
+	release := make(chan struct{})
+	w := watchers{interval: time.Millisecond, poll: func() {
+		select {
+		case calls <- struct{}{}:
+			<-release
+		default:
+		}
+	}}
+	expectCall := func(want bool) {
+		t.Helper()
+		select {
+		case <-calls:
+			if !want {
+				t.Fatal("poll was called")
+			}
+		case <-time.After(50 * time.Millisecond):
+			if want {
+				t.Fatal("poll was not called")
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for range 3 {
+		w.subscribe(ctx)
+	}
+	// 購読者が何人いても、ポーリングは一つだけ
+	expectCall(true)
+	expectCall(false)
+
+	cancel()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		w.mu.Lock()
+		stopped := w.stopPoll == nil
+		w.mu.Unlock()
+		if stopped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("polling did not stop with the last subscriber")
+		}
+	}
+	release <- struct{}{}
+	expectCall(false)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	w.subscribe(ctx)
+	expectCall(true)
+	close(release)
+}
+
+func TestDirCatalog(t *testing.T) {
+	root := t.TempDir()
+	const twoSegments = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\n0.ts\n#EXTINF:4.600,\n1.ts\n#EXT-X-ENDLIST\n"
+	files := map[string]string{
+		"music/100/100.m3u8":       twoSegments,
+		"music/100/100.json":       `{"title": "Tell Your World", "artist": "livetune", "tags": ["miku", "2012"]}`,
+		"music/200/200.m3u8":       twoSegments,
+		"voice/news-1/news-1.m3u8": "#EXTM3U\n#EXTINF:3.0,\nnews.ts\n",
+		"music/300/other.m3u8":     twoSegments, // {id}.m3u8 がないので無視
+		"music/400/400.m3u8":       "#EXTM3U\n", // セグメントがない
+		"music/500/500.m3u8":       twoSegments,
+		"music/500/500.json":       "{broken",
+		"index.json":               "[]",
+	}
+	for p, data := range files {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := NewDirCatalog(root, 0)
+	if err != nil {
+		t.Fatalf("NewDirCatalog() error = %v", err)
+	}
+
+	want := []Track{
+		{ID: "100", Title: "Tell Your World", Artist: "livetune", Tags: []string{"miku", "2012"}, Length: 15, M3U8: "100.m3u8", Type: "music"},
+		{ID: "200", Title: "200", Length: 15, M3U8: "200.m3u8", Type: "music"},
+		{ID: "news-1", Title: "news-1", Length: 3, M3U8: "news-1.m3u8", Type: "voice"},
+	}
+	if tracks := c.List(); !equalTracks(tracks, want) {
+		t.Errorf("List() = %+v, want %+v", tracks, want)
+	}
+	if problems := c.Problems(); len(problems) != 2 {
+		t.Errorf("Problems() = %v, want 2 (no segments, broken sidecar)", problems)
+	}
+
+	// 変更されたm3u8だけ再解析される
+	longer := twoSegments + "#EXTINF:10.0,\n2.ts\n"
+	if err := os.WriteFile(filepath.Join(root, "music", "200", "200.m3u8"), []byte(longer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "music", "200", "200.m3u8"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if tr, _ := c.Get("200"); tr.Length != 25 {
+		t.Errorf("length after edit = %v, want 25", tr.Length)
+	}
+
+	if _, err := NewDirCatalog(filepath.Join(root, "missing"), 0); err == nil {
+		t.Error("NewDirCatalog() should fail for a missing root")
+	}
+}
+
+func TestCompareCatalogs(t *testing.T) {
+	reference := NewMemoryCatalog(
+		Track{ID: "1", Length: 100},
+		Track{ID: "2", Length: 200},
+		Track{ID: "3", Length: 300},
+	)
+	actual := NewMemoryCatalog(
+		Track{ID: "1", Length: 101}, // 許容範囲内
+		Track{ID: "2", Length: 180},
+		Track{ID: "4", Length: 400},
+	)
+
+	got := CompareCatalogs(reference, actual, 1)
+	want := []TrackMismatch{
+		{ID: "2", Reason: "length 200s in reference but 180s in m3u8"},
+		{ID: "3", Reason: "listed in reference but not found"},
+		{ID: "4", Reason: "found but not listed in reference"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("CompareCatalogs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mismatch[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCatalogLogic(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracks  []Track
+		wantID  string
+		wantErr bool
+	}{
+		{
+			name:    "empty catalog",
+			wantErr: true,
+		},
+		{
+			name:   "skips tracks that cannot be played",
+			tracks: []Track{{ID: "../escape"}, {ID: "melt", Type: "../../etc"}, {ID: "tell-your-world"}},
+			wantID: "tell-your-world",
+		},
+		{
+			name:    "no playable track",
+			tracks:  []Track{{ID: "../escape"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := catalogLogic{catalog: NewMemoryCatalog(tt.tracks...)}
+			for i := 0; i < 5; i++ {
+				c, err := l.Choice()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Choice() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && c.ID() != tt.wantID {
+					t.Errorf("Choice() = %v, want %v", c.ID(), tt.wantID)
+				}
+			}
+			if l.Len() != len(tt.tracks) {
+				t.Errorf("Len() = %v, want %v", l.Len(), len(tt.tracks))
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Severity of a CheckIssue
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue codes reported by CheckCatalog
+const (
+	IssueCatalogLoad     = "catalog_load"
+	IssueInvalidID       = "invalid_id"
+	IssueDuplicateID     = "duplicate_id"
+	IssueMissingSource   = "missing_source"
+	IssueInvalidPlaylist = "invalid_playlist"
+	IssueMissingSegment  = "missing_segment"
+	IssueLengthMismatch  = "length_mismatch"
+	IssueBrokenSegment   = "broken_segment"
+)
+
+// CheckConfig configures CheckCatalog
+type CheckConfig struct {
+	// Root is the contents root source paths are resolved against; DefaultContentsRoot when empty
+	Root string
+	// Formatter resolves tracks to m3u8 paths; DefaultContentFormatter under Root when nil
+	Formatter ContentFormatter
+	// LengthTolerance is how far (seconds) a track's length may be from its EXTINF sum
+	LengthTolerance float64
+	// Inspect additionally inspects every TS segment (slow: reads the whole library)
+	Inspect    bool
+	Validation ValidationConfig
+}
+
+// CheckIssue is one problem found by CheckCatalog
+type CheckIssue struct {
+	ID       string   `json:"id,omitempty"`
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Path     string   `json:"path,omitempty"`
+}
+
+// CheckReport is the machine-readable result of CheckCatalog
+type CheckReport struct {
+	Root     string       `json:"root"`
+	Tracks   int          `json:"tracks"`
+	Errors   int          `json:"errors"`
+	Warnings int          `json:"warnings"`
+	OK       bool         `json:"ok"`
+	Issues   []CheckIssue `json:"issues"`
+}
+
+func (r *CheckReport) add(issue CheckIssue) {
+	r.Issues = append(r.Issues, issue)
+	if issue.Severity == SeverityError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+}
+
+// CheckCatalog verifies that every track of catalog can be played: IDs are unique and
+// usable as a path element, the m3u8 exists and parses with positive durations, the segments exist,
+// and the catalog length matches the EXTINF sum
+func CheckCatalog(catalog Catalog, config CheckConfig) CheckReport {
+	if config.Root == "" {
+		config.Root = DefaultContentsRoot
+	}
+	if config.Formatter == nil {
+		config.Formatter = DefaultContentFormatter{Root: config.Root}
+	}
+	report := CheckReport{Root: config.Root, Issues: []CheckIssue{}}
+
+	if rc, ok := catalog.(reloadableCatalog); ok {
+		if err := rc.Err(); err != nil {
+			report.add(CheckIssue{Severity: SeverityError, Code: IssueCatalogLoad, Message: err.Error()})
+		}
+	}
+
+	tracks := catalog.List()
+	report.Tracks = len(tracks)
+	seen := make(map[string]int, len(tracks))
+	for _, t := range tracks {
+		seen[t.ID]++
+		if seen[t.ID] == 2 {
+			report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueDuplicateID, Message: "id is listed more than once"})
+		}
+		if seen[t.ID] > 1 {
+			continue
+		}
+		checkTrack(&report, t, config)
+	}
+
+	report.OK = report.Errors == 0
+	return report
+}
+
+func checkTrack(report *CheckReport, t Track, config CheckConfig) {
+	c, err := newContentFromTrack(t, config.Formatter)
+	if err != nil {
+		report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueInvalidID, Message: err.Error()})
+		return
+	}
+	path := c.SourcePath()
+	dir := filepath.Dir(path)
+
+	if _, err := os.Stat(path); err != nil {
+		report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueMissingSource, Message: err.Error(), Path: path})
+		return
+	}
+	segments, err := ReadMediaPlaylist(path)
+	if err != nil {
+		report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueInvalidPlaylist, Message: err.Error(), Path: path})
+		return
+	}
+	total, err := MediaPlaylistDuration(segments)
+	if err != nil {
+		report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueInvalidPlaylist, Message: err.Error(), Path: path})
+	}
+
+	for _, seg := range segments {
+		segPath, ok := LocalSegmentPath(dir, seg.URI)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(segPath); err != nil {
+			report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueMissingSegment, Message: err.Error(), Path: segPath})
+		}
+	}
+
+	if total > 0 && math.Abs(float64(t.Length)-total) > config.LengthTolerance {
+		report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueLengthMismatch,
+			Message: fmt.Sprintf("length is %ds but the segments add up to %.3fs", t.Length, total), Path: path})
+	}
+
+	if config.Inspect {
+		v := ValidateMediaPlaylist(path, config.Validation)
+		for _, seg := range v.Segments {
+			if seg.Path == "" || errors.Is(firstErr(seg.Problems), os.ErrNotExist) {
+				continue // already reported as missing
+			}
+			for _, p := range seg.Problems {
+				report.add(CheckIssue{ID: t.ID, Severity: SeverityError, Code: IssueBrokenSegment, Message: p.Error(), Path: seg.Path})
+			}
+		}
+	}
+}
+
+func firstErr(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs[0]
+}
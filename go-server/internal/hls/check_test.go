@@ -0,0 +1,94 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCatalog(t *testing.T) {
+	root := t.TempDir()
+	writeSilenceContent(t, ContentDir(root, "music", "1"), "1", []float64{10.0, 10.0})
+	writeSilenceContent(t, ContentDir(root, "music", "2"), "2", []float64{10.0})
+	writeSilenceContent(t, ContentDir(root, "music", "3"), "3", []float64{10.0, 10.0})
+	os.Remove(filepath.Join(ContentDir(root, "music", "3"), "3_1.ts"))
+	writeSilenceContent(t, ContentDir(root, "music", "4"), "4", []float64{10.0})
+	os.WriteFile(filepath.Join(ContentDir(root, "music", "4"), "4_0.ts"), []byte("not a ts"), 0644)
+
+	catalog := NewMemoryCatalog(
+		Track{ID: "1", Length: 20, M3U8: "1.m3u8"},
+		Track{ID: "2", Length: 30, M3U8: "2.m3u8"},
+		Track{ID: "3", Length: 20, M3U8: "3.m3u8"},
+		Track{ID: "4", Length: 10, M3U8: "4.m3u8"},
+		Track{ID: "5", Length: 10, M3U8: "5.m3u8"},
+		Track{ID: "../etc", Length: 10},
+	)
+	// MemoryCatalog は ID で重複を除くので、重複は List を上書きして作る
+	dup := duplicatingCatalog{MemoryCatalog: catalog, extra: Track{ID: "1", Length: 20}}
+
+	tests := []struct {
+		name      string
+		inspect   bool
+		wantCodes map[string]string
+	}{
+		{
+			name: "without inspection",
+			wantCodes: map[string]string{
+				"1":      IssueDuplicateID,
+				"2":      IssueLengthMismatch,
+				"3":      IssueMissingSegment,
+				"5":      IssueMissingSource,
+				"../etc": IssueInvalidID,
+			},
+		},
+		{
+			name:    "with inspection",
+			inspect: true,
+			wantCodes: map[string]string{
+				"1":      IssueDuplicateID,
+				"2":      IssueLengthMismatch,
+				"3":      IssueMissingSegment,
+				"4":      IssueBrokenSegment,
+				"5":      IssueMissingSource,
+				"../etc": IssueInvalidID,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := CheckCatalog(dup, CheckConfig{Root: root, LengthTolerance: 1, Inspect: tt.inspect})
+			if report.OK {
+				t.Error("report is OK, want errors")
+			}
+			if report.Tracks != 7 {
+				t.Errorf("Tracks = %d, want 7", report.Tracks)
+			}
+
+			got := make(map[string]string)
+			for _, issue := range report.Issues {
+				if _, ok := got[issue.ID]; ok {
+					continue
+				}
+				got[issue.ID] = issue.Code
+			}
+			for id, code := range tt.wantCodes {
+				if got[id] != code {
+					t.Errorf("issue for %s = %q, want %q", id, got[id], code)
+				}
+			}
+			if len(got) != len(tt.wantCodes) {
+				t.Errorf("issues = %+v, want only %v", report.Issues, tt.wantCodes)
+			}
+		})
+	}
+}
+
+type duplicatingCatalog struct {
+	*MemoryCatalog
+	extra Track
+}
+
+func (c duplicatingCatalog) List() []Track {
+	return append(c.MemoryCatalog.List(), c.extra)
+}
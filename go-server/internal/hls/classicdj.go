@@ -1,15 +1,12 @@
 package hls
 
+import "log/slog"
+
 func NewClassicDJ(pManager *playlistManager) *dj {
-	contents := []content{
-		*NewAudioContent(1, 85, DefaultContentFormatter{}),
-		*NewAudioContent(2, 196, DefaultContentFormatter{}),
-		*NewAudioContent(3, 43, DefaultContentFormatter{}),
-	}
-	return &dj{
-		manager: pManager,
-		logic: randomLogic{
-			contents: contents,
-		},
-	}
+	catalog := NewMemoryCatalog(
+		Track{ID: "1", Length: 85},
+		Track{ID: "2", Length: 196},
+		Track{ID: "3", Length: 43},
+	)
+	return NewCatalogDJ(pManager, catalog, slog.Default())
 }
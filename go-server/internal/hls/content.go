@@ -1,14 +1,17 @@
 package hls
 
 import (
-	"log/slog"
+	"fmt"
+	"net/url"
+	"path"
 	"path/filepath"
-	"strconv"
+	"strings"
 )
 
 // Content defines the interface for content operations
 type Content interface {
-	ToSegments() []segment
+	ID() string
+	ToSegments() ([]segment, error)
 	ToStreamFilePath(baseDir string) string
 	SourcePath() string
 	UrlPath() string
@@ -16,21 +19,29 @@ type Content interface {
 }
 
 type content struct {
-	id          int
+	id          string
 	contentType ContentType
-	isTmp       bool
-	length      int // seconde
-	formatter   contentFormatter
+	// m3u8 is Track.M3U8; how it is resolved depends on the formatter
+	m3u8      string
+	isTmp     bool
+	length    int // seconde
+	formatter ContentFormatter
 }
 
-type contentFormatter interface {
+// ContentFormatter decides where the m3u8 of a content lives and under which URLs its
+// segments are published. Catalogs carry one (see SetFormatter) so that libraries with
+// different layouts can be played side by side.
+type ContentFormatter interface {
 	sourcePath(content) string
 	urlPath(content) string
 	segmentLocalToGlobal(segment, content) segment
-	// parser(string) content
 }
 
-type DefaultContentFormatter struct{}
+const (
+	// contentsRootDir is where contents live on disk; nginx serves it as contentsURLPrefix
+	contentsRootDir   = "/srv/radio/contents"
+	contentsURLPrefix = "/contents"
+)
 
 type ContentType string
 
@@ -39,7 +50,7 @@ const (
 	news  ContentType = "voice"
 )
 
-func NewAudioContent(id int, length int, formatter contentFormatter) *content {
+func NewAudioContent(id string, length int, formatter ContentFormatter) *content {
 	return &content{
 		id:          id,
 		contentType: audio,
@@ -49,22 +60,140 @@ func NewAudioContent(id int, length int, formatter contentFormatter) *content {
 	}
 }
 
-// TODO: abstract this method
+// DefaultContentFormatter resolves contents laid out as {root}/{type}/{id}/{m3u8}, where
+// m3u8 defaults to {id}.m3u8. Segment URIs are relative to that directory.
+type DefaultContentFormatter struct {
+	// Root is the contents root on disk; contentsRootDir when empty
+	Root string
+	// URLPrefix is the URL path Root is served under; contentsURLPrefix when empty
+	URLPrefix string
+}
+
+func (d DefaultContentFormatter) root() string {
+	if d.Root == "" {
+		return contentsRootDir
+	}
+	return d.Root
+}
+
+func (d DefaultContentFormatter) urlPrefix() string {
+	if d.URLPrefix == "" {
+		return contentsURLPrefix
+	}
+	return d.URLPrefix
+}
+
+// relDir returns the content directory relative to the root, in slash form
+func (d DefaultContentFormatter) relDir(c content) string {
+	return string(c.contentType) + "/" + c.id
+}
+
+func (d DefaultContentFormatter) m3u8Name(c content) string {
+	if c.m3u8 == "" {
+		return c.id + ".m3u8"
+	}
+	return path.Base(c.m3u8)
+}
+
 func (d DefaultContentFormatter) sourcePath(c content) string {
-	return "/srv/radio/contents/" + string(c.contentType) + "/" + strconv.Itoa(c.id) + "/" + strconv.Itoa(c.id) + ".m3u8"
+	return filepath.Join(d.root(), filepath.FromSlash(d.relDir(c)), d.m3u8Name(c))
 }
 
-// TODO: abstract this method
 func (d DefaultContentFormatter) urlPath(c content) string {
-	return "/contents/" + string(c.contentType) + "/" + strconv.Itoa(c.id) + "/" + strconv.Itoa(c.id) + ".m3u8"
+	return d.urlPrefix() + "/" + escapePath(d.relDir(c)+"/"+d.m3u8Name(c))
 }
 
-// TODO: abstract this method
 func (d DefaultContentFormatter) segmentLocalToGlobal(seg segment, c content) segment {
-	seg.uri = "/contents/" + string(c.contentType) + "/" + strconv.Itoa(c.id) + "/" + seg.uri
+	dir := d.urlPrefix() + "/" + escapePath(d.relDir(c))
+	seg.uri = resolveSegmentURI(dir, seg.uri)
+	if seg.initURI != "" {
+		seg.initURI = resolveSegmentURI(dir, seg.initURI)
+	}
 	return seg
 }
 
+// PathContentFormatter resolves Track.M3U8 as a path relative to the contents root, for
+// libraries in nested folders that do not follow the {type}/{id} layout. Segment URIs are
+// relative to the directory of the m3u8.
+type PathContentFormatter struct {
+	// Root is the contents root on disk; contentsRootDir when empty
+	Root string
+	// URLPrefix is the URL path Root is served under; contentsURLPrefix when empty
+	URLPrefix string
+}
+
+// relPath returns the m3u8 path relative to the root; paths cannot escape the root
+func (p PathContentFormatter) relPath(c content) string {
+	rel := c.m3u8
+	if rel == "" {
+		rel = c.id + ".m3u8"
+	}
+	return strings.TrimPrefix(path.Clean("/"+rel), "/")
+}
+
+func (p PathContentFormatter) sourcePath(c content) string {
+	return filepath.Join(DefaultContentFormatter(p).root(), filepath.FromSlash(p.relPath(c)))
+}
+
+func (p PathContentFormatter) urlPath(c content) string {
+	return DefaultContentFormatter(p).urlPrefix() + "/" + escapePath(p.relPath(c))
+}
+
+func (p PathContentFormatter) segmentLocalToGlobal(seg segment, c content) segment {
+	dir := DefaultContentFormatter(p).urlPrefix()
+	if d := path.Dir(p.relPath(c)); d != "." {
+		dir += "/" + escapePath(d)
+	}
+	seg.uri = resolveSegmentURI(dir, seg.uri)
+	if seg.initURI != "" {
+		seg.initURI = resolveSegmentURI(dir, seg.initURI)
+	}
+	return seg
+}
+
+// Content layouts accepted by NewContentFormatter
+const (
+	LayoutID   = "id"   // {type}/{id}/{m3u8}
+	LayoutPath = "path" // m3u8 is a path relative to the root
+)
+
+// NewContentFormatter returns the formatter for a layout name, resolving files under root
+// (contentsRootDir when empty)
+func NewContentFormatter(layout string, root string) (ContentFormatter, error) {
+	switch layout {
+	case LayoutID, "":
+		return DefaultContentFormatter{Root: root}, nil
+	case LayoutPath:
+		return PathContentFormatter{Root: root}, nil
+	default:
+		return nil, fmt.Errorf("unknown content layout %q", layout)
+	}
+}
+
+// resolveSegmentURI resolves a segment URI written in a source m3u8 against the URL
+// directory of that m3u8, so that "../shared/seg.ts" works. Absolute URLs ("https://...",
+// "//host/...") and root-relative paths are published as they are.
+func resolveSegmentURI(dirURL string, uri string) string {
+	ref, err := url.Parse(uri)
+	if err != nil || ref.IsAbs() || ref.Host != "" || strings.HasPrefix(uri, "/") {
+		return uri
+	}
+	base, err := url.Parse(dirURL + "/")
+	if err != nil {
+		return dirURL + "/" + uri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// escapePath percent-encodes a slash separated file system path for use in a URL
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+func (c content) ID() string {
+	return c.id
+}
+
 func (c content) SourcePath() string {
 	return c.formatter.sourcePath(c)
 }
@@ -77,33 +206,39 @@ func (c content) SegmentLocalToGlobal(seg segment) segment {
 	return c.formatter.segmentLocalToGlobal(seg, c)
 }
 
+// ToStreamFilePath returns where the m3u8 would be under baseDir/contents, mirroring UrlPath
 func (c content) ToStreamFilePath(baseDir string) string {
-	return filepath.Join(baseDir, "contents", string(c.contentType), strconv.Itoa(c.id), strconv.Itoa(c.id)+".m3u8")
+	rel := strings.TrimPrefix(c.UrlPath(), contentsURLPrefix+"/")
+	if unescaped, err := url.PathUnescape(rel); err == nil {
+		rel = unescaped
+	}
+	return filepath.Join(baseDir, "contents", filepath.FromSlash(rel))
 }
 
-func (c content) ToSegments() []segment {
-	sourcePath := c.SourcePath()
+func (c content) ToSegments() ([]segment, error) {
+	return loadSegments(c.SourcePath(), c.SegmentLocalToGlobal)
+}
 
+// loadSegments parses the m3u8 at sourcePath and maps its segment URIs with toGlobal
+func loadSegments(sourcePath string, toGlobal func(segment) segment) ([]segment, error) {
 	// TODO: abstract this using interface
 	fs := DefaultFileSystem{}
 	pf := DefaultPlaylistFormatter{}
 
 	bytes, err := fs.ReadFile(sourcePath)
 	if err != nil {
-		slog.Error("failed to read file", "path", sourcePath, "error", err)
-		return []segment{}
+		return nil, fmt.Errorf("failed to read %s: %w", sourcePath, err)
 	}
 
 	pContent := DefaultPlaylistContent{data: bytes}
 	playlist, err := pf.Parse(&pContent)
 	if err != nil {
-		slog.Error("failed to parse playlist", "error", err)
-		return []segment{}
+		return nil, fmt.Errorf("failed to parse %s: %w", sourcePath, err)
 	}
 
 	segments := make([]segment, len(playlist.segments))
 	for i, seg := range playlist.segments {
-		segments[i] = c.SegmentLocalToGlobal(seg)
+		segments[i] = toGlobal(seg)
 	}
-	return segments
+	return segments, nil
 }
@@ -0,0 +1,157 @@
+package hls
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestContentFormatters(t *testing.T) {
+	tests := []struct {
+		name       string
+		formatter  ContentFormatter
+		track      Track
+		wantSource string
+		wantURL    string
+		wantSeg    string
+	}{
+		{
+			name:       "default layout with numeric id",
+			formatter:  DefaultContentFormatter{},
+			track:      Track{ID: "27714925", M3U8: "27714925.m3u8"},
+			wantSource: "/srv/radio/contents/music/27714925/27714925.m3u8",
+			wantURL:    "/contents/music/27714925/27714925.m3u8",
+			wantSeg:    "/contents/music/27714925/seg0.ts",
+		},
+		{
+			name:       "default layout honors the m3u8 file name",
+			formatter:  DefaultContentFormatter{Root: "/data", URLPrefix: "/media"},
+			track:      Track{ID: "0b6c3f2e-uuid", Type: "voice", M3U8: "index.m3u8"},
+			wantSource: "/data/voice/0b6c3f2e-uuid/index.m3u8",
+			wantURL:    "/media/voice/0b6c3f2e-uuid/index.m3u8",
+			wantSeg:    "/media/voice/0b6c3f2e-uuid/seg0.ts",
+		},
+		{
+			name:       "default layout without m3u8",
+			formatter:  DefaultContentFormatter{},
+			track:      Track{ID: "tell-your-world"},
+			wantSource: "/srv/radio/contents/music/tell-your-world/tell-your-world.m3u8",
+			wantURL:    "/contents/music/tell-your-world/tell-your-world.m3u8",
+			wantSeg:    "/contents/music/tell-your-world/seg0.ts",
+		},
+		{
+			name:       "path layout with nested folders",
+			formatter:  PathContentFormatter{},
+			track:      Track{ID: "melt", M3U8: "ryo/2007 singles/melt.m3u8"},
+			wantSource: "/srv/radio/contents/ryo/2007 singles/melt.m3u8",
+			wantURL:    "/contents/ryo/2007%20singles/melt.m3u8",
+			wantSeg:    "/contents/ryo/2007%20singles/seg0.ts",
+		},
+		{
+			name:       "path layout cannot escape the root",
+			formatter:  PathContentFormatter{Root: "/data"},
+			track:      Track{ID: "x", M3U8: "../../etc/x.m3u8"},
+			wantSource: "/data/etc/x.m3u8",
+			wantURL:    "/contents/etc/x.m3u8",
+			wantSeg:    "/contents/etc/seg0.ts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newContentFromTrack(tt.track, tt.formatter)
+			if err != nil {
+				t.Fatalf("newContentFromTrack() error = %v", err)
+			}
+			if c.ID() != tt.track.ID {
+				t.Errorf("ID() = %q, want %q", c.ID(), tt.track.ID)
+			}
+			if got := c.SourcePath(); got != tt.wantSource {
+				t.Errorf("SourcePath() = %q, want %q", got, tt.wantSource)
+			}
+			if got := c.UrlPath(); got != tt.wantURL {
+				t.Errorf("UrlPath() = %q, want %q", got, tt.wantURL)
+			}
+			if got := c.SegmentLocalToGlobal(segment{uri: "seg0.ts"}).uri; got != tt.wantSeg {
+				t.Errorf("SegmentLocalToGlobal() = %q, want %q", got, tt.wantSeg)
+			}
+		})
+	}
+}
+
+func TestNewContentFromTrack_RejectsPaths(t *testing.T) {
+	for _, track := range []Track{
+		{ID: "../escape"},
+		{ID: "a/b"},
+		{ID: ".."},
+		{ID: "melt", Type: "../../etc"},
+		{ID: "melt", Type: "music/ryo"},
+		{ID: "melt", Type: `..\etc`},
+		{ID: "melt", Type: "."},
+	} {
+		if _, err := newContentFromTrack(track, DefaultContentFormatter{}); err == nil {
+			t.Errorf("newContentFromTrack(%+v) accepted a track that escapes its directory", track)
+		}
+	}
+}
+
+func TestCatalogFormatter(t *testing.T) {
+	catalog := NewMemoryCatalog(Track{ID: "melt", M3U8: "ryo/melt.m3u8"})
+	catalog.SetFormatter(PathContentFormatter{Root: "/library"})
+
+	c, err := catalogLogic{catalog: catalog}.Choice()
+	if err != nil {
+		t.Fatalf("Choice() error = %v", err)
+	}
+	if got := c.SourcePath(); got != "/library/ryo/melt.m3u8" {
+		t.Errorf("SourcePath() = %q, want the catalog's formatter to be used", got)
+	}
+
+	dc, _ := NewDirCatalog("/somewhere", 0)
+	if got := catalogFormatter(dc); got != (DefaultContentFormatter{Root: "/somewhere"}) {
+		t.Errorf("DirCatalog formatter = %#v, want one rooted at its scan root", got)
+	}
+	if err := SetContentLayout(dc, LayoutPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := catalogFormatter(dc); got != (PathContentFormatter{Root: "/somewhere"}) {
+		t.Errorf("formatter after SetContentLayout = %#v, want the scan root to be kept", got)
+	}
+	if err := SetContentLayout(dc, "flat"); err == nil {
+		t.Error("SetContentLayout accepted an unknown layout")
+	}
+}
+
+func TestContentsPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		uri    string
+		want   string
+		wantOK bool
+	}{
+		{"plain", "/contents/music/1/seg0.ts", "/srv/contents/music/1/seg0.ts", true},
+		{"escaped non-ASCII", "/contents/music/%E6%9B%B2/seg0.ts", "/srv/contents/music/曲/seg0.ts", true},
+		{"escaped space", "/contents/music/Tell%20Your%20World/seg0.ts", "/srv/contents/music/Tell Your World/seg0.ts", true},
+		{"escaped percent", "/contents/music/100%25/seg0.ts", "/srv/contents/music/100%/seg0.ts", true},
+		{"cannot escape the root", "/contents/../../etc/passwd", "/srv/contents/etc/passwd", true},
+		{"escaped traversal", "/contents/%2E%2E/%2E%2E/etc/passwd", "/srv/contents/etc/passwd", true},
+		{"other prefix", "/stations/proseka/silence.ts", "", false},
+		{"query", "/contents/music/1/seg0.ts?token=x", "", false},
+		{"invalid escape", "/contents/music/%zz/seg0.ts", "", false},
+		{"root itself", "/contents/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ContentsPath("/srv/contents", "/contents", tt.uri)
+			if ok != tt.wantOK || got != filepath.FromSlash(tt.want) {
+				t.Errorf("ContentsPath(%q) = %q, %v; want %q, %v", tt.uri, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	// URIs published by the content formatters map back to their files
+	c := content{id: "曲", contentType: "music", formatter: DefaultContentFormatter{Root: "/srv/contents"}}
+	uri := c.SegmentLocalToGlobal(segment{uri: "seg 0.ts"}).uri
+	if got, ok := ContentsPath("/srv/contents", "/contents", uri); !ok || got != filepath.FromSlash("/srv/contents/music/曲/seg 0.ts") {
+		t.Errorf("ContentsPath(%q) = %q, %v", uri, got, ok)
+	}
+}
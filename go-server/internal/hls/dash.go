@@ -0,0 +1,318 @@
+package hls
+
+import (
+	"cmp"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DASHContentType is the media type of MPEG-DASH manifests
+const DASHContentType = "application/dash+xml"
+
+const (
+	// SegmentList は isoff-live では使えないので main プロファイルを名乗る
+	dashProfile      = "urn:mpeg:dash:profile:isoff-main:2011"
+	dashTimescale    = 1000 // milliseconds
+	defaultCodecs    = "mp4a.40.2"
+	defaultBandwidth = 128000
+)
+
+// ErrNoDASHSegments is returned when no segment of the live window can be described in an
+// MPD: DASH needs fragmented MP4 in the clear, and MPEG-TS or AES-128 segments are left out
+var ErrNoDASHSegments = errors.New("no fMP4 segments in the playlist")
+
+// DASHFormatter renders the live playlist as a dynamic MPEG-DASH manifest. The window,
+// media sequence numbers and discontinuities are those of the HLS playlist: every
+// content starts a Period whose id is its discontinuity sequence number, and the
+// Number of a segment is its media sequence number, so both manifests describe the same
+// segments. Only contents packaged as fragmented MP4, whose media playlist has an
+// EXT-X-MAP, can be described; the others (MPEG-TS contents, filler, encrypted segments)
+// leave a gap between Periods.
+type DASHFormatter struct {
+	// Codecs of the audio in the segments; AAC-LC when empty
+	Codecs string
+	// Bandwidth is the bit rate announced for the audio in bits per second; 128 kbps when zero
+	Bandwidth int
+	// SamplingRate is announced as audioSamplingRate when it is not zero
+	SamplingRate int
+}
+
+type mpd struct {
+	XMLName                    xml.Name    `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string      `xml:"profiles,attr"`
+	Type                       string      `xml:"type,attr"`
+	AvailabilityStartTime      string      `xml:"availabilityStartTime,attr"`
+	PublishTime                string      `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string      `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              string      `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string      `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string      `xml:"suggestedPresentationDelay,attr"`
+	MaxSegmentDuration         string      `xml:"maxSegmentDuration,attr"`
+	Periods                    []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID            string           `xml:"id,attr"`
+	Start         string           `xml:"start,attr"`
+	AdaptationSet mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int               `xml:"id,attr"`
+	ContentType      string            `xml:"contentType,attr"`
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	Representation   mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                string         `xml:"id,attr"`
+	Bandwidth         int            `xml:"bandwidth,attr"`
+	Codecs            string         `xml:"codecs,attr"`
+	AudioSamplingRate int            `xml:"audioSamplingRate,attr,omitempty"`
+	SegmentList       mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdSegmentList struct {
+	Timescale      int             `xml:"timescale,attr"`
+	StartNumber    int             `xml:"startNumber,attr"`
+	Initialization mpdURL          `xml:"Initialization"`
+	Timeline       []mpdS          `xml:"SegmentTimeline>S"`
+	SegmentURLs    []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// mpdS is an S element of a SegmentTimeline: r more segments follow with the same d
+type mpdS struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+	R int   `xml:"r,attr,omitempty"`
+}
+
+// dashPeriod is a run of segments between two discontinuities
+type dashPeriod struct {
+	id       int     // discontinuity sequence number
+	start    float64 // presentation time of the discontinuity
+	first    int     // media sequence number of segments[0]
+	segments []segment
+	times    []float64 // presentation time of each segment
+}
+
+// describable reports whether the period can be described in an MPD: every segment is
+// fragmented MP4 in the clear with the same initialization section
+func (dp dashPeriod) describable() bool {
+	for _, seg := range dp.segments {
+		if seg.initURI == "" || seg.initURI != dp.segments[0].initURI || seg.key != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *DASHFormatter) Format(p *playlist) (PlaylistContent, error) {
+	p.rwmu.RLock()
+	defer p.rwmu.RUnlock()
+
+	var periods []dashPeriod
+	disconSeq := p.metadata.discontinuitySequence
+	t := p.metadata.startTime
+	for i, seg := range p.segments {
+		if seg.discontinuity {
+			disconSeq++
+		}
+		if i == 0 || seg.discontinuity {
+			start := t
+			if i == 0 {
+				start = p.metadata.periodStart
+			}
+			periods = append(periods, dashPeriod{id: disconSeq, start: start, first: p.metadata.mediaSequence + i})
+		}
+		dp := &periods[len(periods)-1]
+		dp.segments = append(dp.segments, seg)
+		dp.times = append(dp.times, t)
+		t += seg.duration
+	}
+	windowStart, end := p.metadata.startTime, t
+
+	m := mpd{
+		Profiles: dashProfile,
+		Type:     "dynamic",
+		// ウィンドウが初めて埋まったときに一度だけ決まる。公開はセグメントの長さより
+		// 遅れないので、プレイヤーがまだないセグメントを取りに行くことはない
+		AvailabilityStartTime:      formatMPDTime(p.availabilityStart),
+		PublishTime:                formatMPDTime(p.updatedAt),
+		MinimumUpdatePeriod:        formatMPDDuration(p.config.TargetDuration),
+		MinBufferTime:              formatMPDDuration(p.config.TargetDuration),
+		TimeShiftBufferDepth:       formatMPDDuration(end - windowStart),
+		SuggestedPresentationDelay: formatMPDDuration(3 * p.config.TargetDuration),
+		MaxSegmentDuration:         formatMPDDuration(p.config.TargetDuration),
+	}
+	for _, dp := range periods {
+		if !dp.describable() {
+			continue
+		}
+		m.Periods = append(m.Periods, f.period(dp))
+	}
+	if len(m.Periods) == 0 {
+		return nil, ErrNoDASHSegments
+	}
+
+	body, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode mpd: %w", err)
+	}
+	return &DefaultPlaylistContent{data: append(append([]byte(xml.Header), body...), '\n')}, nil
+}
+
+func (f *DASHFormatter) period(dp dashPeriod) mpdPeriod {
+	// 境界をミリ秒に丸めてから差を取り、丸め誤差が積もらないようにする
+	origin := toTimescale(dp.start)
+	list := mpdSegmentList{
+		Timescale:      dashTimescale,
+		StartNumber:    dp.first,
+		Initialization: mpdURL{SourceURL: dp.segments[0].initURI},
+	}
+	for i, seg := range dp.segments {
+		start := toTimescale(dp.times[i])
+		d := toTimescale(dp.times[i]+seg.duration) - start
+		if n := len(list.Timeline); n > 0 && list.Timeline[n-1].D == d {
+			list.Timeline[n-1].R++
+		} else {
+			list.Timeline = append(list.Timeline, mpdS{T: start - origin, D: d})
+		}
+		list.SegmentURLs = append(list.SegmentURLs, mpdSegmentURL{Media: seg.uri})
+	}
+	return mpdPeriod{
+		ID:    strconv.Itoa(dp.id),
+		Start: formatMPDDuration(dp.start),
+		AdaptationSet: mpdAdaptationSet{
+			ContentType:      "audio",
+			MimeType:         "audio/mp4",
+			SegmentAlignment: true,
+			Representation: mpdRepresentation{
+				ID:                "audio",
+				Bandwidth:         cmp.Or(f.Bandwidth, defaultBandwidth),
+				Codecs:            cmp.Or(f.Codecs, defaultCodecs),
+				AudioSamplingRate: f.SamplingRate,
+				SegmentList:       list,
+			},
+		},
+	}
+}
+
+// Parse is not supported: MPDs are only rendered
+func (f *DASHFormatter) Parse(content PlaylistContent) (*playlist, error) {
+	return nil, fmt.Errorf("parse mpd: %w", errors.ErrUnsupported)
+}
+
+func toTimescale(seconds float64) int64 {
+	return int64(math.Round(seconds * dashTimescale))
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
+
+// formatMPDTime formats t as an xs:dateTime in UTC with milliseconds
+func formatMPDTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// formatMPDDuration formats seconds as an xs:duration such as PT6.006S
+func formatMPDDuration(seconds float64) string {
+	return "PT" + strconv.FormatFloat(math.Round(seconds*1000)/1000, 'f', -1, 64) + "S"
+}
+
+// DASH serves the live playlist of a station as an MPEG-DASH manifest, rendered with a
+// DASHFormatter after every published segment
+type DASH struct {
+	playlist  *playlist
+	formatter *DASHFormatter
+	logger    *slog.Logger
+
+	// snapshot is nil while no segment of the window can be described
+	snapshot atomic.Pointer[RenderedPlaylist]
+	requests atomic.Int64
+}
+
+// EnableDASH makes the station render its live playlist with f after every published
+// segment and returns the DASH serving it. It must be called before Start.
+func (s *Station) EnableDASH(f *DASHFormatter) *DASH {
+	if f == nil {
+		f = &DASHFormatter{}
+	}
+	d := &DASH{
+		playlist:  s.playlist,
+		formatter: f,
+		logger:    s.playlist.logger,
+	}
+	s.dash = d
+	s.Observe(d)
+	return d
+}
+
+// SegmentPublished implements SegmentObserver
+func (d *DASH) SegmentPublished(seg PublishedSegment) {
+	r, err := d.playlist.render(d.formatter)
+	if err != nil {
+		if !errors.Is(err, ErrNoDASHSegments) {
+			d.logger.Error("failed to render mpd", "error", err)
+		}
+		d.snapshot.Store(nil)
+		return
+	}
+	r.ContentType = DASHContentType
+	d.snapshot.Store(r)
+}
+
+// Render returns the MPD rendered when the last segment was published, or
+// ErrNoDASHSegments while the window has no fMP4 segments
+func (d *DASH) Render() (*RenderedPlaylist, error) {
+	if r := d.snapshot.Load(); r != nil {
+		return r, nil
+	}
+	return nil, ErrNoDASHSegments
+}
+
+// Handler serves the MPD with the same caching headers as the live playlist. While the
+// window has no fMP4 segments, e.g. during filler, it answers 503 so that players retry.
+func (d *DASH) Handler() http.Handler {
+	serve := servePlaylist(d.Render, &d.requests, d.logger)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.snapshot.Load() == nil {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Retry-After", strconv.Itoa(playlistMaxAge(d.playlist.config.TargetDuration)))
+			http.Error(w, "No fMP4 segments in the live window", http.StatusServiceUnavailable)
+			return
+		}
+		serve.ServeHTTP(w, r)
+	})
+}
+
+// DASHStats is a point-in-time snapshot of a DASH
+type DASHStats struct {
+	// Available is false while the window has no fMP4 segments
+	Available bool
+	Requests  int64
+}
+
+func (d *DASH) Stats() DASHStats {
+	return DASHStats{
+		Available: d.snapshot.Load() != nil,
+		Requests:  d.requests.Load(),
+	}
+}
@@ -0,0 +1,170 @@
+package hls
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fmp4Segment(duration float64, uri string, initURI string, discontinuity bool) segment {
+	seg := NewSegment(duration, uri, discontinuity)
+	seg.initURI = initURI
+	return seg
+}
+
+func TestDASHFormatter(t *testing.T) {
+	s := newTestStation(PlaylistConfig{MaxSegments: 4, TargetDuration: 6})
+	dash := s.EnableDASH(nil)
+	var filledAST string
+	for i, seg := range []segment{
+		fmp4Segment(6, "/contents/a/0.m4s", "/contents/a/init.mp4", true),
+		fmp4Segment(6, "/contents/a/1.m4s", "/contents/a/init.mp4", false),
+		fmp4Segment(4, "/contents/a/2.m4s", "/contents/a/init.mp4", false),
+		NewSegment(6, "/stations/test/silence.ts", true),
+		fmp4Segment(6, "/contents/b/0.m4s", "/contents/b/init.mp4", true),
+		fmp4Segment(6, "/contents/b/1.m4s", "/contents/b/init.mp4", false),
+	} {
+		s.playlist.Update(seg)
+		if i == 3 { // the window is full
+			rendered, err := dash.Render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m mpd
+			if err := xml.Unmarshal(rendered.Content.Bytes(), &m); err != nil {
+				t.Fatal(err)
+			}
+			filledAST = m.AvailabilityStartTime
+		}
+	}
+
+	rendered, err := dash.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m mpd
+	if err := xml.Unmarshal(rendered.Content.Bytes(), &m); err != nil {
+		t.Fatalf("invalid mpd: %v\n%s", err, rendered.Content)
+	}
+	if m.Type != "dynamic" || m.TimeShiftBufferDepth != "PT22S" || m.MinimumUpdatePeriod != "PT6S" {
+		t.Errorf("MPD attributes = %+v", m)
+	}
+	// availabilityStartTime is fixed when the window first fills, with the end of its
+	// newest segment (22s) published then
+	if m.AvailabilityStartTime != filledAST {
+		t.Errorf("availabilityStartTime moved from %s to %s", filledAST, m.AvailabilityStartTime)
+	}
+	ast, _ := time.Parse(time.RFC3339, m.AvailabilityStartTime)
+	published, _ := time.Parse(time.RFC3339, m.PublishTime)
+	if d := published.Sub(ast); d < 12*time.Second || d > 34*time.Second {
+		t.Errorf("publishTime %s is %v after availabilityStartTime %s, want inside the window [12s, 34s]", m.PublishTime, d, m.AvailabilityStartTime)
+	}
+
+	// segments 0 and 1 left the window; the filler between the contents is MPEG-TS
+	want := []struct {
+		id, start   string
+		startNumber int
+		init        string
+		timeline    []mpdS
+		media       []string
+	}{
+		{"1", "PT0S", 2, "/contents/a/init.mp4", []mpdS{{T: 12000, D: 4000}}, []string{"/contents/a/2.m4s"}},
+		{"3", "PT22S", 4, "/contents/b/init.mp4", []mpdS{{T: 0, D: 6000, R: 1}}, []string{"/contents/b/0.m4s", "/contents/b/1.m4s"}},
+	}
+	if len(m.Periods) != len(want) {
+		t.Fatalf("%d periods, want %d\n%s", len(m.Periods), len(want), rendered.Content)
+	}
+	for i, w := range want {
+		p := m.Periods[i]
+		list := p.AdaptationSet.Representation.SegmentList
+		if p.ID != w.id || p.Start != w.start || list.StartNumber != w.startNumber || list.Initialization.SourceURL != w.init {
+			t.Errorf("period %d = id %s, start %s, startNumber %d, init %s", i, p.ID, p.Start, list.StartNumber, list.Initialization.SourceURL)
+		}
+		if len(list.Timeline) != len(w.timeline) {
+			t.Errorf("period %d timeline = %+v, want %+v", i, list.Timeline, w.timeline)
+		} else {
+			for j := range w.timeline {
+				if list.Timeline[j] != w.timeline[j] {
+					t.Errorf("period %d timeline = %+v, want %+v", i, list.Timeline, w.timeline)
+				}
+			}
+		}
+		var media []string
+		for _, u := range list.SegmentURLs {
+			media = append(media, u.Media)
+		}
+		if strings.Join(media, " ") != strings.Join(w.media, " ") {
+			t.Errorf("period %d segments = %v, want %v", i, media, w.media)
+		}
+	}
+	if rep := m.Periods[0].AdaptationSet.Representation; rep.Codecs != defaultCodecs || rep.Bandwidth != defaultBandwidth {
+		t.Errorf("representation = %+v", rep)
+	}
+}
+
+func TestDASHFormatter_NoFMP4(t *testing.T) {
+	p := NewPlaylist(PlaylistConfig{MaxSegments: 3, TargetDuration: 6})
+	p.Update(NewSegment(6, "/contents/a/0.ts", true))
+	encrypted := fmp4Segment(6, "/contents/b/0.m4s", "/contents/b/init.mp4", true)
+	encrypted.key = &segmentKey{method: keyMethodAES128, uri: "/keys/k1"}
+	p.Update(encrypted)
+	if _, err := (&DASHFormatter{}).Format(p); !errors.Is(err, ErrNoDASHSegments) {
+		t.Errorf("Format() error = %v, want ErrNoDASHSegments", err)
+	}
+}
+
+func TestDASHHandler(t *testing.T) {
+	s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 6})
+	handler := s.EnableDASH(nil).Handler()
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations/test/stream.mpd", nil))
+		return rec
+	}
+
+	s.playlist.Update(NewSegment(6, "/stations/test/silence.ts", true))
+	if rec := serve(); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("without fMP4 segments: %d %v", rec.Code, rec.Header())
+	}
+
+	s.playlist.Update(fmp4Segment(6, "/contents/a/0.m4s", "/contents/a/init.mp4", true))
+	rec := serve()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != DASHContentType || rec.Header().Get("ETag") == "" {
+		t.Errorf("with fMP4 segments: %d %v", rec.Code, rec.Header())
+	}
+	if stats := s.dash.Stats(); !stats.Available || stats.Requests != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestFormat_EXTXMAP(t *testing.T) {
+	p := NewPlaylist(PlaylistConfig{MaxSegments: 4, TargetDuration: 6})
+	p.Update(fmp4Segment(6, "/contents/a/0.m4s", "/contents/a/init.mp4", true))
+	p.Update(fmp4Segment(6, "/contents/a/1.m4s", "/contents/a/init.mp4", false))
+	p.Update(fmp4Segment(6, "/contents/b/0.m4s", "/contents/b/init.mp4", true))
+
+	c, err := (&DefaultPlaylistFormatter{}).Format(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(c.String(), "#EXT-X-MAP:"); got != 2 {
+		t.Errorf("%d EXT-X-MAP tags, want one per content\n%s", got, c)
+	}
+	if !strings.Contains(c.String(), "#EXT-X-VERSION:6\n") {
+		t.Errorf("EXT-X-MAP needs version 6\n%s", c)
+	}
+
+	parsed, err := (&DefaultPlaylistFormatter{}).Parse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"/contents/a/init.mp4", "/contents/a/init.mp4", "/contents/b/init.mp4"} {
+		if parsed.segments[i].initURI != want {
+			t.Errorf("segment %d initURI = %q, want %q", i, parsed.segments[i].initURI, want)
+		}
+	}
+}
@@ -1,65 +1,121 @@
 package hls
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"log/slog"
 )
 
-const sleepTime = 10
+// maxConsecutiveRejects is how many contents in a row may fail the pre-flight check or
+// fail to load before the dj gives up and lets the supervisor restart it
+const maxConsecutiveRejects = 5
 
 type dj struct {
 	manager StreamManager
+	logger  *slog.Logger
+
+	logicMu sync.Mutex
 	logic   logic
+
+	choiceFailures atomic.Int64
+	added          atomic.Int64 // contents queued
+	running        atomic.Bool
+
+	errMu     sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
 }
 
-func (d *dj) Start() {
+// Start queues contents until ctx is done or the manager is killed, in which case it returns nil.
+// Any other failure stops the dj and is returned so that a supervisor can restart it.
+func (d *dj) Start(ctx context.Context) error {
+	d.running.Store(true)
+	defer d.running.Store(false)
+
 	go d.manager.Run()
 
+	rejects := 0
 	for {
-		content, err := d.logic.Choice()
+		content, err := d.currentLogic().Choice()
 		if err != nil {
-			slog.Error("failed to choose content", "error", err)
-			return
+			d.choiceFailures.Add(1)
+			d.recordError(err)
+			d.logger.Error("failed to choose content", "error", err)
+			return err
 		}
 
-		for {
-			err = d.manager.Add(content)
-
-			if err == nil {
-				// 追加成功したら次のコンテンツを選ぶ
-				slog.Info("added content", "content_id", content.id)
-				break
+		// バッファに空きができるまでAddがブロックする
+		if err := d.manager.Add(ctx, content); err != nil {
+			if errors.Is(err, ErrManagerKilled) || errors.Is(err, context.Canceled) {
+				d.logger.Info("dj stopped", "reason", err)
+				return nil
 			}
-			if errors.Is(err, ErrBufferFull) {
-				slog.Info("buffer is full, retrying", "content_id", content.id)
-				// バッファが一杯なら待機して再試行（同じコンテンツを使用）
-				time.Sleep(time.Duration(sleepTime) * time.Second)
-				slog.Info("wakup", "content_id", content.id)
+			d.recordError(err)
+			skippable := errors.Is(err, ErrPreflightFailed) || errors.Is(err, ErrContentUnavailable)
+			if skippable && rejects+1 < maxConsecutiveRejects {
+				// 壊れたコンテンツは飛ばして次を選ぶ
+				rejects++
+				d.logger.Warn("skipped content", "content_id", content.ID(), "error", err)
 				continue
 			}
-			// その他のエラーは即座に終了
-			slog.Error("failed to add content", "error", err)
-			return
+			d.logger.Error("failed to add content", "content_id", content.ID(), "error", err)
+			return err
 		}
+		rejects = 0
+		d.added.Add(1)
+		d.logger.Info("added content", "content_id", content.ID())
 	}
 }
 
+func (d *dj) currentLogic() logic {
+	d.logicMu.Lock()
+	defer d.logicMu.Unlock()
+	return d.logic
+}
+
+func (d *dj) setLogic(l logic) {
+	d.logicMu.Lock()
+	defer d.logicMu.Unlock()
+	d.logic = l
+}
+
+func (d *dj) recordError(err error) {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+	d.lastErr = err
+	d.lastErrAt = time.Now()
+}
+
+// lastError returns the most recent error that stopped or degraded the dj
+func (d *dj) lastError() (time.Time, error) {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+	return d.lastErrAt, d.lastErr
+}
+
 type logic interface {
-	Choice() (content, error)
+	Choice() (Content, error)
+	// Len returns the number of contents the logic can choose from
+	Len() int
 }
 
 type randomLogic struct {
 	contents []content
 }
 
-func (rl randomLogic) Choice() (content, error) {
+func (rl randomLogic) Choice() (Content, error) {
 	if len(rl.contents) == 0 {
-		return content{}, fmt.Errorf("contents is empty")
+		return nil, fmt.Errorf("contents is empty")
 	}
 
 	return rl.contents[rand.Intn(len(rl.contents))], nil
 }
+
+func (rl randomLogic) Len() int {
+	return len(rl.contents)
+}
@@ -0,0 +1,203 @@
+package hls
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DVR keeps the segments a station published within a time window so that listeners can
+// rewind. It is served as a sliding-window playlist: an EVENT playlist could not drop
+// segments that fall out of the window. Media and discontinuity sequence numbers are
+// those of the live playlist, so players can switch between the two. Segment and key
+// URIs are rewritten again every time the playlist is rendered, so that signatures do
+// not expire while segments are still in the window.
+type DVR struct {
+	window         float64 // seconds
+	targetDuration float64
+	formatter      PlaylistFormatter
+	logger         *slog.Logger
+	rewriter       URLRewriter // of the station's segments; nil when they are not rewritten
+	keyRewriter    URLRewriter // of the station's EXT-X-KEY URIs
+
+	mu       sync.Mutex
+	ring     segmentRing
+	duration float64 // of the segments in ring
+
+	snapshot atomic.Pointer[RenderedPlaylist]
+	requests atomic.Int64
+}
+
+// EnableDVR makes the station keep the last window of published segments and returns the
+// DVR serving them. It must be called before Start.
+func (s *Station) EnableDVR(window time.Duration) *DVR {
+	d := &DVR{
+		window:         window.Seconds(),
+		targetDuration: s.playlist.config.TargetDuration,
+		formatter:      s.formatter,
+		logger:         s.playlist.logger,
+	}
+	if s.manager != nil {
+		d.rewriter = s.manager.config.URLRewriter
+		if s.manager.config.Encryption != nil {
+			d.keyRewriter = s.manager.config.Encryption.config.KeyURLRewriter
+		}
+	}
+	// 1セグメント = TargetDuration として初期容量を見積もる
+	if s.playlist.config.TargetDuration > 0 {
+		d.ring.grow(int(d.window/s.playlist.config.TargetDuration) + 1)
+	}
+	s.dvr = d
+	s.Observe(d)
+	return d
+}
+
+// SegmentPublished implements SegmentObserver
+func (d *DVR) SegmentPublished(seg PublishedSegment) {
+	d.mu.Lock()
+	d.ring.push(seg)
+	d.duration += seg.Duration
+	// 最新のセグメントは常に残す
+	for d.ring.len() > 1 && d.duration-d.ring.at(0).Duration >= d.window {
+		d.duration -= d.ring.popFront().Duration
+	}
+	p := d.playlistLocked()
+	d.mu.Unlock()
+
+	c, err := d.formatter.Format(p)
+	if err != nil {
+		d.logger.Error("failed to render dvr playlist", "error", err)
+		return
+	}
+	d.snapshot.Store(newRenderedPlaylist(c, seg.MediaSequence+1, seg.PublishedAt, d.targetDuration))
+}
+
+// playlistLocked builds a playlist of the buffered segments; the caller must hold mu
+func (d *DVR) playlistLocked() *playlist {
+	p := &playlist{
+		metadata:  playlistMetadata{version: 3, targetDuration: d.targetDuration},
+		segments:  make([]segment, 0, d.ring.len()),
+		formatter: d.formatter,
+	}
+	if d.ring.len() == 0 {
+		return p
+	}
+	first := d.ring.at(0)
+	p.metadata.mediaSequence = first.MediaSequence
+	// DiscontinuitySequence は自身の DISCONTINUITY を含むので、先頭の分を引く
+	p.metadata.discontinuitySequence = first.DiscontinuitySequence
+	if first.Discontinuity {
+		p.metadata.discontinuitySequence--
+	}
+	keys := make(map[*segmentKey]*segmentKey)
+	for i := range d.ring.len() {
+		seg := d.ring.at(i)
+		uri, initURI, key := seg.URI, seg.initURI, seg.key
+		// 公開時の署名は TTL で切れるので、巻き戻せる間は描画のたびに付け直す
+		if d.rewriter != nil && seg.rawURI != "" {
+			uri = d.rewriter.RewriteURL(seg.rawURI)
+			if seg.SourceInitURI != "" {
+				initURI = d.rewriter.RewriteURL(seg.SourceInitURI)
+			}
+		}
+		if d.keyRewriter != nil && key != nil && key.rawURI != "" {
+			if keys[key] == nil {
+				rewritten := *key
+				rewritten.uri = d.keyRewriter.RewriteURL(key.rawURI)
+				keys[key] = &rewritten
+			}
+			key = keys[key]
+		}
+		p.segments = append(p.segments, segment{
+			duration:      seg.Duration,
+			uri:           uri,
+			discontinuity: seg.Discontinuity,
+			contentID:     seg.ContentID,
+			key:           key,
+			initURI:       initURI,
+		})
+	}
+	return p
+}
+
+// Render returns the DVR playlist rendered when the last segment was published
+func (d *DVR) Render() (*RenderedPlaylist, error) {
+	if r := d.snapshot.Load(); r != nil {
+		return r, nil
+	}
+	d.mu.Lock()
+	p := d.playlistLocked()
+	d.mu.Unlock()
+	c, err := d.formatter.Format(p)
+	if err != nil {
+		return nil, err
+	}
+	return newRenderedPlaylist(c, 0, time.Time{}, d.targetDuration), nil
+}
+
+// Handler serves the DVR playlist with the same caching headers as the live playlist
+func (d *DVR) Handler() http.Handler {
+	return servePlaylist(d.Render, &d.requests, d.logger)
+}
+
+// DVRStats is a point-in-time snapshot of a DVR
+type DVRStats struct {
+	Segments        int
+	BufferedSeconds float64
+	Requests        int64
+}
+
+func (d *DVR) Stats() DVRStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DVRStats{
+		Segments:        d.ring.len(),
+		BufferedSeconds: d.duration,
+		Requests:        d.requests.Load(),
+	}
+}
+
+// segmentRing is a growable ring buffer of published segments
+type segmentRing struct {
+	buf  []PublishedSegment
+	head int
+	n    int
+}
+
+func (r *segmentRing) len() int {
+	return r.n
+}
+
+func (r *segmentRing) at(i int) PublishedSegment {
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+func (r *segmentRing) push(seg PublishedSegment) {
+	if r.n == len(r.buf) {
+		r.grow(max(2*len(r.buf), 16))
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = seg
+	r.n++
+}
+
+func (r *segmentRing) popFront() PublishedSegment {
+	seg := r.buf[r.head]
+	r.buf[r.head] = PublishedSegment{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+	return seg
+}
+
+// grow reallocates the buffer with room for capacity segments, keeping their order
+func (r *segmentRing) grow(capacity int) {
+	if capacity <= len(r.buf) {
+		return
+	}
+	buf := make([]PublishedSegment, capacity)
+	for i := range r.n {
+		buf[i] = r.at(i)
+	}
+	r.buf, r.head = buf, 0
+}
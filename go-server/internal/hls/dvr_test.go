@@ -0,0 +1,148 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDVR(t *testing.T) {
+	key := &segmentKey{method: keyMethodAES128, uri: "/keys/k1", iv: "0x00"}
+	publish := func(s *Station, n int) {
+		for i := range n {
+			seg := NewSegment(10, "/contents/"+strconv.Itoa(i)+".ts", i%4 == 0)
+			if i >= 6 {
+				seg.key = key
+			}
+			s.playlist.Update(seg)
+		}
+	}
+
+	t.Run("same window as the live playlist", func(t *testing.T) {
+		s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+		dvr := s.EnableDVR(30 * time.Second)
+		publish(s, 10)
+
+		live, _ := s.Render()
+		rewind, err := dvr.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewind.Content.String() != live.Content.String() {
+			t.Errorf("dvr playlist differs from the live playlist\ndvr:\n%s\nlive:\n%s", rewind.Content, live.Content)
+		}
+		if rewind.ETag != live.ETag {
+			t.Errorf("dvr ETag = %s, want %s", rewind.ETag, live.ETag)
+		}
+	})
+
+	t.Run("longer window", func(t *testing.T) {
+		s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+		dvr := s.EnableDVR(60 * time.Second)
+		publish(s, 10)
+		if stats := dvr.Stats(); stats.Segments != 6 || stats.BufferedSeconds != 60 {
+			t.Errorf("Stats() = %+v, want 6 segments and 60s", stats)
+		}
+
+		rendered, _ := dvr.Render()
+		parsed, err := (&DefaultPlaylistFormatter{}).Parse(rendered.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// segments 4..9 remain; segment 0 had the only discontinuity before them
+		if parsed.metadata.mediaSequence != 4 || parsed.metadata.discontinuitySequence != 1 {
+			t.Errorf("media sequence = %d, discontinuity sequence = %d, want 4 and 1",
+				parsed.metadata.mediaSequence, parsed.metadata.discontinuitySequence)
+		}
+		if len(parsed.segments) != 6 || parsed.segments[0].uri != "/contents/4.ts" || !parsed.segments[0].discontinuity {
+			t.Fatalf("segments = %+v", parsed.segments)
+		}
+		for i, seg := range parsed.segments {
+			if wantKey := i >= 2; (seg.key != nil) != wantKey {
+				t.Errorf("segment %s key = %+v, want encrypted %v", seg.uri, seg.key, wantKey)
+			}
+		}
+	})
+
+	t.Run("urls are signed again on every render", func(t *testing.T) {
+		s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+		signer := &versionRewriter{}
+		s.manager = &playlistManager{config: ManagerConfig{
+			URLRewriter: signer,
+			Encryption:  &KeyRotator{config: EncryptionConfig{KeyURLRewriter: signer}},
+		}}
+		dvr := s.EnableDVR(time.Hour)
+		key := &segmentKey{method: keyMethodAES128, uri: "/keys/k1?v=0", rawURI: "/keys/k1", iv: "0x00"}
+		for i := range 5 {
+			seg := NewSegment(10, "/contents/"+strconv.Itoa(i)+".ts?v=0", i == 0)
+			seg.rawURI = "/contents/" + strconv.Itoa(i) + ".ts"
+			seg.key = key
+			s.playlist.Update(seg)
+		}
+		// filler is served by this server and never rewritten
+		s.playlist.Update(segment{duration: 10, uri: "/stations/test/silence.ts", discontinuity: true, contentID: fillerContentID, filler: true})
+
+		rendered, _ := dvr.Render()
+		parsed, err := (&DefaultPlaylistFormatter{}).Parse(rendered.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed.segments) != 6 {
+			t.Fatalf("segments = %+v", parsed.segments)
+		}
+		for i, seg := range parsed.segments[:5] {
+			if want := "/contents/" + strconv.Itoa(i) + ".ts?v="; !strings.HasPrefix(seg.uri, want) || seg.uri == want+"0" {
+				t.Errorf("segment %d uri = %q, want it signed again", i, seg.uri)
+			}
+			if seg.key == nil || !strings.HasPrefix(seg.key.uri, "/keys/k1?v=") || seg.key.uri == "/keys/k1?v=0" {
+				t.Errorf("segment %d key = %+v, want it signed again", i, seg.key)
+			}
+		}
+		if uri := parsed.segments[5].uri; uri != "/stations/test/silence.ts" {
+			t.Errorf("filler uri = %q", uri)
+		}
+		// one EXT-X-KEY for the segments that share a key
+		if n := strings.Count(rendered.Content.String(), "#EXT-X-KEY"); n != 2 {
+			t.Errorf("playlist has %d EXT-X-KEY tags, want the key and METHOD=NONE before the filler\n%s", n, rendered.Content)
+		}
+	})
+
+	t.Run("ring buffer grows past its estimate", func(t *testing.T) {
+		s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+		dvr := s.EnableDVR(time.Hour)
+		for i := range 100 {
+			s.playlist.Update(NewSegment(2, "/contents/"+strconv.Itoa(i)+".ts", i == 0))
+		}
+		rendered, _ := dvr.Render()
+		if stats := dvr.Stats(); stats.Segments != 100 {
+			t.Errorf("Segments = %d, want 100", stats.Segments)
+		}
+		if !strings.Contains(rendered.Content.String(), "#EXT-X-MEDIA-SEQUENCE:0\n") ||
+			!strings.HasSuffix(rendered.Content.String(), "/contents/99.ts\n") {
+			t.Errorf("dvr playlist =\n%s", rendered.Content)
+		}
+
+		rec := httptest.NewRecorder()
+		dvr.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations/test/dvr.m3u8", nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") != seqETag(100) || rec.Body.String() != rendered.Content.String() {
+			t.Errorf("handler = %d %q", rec.Code, rec.Header().Get("ETag"))
+		}
+		if got := dvr.Stats().Requests; got != 1 {
+			t.Errorf("DVR requests = %d, want 1", got)
+		}
+	})
+}
+
+// versionRewriter appends an increasing version to every URI it rewrites, as a signer
+// appends a later expiry
+type versionRewriter struct {
+	n int
+}
+
+func (r *versionRewriter) RewriteURL(uri string) string {
+	r.n++
+	return uri + "?v=" + strconv.Itoa(r.n)
+}
@@ -0,0 +1,365 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+)
+
+var (
+	// ErrNotEncryptable is returned for segments that cannot be encrypted on the fly:
+	// those not served from the contents root, such as absolute CDN URLs, and fragmented
+	// MP4, which AES-128 whole-segment encryption does not apply to
+	ErrNotEncryptable = errors.New("segment cannot be encrypted")
+	ErrKeyNotFound    = errors.New("encryption key not found")
+)
+
+const (
+	defaultKeyRotation = 10
+	// keyIDLength is the length of the hexadecimal key IDs used in key and segment URLs
+	keyIDLength = 16
+	// keyPruneInterval is how often Run deletes expired keys
+	keyPruneInterval = time.Hour
+)
+
+// EncryptionConfig configures AES-128 encryption of a station's segments. Every segment
+// published under a key is encrypted as a whole (AES-128-CBC with PKCS#7 padding) when it
+// is requested, so the contents on disk stay in the clear. SAMPLE-AES is not supported.
+type EncryptionConfig struct {
+	// Station names the keys in Storage
+	Station string
+	// Storage keeps the keys so that segments stay playable across key rotations and restarts
+	Storage PlaylistStorage
+	// KeyRetention is how long keys are kept after they were created. It must cover every
+	// playlist that can still refer to a key, such as a DVR window. Zero keeps them forever;
+	// Storage must implement KeyPruner otherwise.
+	KeyRetention time.Duration
+	// RotateEvery is the number of segments encrypted with one key; defaultKeyRotation when <= 0
+	RotateEvery int
+	// KeyURLPrefix is the URL path KeyHandler is served under, e.g. "/stations/proseka/keys"
+	KeyURLPrefix string
+	// SegmentURLPrefix is the URL path SegmentHandler is served under, e.g. "/stations/proseka/segments"
+	SegmentURLPrefix string
+	// KeyURLRewriter rewrites the key URIs written to EXT-X-KEY, e.g. to sign them. Nil keeps them.
+	KeyURLRewriter URLRewriter
+	// Root is the contents root on disk; contentsRootDir when empty
+	Root string
+	// URLPrefix is the URL path Root is served under; contentsURLPrefix when empty
+	URLPrefix string
+	// Rand is the source of keys and IVs; crypto/rand when nil
+	Rand io.Reader
+}
+
+// KeyPruner is implemented by key storages that can delete old keys, such as FileStorage
+type KeyPruner interface {
+	Prune(prefix string, before time.Time) (int, error)
+}
+
+// KeyRotator assigns AES-128 keys to the segments of a station, switching to a new key
+// every RotateEvery segments, and serves the keys and the encrypted segments
+type KeyRotator struct {
+	config EncryptionConfig
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	current   *segmentKey
+	currentID string
+	currentAt time.Time // when current was created
+	used      int       // segments assigned to current
+
+	keysCreated       atomic.Int64
+	keysPruned        atomic.Int64
+	keyRequests       atomic.Int64
+	segmentsEncrypted atomic.Int64
+}
+
+func NewKeyRotator(config EncryptionConfig) (*KeyRotator, error) {
+	if config.Storage == nil {
+		return nil, errors.New("encryption requires a key storage")
+	}
+	if _, ok := config.Storage.(KeyPruner); config.KeyRetention > 0 && !ok {
+		return nil, fmt.Errorf("key storage %T cannot delete expired keys", config.Storage)
+	}
+	if !strings.HasPrefix(config.KeyURLPrefix, "/") || !strings.HasPrefix(config.SegmentURLPrefix, "/") {
+		return nil, fmt.Errorf("key URL prefix %q and segment URL prefix %q must start with /",
+			config.KeyURLPrefix, config.SegmentURLPrefix)
+	}
+	config.KeyURLPrefix = strings.TrimSuffix(config.KeyURLPrefix, "/")
+	config.SegmentURLPrefix = strings.TrimSuffix(config.SegmentURLPrefix, "/")
+	if config.RotateEvery <= 0 {
+		config.RotateEvery = defaultKeyRotation
+	}
+	if config.Root == "" {
+		config.Root = contentsRootDir
+	}
+	if config.URLPrefix == "" {
+		config.URLPrefix = contentsURLPrefix
+	}
+	config.URLPrefix = strings.TrimSuffix(config.URLPrefix, "/")
+	if config.Rand == nil {
+		config.Rand = rand.Reader
+	}
+	return &KeyRotator{config: config, logger: slog.Default()}, nil
+}
+
+func (k *KeyRotator) SetLogger(logger *slog.Logger) {
+	k.logger = logger
+}
+
+// checkEncryptable reports whether every segment can be served by SegmentHandler
+func (k *KeyRotator) checkEncryptable(segs []segment) error {
+	for _, seg := range segs {
+		if seg.initURI != "" {
+			// fMP4 は SAMPLE-AES (cbcs) でなければならず、セグメントごとの AES-128 は使えない
+			return fmt.Errorf("%w: %s is fragmented MP4", ErrNotEncryptable, seg.uri)
+		}
+		if _, ok := k.localPath(seg.uri); !ok {
+			return fmt.Errorf("%w: %s is not under %s", ErrNotEncryptable, seg.uri, k.config.URLPrefix)
+		}
+	}
+	return nil
+}
+
+// encrypt assigns the current key to seg, rotating it when it has been used RotateEvery
+// times, and points seg at the encrypted copy served by SegmentHandler
+func (k *KeyRotator) encrypt(seg segment) (segment, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.current == nil || k.used >= k.config.RotateEvery {
+		id, key, err := k.newKey()
+		if err != nil {
+			return seg, err
+		}
+		k.current, k.currentID, k.currentAt = key, id, time.Now()
+		k.used = 0
+	}
+	k.used++
+
+	seg.key = k.current
+	seg.uri = k.config.SegmentURLPrefix + "/" + k.currentID + seg.uri
+	return seg, nil
+}
+
+// newKey generates a key and IV, stores them and returns the key ID and the EXT-X-KEY
+// that refers to them
+func (k *KeyRotator) newKey() (string, *segmentKey, error) {
+	material := make([]byte, 2*aes.BlockSize+keyIDLength/2)
+	if _, err := io.ReadFull(k.config.Rand, material); err != nil {
+		return "", nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	keyIV, rawID := material[:2*aes.BlockSize], material[2*aes.BlockSize:]
+	id := hex.EncodeToString(rawID)
+
+	// 鍵とIVを並べて保存する（鍵エンドポイントは鍵だけを返す）
+	if err := k.config.Storage.Store(k.storageKey(id), &DefaultPlaylistContent{data: keyIV}); err != nil {
+		return "", nil, fmt.Errorf("failed to store encryption key %s: %w", id, err)
+	}
+	k.keysCreated.Add(1)
+	k.logger.Info("rotated encryption key", "key_id", id)
+
+	uri, rawURI := k.config.KeyURLPrefix+"/"+id, ""
+	if k.config.KeyURLRewriter != nil {
+		uri, rawURI = k.config.KeyURLRewriter.RewriteURL(uri), uri
+	}
+	return id, &segmentKey{
+		method: keyMethodAES128,
+		uri:    uri,
+		rawURI: rawURI,
+		iv:     "0x" + hex.EncodeToString(keyIV[aes.BlockSize:]),
+	}, nil
+}
+
+func (k *KeyRotator) storageKey(id string) string {
+	return k.config.Station + "-" + id + ".key"
+}
+
+// Run deletes the keys older than KeyRetention every hour until ctx is done
+func (k *KeyRotator) Run(ctx context.Context) {
+	if k.config.KeyRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(keyPruneInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := k.PruneKeys(time.Now().Add(-k.config.KeyRetention)); err != nil {
+			k.logger.Error("failed to prune encryption keys", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneKeys deletes the keys of the station created before before. If the current key is
+// among them, the next segment gets a new key.
+func (k *KeyRotator) PruneKeys(before time.Time) (int, error) {
+	pruner, ok := k.config.Storage.(KeyPruner)
+	if !ok {
+		return 0, fmt.Errorf("key storage %T cannot delete expired keys", k.config.Storage)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// 使用中の鍵を消すなら次のセグメントから新しい鍵にする
+	if k.current != nil && k.currentAt.Before(before) {
+		k.current = nil
+	}
+	n, err := pruner.Prune(k.config.Station+"-", before)
+	k.keysPruned.Add(int64(n))
+	if n > 0 {
+		k.logger.Info("pruned encryption keys", "deleted", n)
+	}
+	return n, err
+}
+
+// load returns the key and IV stored under id
+func (k *KeyRotator) load(id string) (key []byte, iv []byte, err error) {
+	if !validKeyID(id) {
+		return nil, nil, ErrKeyNotFound
+	}
+	c, err := k.config.Storage.Load(k.storageKey(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	data := c.Bytes()
+	if len(data) != 2*aes.BlockSize {
+		return nil, nil, fmt.Errorf("stored key %s is %d bytes, want %d", id, len(data), 2*aes.BlockSize)
+	}
+	return data[:aes.BlockSize], data[aes.BlockSize:], nil
+}
+
+func validKeyID(id string) bool {
+	if len(id) != keyIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// localPath maps a segment URI under URLPrefix to its file under Root
+func (k *KeyRotator) localPath(uri string) (string, bool) {
+	return ContentsPath(k.config.Root, k.config.URLPrefix, uri)
+}
+
+// KeyHandler serves the raw 16-byte key of the {kid} path value. It does not authorize
+// listeners itself: it must be wrapped in middleware that does, e.g. one checking the
+// signature of key URLs signed with KeyURLRewriter.
+func (k *KeyRotator) KeyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k.keyRequests.Add(1)
+		key, _, err := k.load(r.PathValue("kid"))
+		if errors.Is(err, ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			k.logger.Error("failed to load encryption key", "key_id", r.PathValue("kid"), "error", err)
+			http.Error(w, "failed to load key", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "private, no-store")
+		_, _ = w.Write(key)
+	})
+}
+
+// SegmentHandler serves the segment at the {path...} path value (a URI under URLPrefix
+// without its leading slash) encrypted with the key of the {kid} path value
+func (k *KeyRotator) SegmentHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, iv, err := k.load(r.PathValue("kid"))
+		if errors.Is(err, ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			k.logger.Error("failed to load encryption key", "key_id", r.PathValue("kid"), "error", err)
+			http.Error(w, "failed to load key", http.StatusInternalServerError)
+			return
+		}
+		// PathValue はデコード済みなので、公開時の URI の形に戻す
+		file, ok := k.localPath("/" + escapePath(r.PathValue("path")))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		plain, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			k.logger.Error("failed to read segment", "path", file, "error", err)
+			http.Error(w, "failed to read segment", http.StatusInternalServerError)
+			return
+		}
+		encrypted, err := EncryptSegment(key, iv, plain)
+		if err != nil {
+			http.Error(w, "failed to encrypt segment", http.StatusInternalServerError)
+			return
+		}
+		k.segmentsEncrypted.Add(1)
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		_, _ = w.Write(encrypted)
+	})
+}
+
+// EncryptSegment encrypts a whole segment as HLS AES-128 expects: AES-128-CBC with
+// PKCS#7 padding
+func EncryptSegment(key []byte, iv []byte, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("IV must be %d bytes, got %d", aes.BlockSize, len(iv))
+	}
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(bytes.Clone(plain), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data, nil
+}
+
+func (k *KeyRotator) Collect() []metrics.Metric {
+	station := []metrics.Label{{Name: "station", Value: k.config.Station}}
+	return []metrics.Metric{
+		{
+			Name: "hlsradio_encryption_keys_created_total", Help: "Encryption keys generated for the live playlist.", Type: metrics.TypeCounter,
+			Samples: []metrics.Sample{{Labels: station, Value: float64(k.keysCreated.Load())}},
+		},
+		{
+			Name: "hlsradio_encryption_keys_pruned_total", Help: "Expired encryption keys deleted from the key storage.", Type: metrics.TypeCounter,
+			Samples: []metrics.Sample{{Labels: station, Value: float64(k.keysPruned.Load())}},
+		},
+		{
+			Name: "hlsradio_encryption_key_requests_total", Help: "Requests for encryption keys.", Type: metrics.TypeCounter,
+			Samples: []metrics.Sample{{Labels: station, Value: float64(k.keyRequests.Load())}},
+		},
+		{
+			Name: "hlsradio_encrypted_segments_served_total", Help: "Segments encrypted on the fly and served.", Type: metrics.TypeCounter,
+			Samples: []metrics.Sample{{Labels: station, Value: float64(k.segmentsEncrypted.Load())}},
+		},
+	}
+}
@@ -0,0 +1,242 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyRotator(t *testing.T, root string, rotateEvery int) *KeyRotator {
+	t.Helper()
+	k, err := NewKeyRotator(EncryptionConfig{
+		Station:          "test",
+		Storage:          NewFileStorage(DefaultFileSystem{}, t.TempDir()),
+		RotateEvery:      rotateEvery,
+		KeyURLPrefix:     "/stations/test/keys",
+		SegmentURLPrefix: "/stations/test/segments/",
+		Root:             root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyRotator_Rotation(t *testing.T) {
+	k := newTestKeyRotator(t, t.TempDir(), 2)
+	m := NewPlaylistManager(newMockPlaylist(), ManagerConfig{HighWaterMark: 100, Encryption: k})
+
+	segs := make([]segment, 5)
+	for i := range segs {
+		segs[i] = NewSegment(2.0, "/contents/test/1/seg"+string(rune('0'+i))+".ts", false)
+	}
+	if err := m.Add(context.Background(), newMockContent(segs)); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := m.segQ.segments
+	wantSameKey := []bool{false, true, false, true, false}
+	for i, seg := range queued {
+		if seg.key == nil || seg.key.method != keyMethodAES128 || len(seg.key.iv) != 2+2*aes.BlockSize {
+			t.Fatalf("segment %d key = %+v, want an AES-128 key with an IV", i, seg.key)
+		}
+		if i > 0 && (seg.key == queued[i-1].key) != wantSameKey[i] {
+			t.Errorf("segment %d shares the previous key = %v, want %v", i, seg.key == queued[i-1].key, wantSameKey[i])
+		}
+		if !strings.HasPrefix(seg.uri, "/stations/test/segments/") || !strings.HasSuffix(seg.uri, "/contents/test/1/seg"+string(rune('0'+i))+".ts") {
+			t.Errorf("segment %d uri = %q, want it served through the segment endpoint", i, seg.uri)
+		}
+	}
+	if got := k.keysCreated.Load(); got != 3 {
+		t.Errorf("keys created = %d, want 3", got)
+	}
+
+	// EXT-X-KEY is written only when the key changes, and parses back onto every segment
+	p := NewPlaylist(PlaylistConfig{MaxSegments: 10, TargetDuration: 2})
+	p.segments = append(p.segments, queued...)
+	p.segments = append(p.segments, NewSegment(2.0, "/filler.ts", true))
+	formatter := &DefaultPlaylistFormatter{}
+	c, err := formatter.Format(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(c.String(), "#EXT-X-KEY:METHOD=AES-128,URI=\"/stations/test/keys/"); got != 3 {
+		t.Errorf("AES-128 EXT-X-KEY lines = %d, want 3\n%s", got, c)
+	}
+	if !strings.Contains(c.String(), "#EXT-X-KEY:METHOD=NONE\n#EXTINF:2.000,\n/filler.ts") {
+		t.Errorf("filler is not published in the clear\n%s", c)
+	}
+	parsed, err := formatter.Parse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, seg := range parsed.segments {
+		var want *segmentKey
+		if i < len(queued) {
+			want = queued[i].key
+		}
+		if !sameKey(seg.key, want) {
+			t.Errorf("parsed segment %d key = %+v, want %+v", i, seg.key, want)
+		}
+	}
+}
+
+func TestKeyRotator_Handlers(t *testing.T) {
+	root := t.TempDir()
+	plain := bytes.Repeat([]byte{0x47, 1, 2, 3}, 47)
+	if err := os.MkdirAll(filepath.Join(root, "music", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "music", "1", "seg 0.ts"), plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	k := newTestKeyRotator(t, root, 0)
+	seg, err := k.encrypt(NewSegment(2.0, "/contents/music/1/seg%200.ts", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /stations/test/keys/{kid}", k.KeyHandler())
+	mux.Handle("GET /stations/test/segments/{kid}/{path...}", k.SegmentHandler())
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	keyRec := get(seg.key.uri)
+	if keyRec.Code != http.StatusOK || keyRec.Body.Len() != aes.BlockSize {
+		t.Fatalf("key request = %d with %d bytes, want 200 with %d bytes", keyRec.Code, keyRec.Body.Len(), aes.BlockSize)
+	}
+	segRec := get(seg.uri)
+	if segRec.Code != http.StatusOK {
+		t.Fatalf("segment request = %d, want 200", segRec.Code)
+	}
+
+	iv, err := hex.DecodeString(strings.TrimPrefix(seg.key.iv, "0x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(keyRec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := segRec.Body.Bytes()
+	if len(data)%aes.BlockSize != 0 || bytes.Equal(data[:len(plain)], plain) {
+		t.Fatalf("segment was not encrypted")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	padding := int(data[len(data)-1])
+	if !bytes.Equal(data[:len(data)-padding], plain) {
+		t.Errorf("decrypted segment does not match the original")
+	}
+
+	for _, target := range []string{
+		"/stations/test/keys/0000000000000000",
+		"/stations/test/keys/..%2f..%2fetc",
+		strings.Replace(seg.uri, "seg%200.ts", "missing.ts", 1),
+		strings.Replace(seg.uri, "/contents/", "/other/", 1),
+	} {
+		if rec := get(target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, rec.Code)
+		}
+	}
+	if got := k.segmentsEncrypted.Load(); got != 1 {
+		t.Errorf("segments encrypted = %d, want 1", got)
+	}
+}
+
+func TestKeyRotator_RejectsUnencryptableSegments(t *testing.T) {
+	k := newTestKeyRotator(t, t.TempDir(), 0)
+	m := NewPlaylistManager(newMockPlaylist(), ManagerConfig{HighWaterMark: 100, Encryption: k})
+
+	for _, seg := range []segment{
+		NewSegment(2.0, "https://cdn.example.com/seg0.ts", false),
+		fmp4Segment(2.0, "/contents/a/0.m4s", "/contents/a/init.mp4", false),
+	} {
+		err := m.Add(context.Background(), newMockContent([]segment{seg}))
+		if !errors.Is(err, ErrPreflightFailed) || !errors.Is(err, ErrNotEncryptable) {
+			t.Errorf("Add(%s) error = %v, want ErrPreflightFailed and ErrNotEncryptable", seg.uri, err)
+		}
+	}
+	if got := m.Stats().PreflightRejects; got != 2 {
+		t.Errorf("PreflightRejects = %d, want 2", got)
+	}
+}
+
+func TestKeyRotator_PruneKeys(t *testing.T) {
+	dir := t.TempDir()
+	k, err := NewKeyRotator(EncryptionConfig{
+		Station:          "test",
+		Storage:          NewFileStorage(DefaultFileSystem{}, dir),
+		RotateEvery:      10,
+		KeyRetention:     time.Hour,
+		KeyURLPrefix:     "/stations/test/keys",
+		SegmentURLPrefix: "/stations/test/segments",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, err := k.encrypt(NewSegment(2, "/contents/a/0.ts", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 他の局の鍵は消さない
+	other := filepath.Join(dir, "other-0123456789abcdef.key")
+	if err := os.WriteFile(other, make([]byte, 32), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := k.PruneKeys(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("PruneKeys() = %d, %v; want fresh keys kept", n, err)
+	}
+	if n, err := k.PruneKeys(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("PruneKeys() = %d, %v; want the expired key deleted", n, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("key of another station was deleted: %v", err)
+	}
+	next, err := k.encrypt(NewSegment(2, "/contents/a/1.ts", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameKey(next.key, seg.key) {
+		t.Error("the deleted key is still used for new segments")
+	}
+
+	if _, err := NewKeyRotator(EncryptionConfig{
+		Storage:          memoryStorage{},
+		KeyRetention:     time.Hour,
+		KeyURLPrefix:     "/k",
+		SegmentURLPrefix: "/s",
+	}); err == nil {
+		t.Error("NewKeyRotator accepted a retention its storage cannot enforce")
+	}
+}
+
+// memoryStorage is a key storage that cannot prune
+type memoryStorage map[string]PlaylistContent
+
+func (m memoryStorage) Store(key string, c PlaylistContent) error {
+	m[key] = c
+	return nil
+}
+
+func (m memoryStorage) Load(key string) (PlaylistContent, error) {
+	c, ok := m[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return c, nil
+}
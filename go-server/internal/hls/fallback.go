@@ -0,0 +1,69 @@
+package hls
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// playlistFileContent is a content backed by an arbitrary m3u8 under the contents root,
+// used for emergency playlists that do not follow the contents/{type}/{id} layout
+type playlistFileContent struct {
+	id      string
+	relPath string // m3u8 path relative to the contents root
+}
+
+// NewPlaylistFileContent returns a content that plays the m3u8 at relPath (relative to the contents root)
+func NewPlaylistFileContent(id string, relPath string) Content {
+	return playlistFileContent{
+		id:      id,
+		relPath: strings.TrimPrefix(path.Clean("/"+relPath), "/"),
+	}
+}
+
+func (c playlistFileContent) ID() string {
+	return c.id
+}
+
+func (c playlistFileContent) SourcePath() string {
+	return contentsRootDir + "/" + c.relPath
+}
+
+func (c playlistFileContent) UrlPath() string {
+	return contentsURLPrefix + "/" + c.relPath
+}
+
+func (c playlistFileContent) SegmentLocalToGlobal(seg segment) segment {
+	seg.uri = contentsURLPrefix + "/" + path.Join(path.Dir(c.relPath), seg.uri)
+	if seg.initURI != "" {
+		seg.initURI = contentsURLPrefix + "/" + path.Join(path.Dir(c.relPath), seg.initURI)
+	}
+	return seg
+}
+
+func (c playlistFileContent) ToStreamFilePath(baseDir string) string {
+	return filepath.Join(baseDir, "contents", filepath.FromSlash(c.relPath))
+}
+
+func (c playlistFileContent) ToSegments() ([]segment, error) {
+	return loadSegments(c.SourcePath(), c.SegmentLocalToGlobal)
+}
+
+// loopLogic always chooses the same contents in order; used as the fallback when the catalog is unusable
+type loopLogic struct {
+	contents []Content
+	next     int
+}
+
+func (l *loopLogic) Choice() (Content, error) {
+	if len(l.contents) == 0 {
+		return nil, ErrEmptyContent
+	}
+	c := l.contents[l.next%len(l.contents)]
+	l.next++
+	return c, nil
+}
+
+func (l *loopLogic) Len() int {
+	return len(l.contents)
+}
@@ -2,6 +2,7 @@ package hls
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 )
 
@@ -18,7 +19,49 @@ type PlaylistFormatter interface {
 }
 
 // DefaultPlaylistFormatter implements PlaylistFormatter
-type DefaultPlaylistFormatter struct{}
+type DefaultPlaylistFormatter struct {
+	// Logger receives parse warnings; slog.Default() is used when nil
+	Logger *slog.Logger
+}
+
+func (f *DefaultPlaylistFormatter) logger() *slog.Logger {
+	if f.Logger == nil {
+		return slog.Default()
+	}
+	return f.Logger
+}
+
+// tagFloat reads a numeric tag value, logging and falling back to 0 when it is malformed
+func (f *DefaultPlaylistFormatter) tagFloat(l m3u8Line, tag Tag) float64 {
+	value, err := l.getTagFloat(tag)
+	if err != nil {
+		f.logger().Warn("invalid m3u8 tag", "tag", string(tag), "error", err)
+	}
+	return value
+}
+
+// tagMap reads the URI of an EXT-X-MAP line; malformed lines yield none
+func (f *DefaultPlaylistFormatter) tagMap(l m3u8Line) string {
+	attrs, err := l.getAttributes(TagMAP)
+	if err != nil {
+		f.logger().Warn("invalid m3u8 tag", "tag", string(TagMAP), "error", err)
+		return ""
+	}
+	return attrs["URI"]
+}
+
+// tagKey reads an EXT-X-KEY line; METHOD=NONE and malformed lines yield no key
+func (f *DefaultPlaylistFormatter) tagKey(l m3u8Line) *segmentKey {
+	attrs, err := l.getAttributes(TagKEY)
+	if err != nil {
+		f.logger().Warn("invalid m3u8 tag", "tag", string(TagKEY), "error", err)
+		return nil
+	}
+	if attrs["METHOD"] == keyMethodNone || attrs["METHOD"] == "" {
+		return nil
+	}
+	return &segmentKey{method: attrs["METHOD"], uri: attrs["URI"], iv: attrs["IV"]}
+}
 
 func (f *DefaultPlaylistFormatter) Format(p *playlist) (PlaylistContent, error) {
 	var lines []string
@@ -28,18 +71,33 @@ func (f *DefaultPlaylistFormatter) Format(p *playlist) (PlaylistContent, error)
 
 	// Add header lines
 	lines = append(lines, "#EXTM3U")
-	lines = append(lines, fmt.Sprintf("#EXT-X-VERSION:%d", p.metadata.version))
+	lines = append(lines, fmt.Sprintf("#EXT-X-VERSION:%d", p.playlistVersion()))
 	lines = append(lines, fmt.Sprintf("#EXT-X-TARGETDURATION:%.3f", p.metadata.targetDuration))
 	lines = append(lines, fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", p.metadata.mediaSequence))
 	if p.metadata.discontinuitySequence > 0 {
 		lines = append(lines, fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d", p.metadata.discontinuitySequence))
 	}
+	if p.metadata.playlistType != "" {
+		lines = append(lines, string(TagPLAYLISTTYPE)+p.metadata.playlistType)
+	}
 
+	var key *segmentKey
+	var initURI string
 	for _, seg := range p.segments {
 		// Add segment lines
 		if seg.discontinuity {
 			lines = append(lines, "#EXT-X-DISCONTINUITY")
 		}
+		// 鍵が変わったとき（先頭を含む）だけEXT-X-KEYを出す
+		if !sameKey(key, seg.key) {
+			lines = append(lines, seg.key.tag())
+			key = seg.key
+		}
+		// EXT-X-MAP も鍵と同じく変わったときだけ出す
+		if seg.initURI != "" && seg.initURI != initURI {
+			lines = append(lines, fmt.Sprintf("%sURI=%q", TagMAP, seg.initURI))
+		}
+		initURI = seg.initURI
 		if seg.duration > 0.0 {
 			lines = append(lines, fmt.Sprintf("#EXTINF:%.3f,", seg.duration))
 		}
@@ -47,6 +105,9 @@ func (f *DefaultPlaylistFormatter) Format(p *playlist) (PlaylistContent, error)
 			lines = append(lines, seg.uri)
 		}
 	}
+	if p.metadata.endList {
+		lines = append(lines, string(TagENDLIST))
+	}
 	return &DefaultPlaylistContent{
 		data: []byte(strings.Join(lines, "\n") + "\n"),
 	}, nil
@@ -63,22 +124,28 @@ func (f *DefaultPlaylistFormatter) Parse(content PlaylistContent) (*playlist, er
 
 	parsingHeader := true
 	var currentSegment segment
+	var key *segmentKey
+	var initURI string
 
 	for _, line := range lines {
 		l := m3u8Line(line)
 
 		if parsingHeader {
-			if l.hasTag(TagEXTINF) || l.hasTag(TagDISCON) || l.isTS() {
+			if l.hasTag(TagEXTINF) || l.isDiscontinuity() || l.hasTag(TagKEY) || l.hasTag(TagMAP) || l.isURI() {
 				parsingHeader = false
 			} else { // parse header tags
 				if l.hasTag(TagVERSION) {
-					p.metadata.version = int(l.getTagFloat(TagVERSION))
+					p.metadata.version = int(f.tagFloat(l, TagVERSION))
 				} else if l.hasTag(TagMEDIASEQ) {
-					p.metadata.mediaSequence = int(l.getTagFloat(TagMEDIASEQ))
+					p.metadata.mediaSequence = int(f.tagFloat(l, TagMEDIASEQ))
 				} else if l.hasTag(TagDISCONSEQ) {
-					p.metadata.discontinuitySequence = int(l.getTagFloat(TagDISCONSEQ))
+					p.metadata.discontinuitySequence = int(f.tagFloat(l, TagDISCONSEQ))
 				} else if l.hasTag(TagTARGETDURATION) {
-					p.metadata.targetDuration = l.getTagFloat(TagTARGETDURATION)
+					p.metadata.targetDuration = f.tagFloat(l, TagTARGETDURATION)
+				} else if l.hasTag(TagPLAYLISTTYPE) {
+					p.metadata.playlistType = strings.TrimPrefix(string(l), string(TagPLAYLISTTYPE))
+				} else if l.hasTag(TagENDLIST) {
+					p.metadata.endList = true
 				}
 				continue
 			}
@@ -87,15 +154,25 @@ func (f *DefaultPlaylistFormatter) Parse(content PlaylistContent) (*playlist, er
 		// Handle segments
 		if !parsingHeader {
 			switch {
-			case l.hasTag(TagDISCON):
+			case l.isDiscontinuity():
 				// Start a new segment with DISCONTINUITY
 				currentSegment.discontinuity = true
+			case l.hasTag(TagKEY):
+				// The key applies to every following segment until the next EXT-X-KEY
+				key = f.tagKey(l)
+			case l.hasTag(TagMAP):
+				// The media initialization section applies to every following segment
+				initURI = f.tagMap(l)
+			case l.hasTag(TagENDLIST):
+				p.metadata.endList = true
 			case l.hasTag(TagEXTINF):
 				// If we have a complete segment, add it
-				currentSegment.duration = l.getTagFloat(TagEXTINF)
-			case l.isTS():
+				currentSegment.duration = f.tagFloat(l, TagEXTINF)
+			case l.isURI():
 				// Complete the segment with the TS file
 				currentSegment.uri = string(l)
+				currentSegment.key = key
+				currentSegment.initURI = initURI
 				p.segments = append(p.segments, currentSegment)
 				currentSegment = segment{}
 			}
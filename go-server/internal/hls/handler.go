@@ -0,0 +1,171 @@
+package hls
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PlaylistContentType is the media type of m3u8 playlists (RFC 8216)
+const PlaylistContentType = "application/vnd.apple.mpegurl"
+
+// RenderedPlaylist is an immutable snapshot of a formatted playlist with what HTTP
+// caching needs to know about it. A new one is rendered on every Update, so that
+// requests are served without taking the playlist lock or formatting again.
+type RenderedPlaylist struct {
+	Content PlaylistContent
+	// ETag changes whenever a segment is published; it is derived from the media sequence
+	// and bootID
+	ETag string
+	// NextSequence is the media sequence number the next published segment will get
+	NextSequence   int
+	LastModified   time.Time // zero until the first segment is published
+	TargetDuration float64
+	// ContentType is the media type it is served as; PlaylistContentType when empty
+	ContentType string
+
+	gzipped []byte
+	gzipTag string
+}
+
+// bootID identifies this process in ETags. Media sequence numbers restart at 0 with the
+// process, so without it a client holding an ETag from before a restart would be told
+// that a different playlist had not been modified.
+var bootID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+func newRenderedPlaylist(c PlaylistContent, seq int, updatedAt time.Time, targetDuration float64) *RenderedPlaylist {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(c.Bytes())
+	_ = zw.Close()
+
+	return &RenderedPlaylist{
+		Content:        c,
+		ETag:           fmt.Sprintf(`"%s-seq-%d"`, bootID, seq),
+		NextSequence:   seq,
+		LastModified:   updatedAt,
+		TargetDuration: targetDuration,
+		gzipped:        buf.Bytes(),
+		// 表現ごとに異なる強いETagにする
+		gzipTag: fmt.Sprintf(`"%s-seq-%d-gzip"`, bootID, seq),
+	}
+}
+
+// Render returns the snapshot of the live playlist rendered by the last Update. The
+// snapshot is shared and must not be modified.
+func (s *Station) Render() (*RenderedPlaylist, error) {
+	if r := s.playlist.snapshot.Load(); r != nil {
+		return r, nil
+	}
+	// まだ Update されていない
+	return s.playlist.renderSnapshot()
+}
+
+// PlaylistHandler serves the live playlist for GET and HEAD with caching headers:
+// max-age of half the target duration, an ETag and Last-Modified that change with
+// every published segment (answering conditional requests with 304), and gzip when
+// the client accepts it
+func (s *Station) PlaylistHandler() http.Handler {
+	return servePlaylist(s.Render, &s.playlistRequests, s.playlist.logger)
+}
+
+// servePlaylist serves the snapshots returned by render, counting served requests in requests
+func servePlaylist(render func() (*RenderedPlaylist, error), requests *atomic.Int64, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rendered, err := render()
+		if err != nil {
+			logger.Error("failed to format playlist", "error", err)
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "Failed to format playlist", http.StatusInternalServerError)
+			return
+		}
+		requests.Add(1)
+
+		body, etag := rendered.Content.Bytes(), rendered.ETag
+		useGzip := acceptsGzip(r)
+		if useGzip {
+			body, etag = rendered.gzipped, rendered.gzipTag
+		}
+		h := w.Header()
+		h.Set("Content-Type", cmp.Or(rendered.ContentType, PlaylistContentType))
+		h.Set("Cache-Control", "max-age="+strconv.Itoa(playlistMaxAge(rendered.TargetDuration)))
+		h.Add("Vary", "Accept-Encoding")
+		h.Set("ETag", etag)
+		if !rendered.LastModified.IsZero() {
+			h.Set("Last-Modified", rendered.LastModified.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, etag, rendered.LastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if useGzip {
+			h.Set("Content-Encoding", "gzip")
+		}
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// ヘッダー送信後はエラーを返せないので記録だけする
+		if _, err := w.Write(body); err != nil {
+			logger.Debug("failed to write playlist", "error", err)
+		}
+	})
+}
+
+// playlistMaxAge is how long clients and CDNs may reuse a live playlist: half the
+// target duration, as recommended for live streams, and at least a second
+func playlistMaxAge(targetDuration float64) int {
+	return max(1, int(targetDuration/2))
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is none
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// acceptsGzip reports whether Accept-Encoding allows gzip (q=0 forbids it)
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
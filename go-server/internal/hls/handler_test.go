@@ -0,0 +1,205 @@
+package hls
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seqETag is the ETag of the playlist rendered before segment seq is published
+func seqETag(seq int) string {
+	return `"` + bootID + `-seq-` + strconv.Itoa(seq) + `"`
+}
+
+func newTestStation(config PlaylistConfig) *Station {
+	p := NewPlaylist(config)
+	return &Station{name: "test", playlist: p, formatter: &DefaultPlaylistFormatter{}}
+}
+
+func TestPlaylistHandler(t *testing.T) {
+	s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+	s.playlist.Update(NewSegment(10, "/contents/a/0.ts", true))
+	s.playlist.Update(NewSegment(10, "/contents/a/1.ts", false))
+	handler := s.PlaylistHandler()
+
+	serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/stations/test/stream.m3u8", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodGet, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want 200", rec.Code)
+	}
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	for name, want := range map[string]string{
+		"Content-Type":  PlaylistContentType,
+		"Cache-Control": "max-age=5",
+		"ETag":          seqETag(2),
+		"Vary":          "Accept-Encoding",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if lastModified == "" || !strings.Contains(rec.Body.String(), "/contents/a/1.ts") {
+		t.Fatalf("missing Last-Modified or body:\n%s", rec.Body.String())
+	}
+	body := rec.Body.String()
+
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		wantCode int
+		wantBody bool
+	}{
+		{name: "matching etag", method: http.MethodGet, header: http.Header{"If-None-Match": {`"seq-1", ` + etag}}, wantCode: http.StatusNotModified},
+		{name: "weak etag", method: http.MethodGet, header: http.Header{"If-None-Match": {"W/" + etag}}, wantCode: http.StatusNotModified},
+		{name: "stale etag", method: http.MethodGet, header: http.Header{"If-None-Match": {seqETag(1)}}, wantCode: http.StatusOK, wantBody: true},
+		// 再起動前のプロセスのETagはシーケンス番号が同じでも一致しない
+		{name: "etag from before a restart", method: http.MethodGet, header: http.Header{"If-None-Match": {`"seq-2", "0-seq-2"`}}, wantCode: http.StatusOK, wantBody: true},
+		{name: "etag wins over date", method: http.MethodGet, header: http.Header{"If-None-Match": {seqETag(1)}, "If-Modified-Since": {lastModified}}, wantCode: http.StatusOK, wantBody: true},
+		{name: "not modified since", method: http.MethodGet, header: http.Header{"If-Modified-Since": {lastModified}}, wantCode: http.StatusNotModified},
+		{name: "modified since", method: http.MethodGet, header: http.Header{"If-Modified-Since": {time.Unix(0, 0).UTC().Format(http.TimeFormat)}}, wantCode: http.StatusOK, wantBody: true},
+		{name: "head", method: http.MethodHead, wantCode: http.StatusOK},
+		{name: "post", method: http.MethodPost, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.header)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Body.String(); (got == body) != tt.wantBody {
+				t.Errorf("body = %q, want body %v", got, tt.wantBody)
+			}
+			if tt.method == http.MethodHead && rec.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+				t.Errorf("HEAD Content-Length = %q, want %d", rec.Header().Get("Content-Length"), len(body))
+			}
+		})
+	}
+
+	// gzip は別の ETag を持つ
+	rec = serve(http.MethodGet, http.Header{"Accept-Encoding": {"br;q=1.0, gzip;q=0.8"}})
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") == etag {
+		t.Fatalf("gzip response headers = %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unzipped, _ := io.ReadAll(zr); string(unzipped) != body {
+		t.Errorf("gunzipped body = %q, want %q", unzipped, body)
+	}
+	if rec := serve(http.MethodGet, http.Header{"Accept-Encoding": {"gzip;q=0"}}); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("gzip used although q=0")
+	}
+
+	// a new segment changes the ETag
+	s.playlist.Update(NewSegment(10, "/contents/a/2.ts", false))
+	if rec := serve(http.MethodGet, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK || rec.Header().Get("ETag") != seqETag(3) {
+		t.Errorf("after update status = %d, ETag = %q, want 200 and %s", rec.Code, rec.Header().Get("ETag"), seqETag(3))
+	}
+}
+
+func TestPlaylistSnapshot(t *testing.T) {
+	s := newTestStation(PlaylistConfig{MaxSegments: 3, TargetDuration: 10})
+	empty, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.NextSequence != 0 || !empty.LastModified.IsZero() {
+		t.Errorf("snapshot before any update = %+v", empty)
+	}
+
+	s.playlist.Update(NewSegment(10, "/contents/a/0.ts", true))
+	first, _ := s.Render()
+	again, _ := s.Render()
+	if first != again {
+		t.Error("Render() re-rendered without an update")
+	}
+	if !strings.Contains(first.Content.String(), "/contents/a/0.ts") || first.ETag != seqETag(1) {
+		t.Errorf("snapshot after update = %q %s", first.Content, first.ETag)
+	}
+
+	// readers never see a snapshot older than one they have already seen
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			s.playlist.Update(NewSegment(10, "/contents/a/"+strconv.Itoa(i)+".ts", false))
+		}
+	}()
+	last := 0
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		r, _ := s.Render()
+		if r.NextSequence < last {
+			t.Fatalf("snapshot went back from %d to %d", last, r.NextSequence)
+		}
+		last = r.NextSequence
+	}
+	if r, _ := s.Render(); r.NextSequence != 101 || !strings.HasSuffix(r.Content.String(), "/contents/a/100.ts\n") {
+		t.Errorf("final snapshot = %d\n%s", r.NextSequence, r.Content)
+	}
+}
+
+// benchmarkPlaylistHandler serves a full live playlist to parallel listeners
+func benchmarkPlaylistHandler(b *testing.B, handler http.Handler, header http.Header) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/stations/test/stream.m3u8", nil)
+		req.Header = header
+		for pb.Next() {
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+func newBenchmarkStation() *Station {
+	s := newTestStation(PlaylistConfig{MaxSegments: 6, TargetDuration: 10})
+	for i := range 6 {
+		s.playlist.Update(NewSegment(10, "https://cdn.example.com/contents/music/123/seg"+strconv.Itoa(i)+".ts?exp=1700000000&kid=k1&sig=abcdefghijklmnopqrstuvwxyz", i == 0))
+	}
+	return s
+}
+
+// BenchmarkPlaylistHandler_FormatPerRequest is the previous behaviour: every request
+// takes the playlist lock and formats the playlist again
+func BenchmarkPlaylistHandler_FormatPerRequest(b *testing.B) {
+	s := newBenchmarkStation()
+	formatPerRequest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seq, _ := s.playlist.version()
+		c, err := s.formatter.Format(s.playlist)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		w.Header().Set("ETag", `"seq-`+strconv.Itoa(seq)+`"`)
+		_, _ = w.Write(c.Bytes())
+	})
+	benchmarkPlaylistHandler(b, formatPerRequest, http.Header{})
+}
+
+func BenchmarkPlaylistHandler_Snapshot(b *testing.B) {
+	benchmarkPlaylistHandler(b, newBenchmarkStation().PlaylistHandler(), http.Header{})
+}
+
+func BenchmarkPlaylistHandler_SnapshotGzip(b *testing.B) {
+	benchmarkPlaylistHandler(b, newBenchmarkStation().PlaylistHandler(), http.Header{"Accept-Encoding": {"gzip"}})
+}
@@ -0,0 +1,95 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultContentsRoot is the contents root used when none is configured
+const DefaultContentsRoot = contentsRootDir
+
+// DefaultContentsURLPrefix is the URL path nginx serves DefaultContentsRoot under
+const DefaultContentsURLPrefix = contentsURLPrefix
+
+// ContentDir returns {root}/{type}/{id}, the directory DefaultContentFormatter expects
+// {id}.m3u8 and its segments in
+func ContentDir(root string, contentType string, id string) string {
+	return filepath.Join(root, contentType, id)
+}
+
+// MediaSegment is one segment of a media playlist with its URI as written in the m3u8
+type MediaSegment struct {
+	URI           string
+	Duration      float64
+	Discontinuity bool
+	// InitURI is the EXT-X-MAP of fragmented MP4 segments; empty for MPEG-TS
+	InitURI string
+}
+
+// ReadMediaPlaylist parses the media playlist at path
+func ReadMediaPlaylist(path string) ([]MediaSegment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pf := DefaultPlaylistFormatter{}
+	p, err := pf.Parse(&DefaultPlaylistContent{data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	segments := make([]MediaSegment, len(p.segments))
+	for i, seg := range p.segments {
+		segments[i] = MediaSegment{URI: seg.uri, Duration: seg.duration, Discontinuity: seg.discontinuity, InitURI: seg.initURI}
+	}
+	return segments, nil
+}
+
+// MediaPlaylistDuration returns the sum of the EXTINF durations of segments.
+// Empty playlists and segments without a positive duration are errors.
+func MediaPlaylistDuration(segments []MediaSegment) (float64, error) {
+	if len(segments) == 0 {
+		return 0, fmt.Errorf("playlist has no segments")
+	}
+	total := 0.0
+	for _, seg := range segments {
+		if seg.Duration <= 0 {
+			return 0, fmt.Errorf("%s: %w", seg.URI, &ErrInvalidDuration{Duration: seg.Duration})
+		}
+		total += seg.Duration
+	}
+	return total, nil
+}
+
+// LocalSegmentPath resolves a segment URI written in the m3u8 in dir to a file path.
+// It reports false for absolute URLs and root-relative paths, which are not local files.
+func LocalSegmentPath(dir string, uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.IsAbs() || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	return filepath.Join(dir, filepath.FromSlash(u.Path)), true
+}
+
+// ContentsPath maps a segment URI under urlPrefix, such as a published SourceURI, to its
+// file under root. The URI is percent-encoded, as content formatters publish it. It reports
+// false for other URIs, e.g. filler served by the server.
+func ContentsPath(root string, urlPrefix string, uri string) (string, bool) {
+	rest, ok := strings.CutPrefix(uri, strings.TrimSuffix(urlPrefix, "/")+"/")
+	if !ok || strings.ContainsAny(rest, "?#") {
+		return "", false
+	}
+	rest, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", false
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if rel == "" {
+		return "", false
+	}
+	return filepath.Join(root, filepath.FromSlash(rel)), true
+}
@@ -0,0 +1,82 @@
+package hls
+
+import (
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+)
+
+var allStatuses = []Status{StatusDefault, StatusStreaming, StatusKilled, StatusPaused}
+
+// StationsCollector exposes per-station statistics as metrics
+type StationsCollector struct {
+	stations []*Station
+}
+
+func NewStationsCollector(stations ...*Station) *StationsCollector {
+	return &StationsCollector{stations: stations}
+}
+
+func (c *StationsCollector) Collect() []metrics.Metric {
+	buffered := metrics.Metric{Name: "hlsradio_buffered_seconds", Help: "Seconds of audio queued in the segment buffer.", Type: metrics.TypeGauge}
+	mediaSeq := metrics.Metric{Name: "hlsradio_media_sequence", Help: "Current EXT-X-MEDIA-SEQUENCE of the live playlist.", Type: metrics.TypeGauge}
+	disconSeq := metrics.Metric{Name: "hlsradio_discontinuity_sequence", Help: "Current EXT-X-DISCONTINUITY-SEQUENCE of the live playlist.", Type: metrics.TypeGauge}
+	tracksPlayed := metrics.Metric{Name: "hlsradio_tracks_played_total", Help: "Contents whose first segment was published to the live playlist.", Type: metrics.TypeCounter}
+	choiceFailures := metrics.Metric{Name: "hlsradio_dj_choice_failures_total", Help: "Times the dj failed to choose the next content.", Type: metrics.TypeCounter}
+	bufferFullWaits := metrics.Metric{Name: "hlsradio_buffer_full_waits_total", Help: "Times adding a content had to wait for the buffer to drain.", Type: metrics.TypeCounter}
+	underruns := metrics.Metric{Name: "hlsradio_underruns_total", Help: "Times the segment buffer ran dry while streaming.", Type: metrics.TypeCounter}
+	underrunActive := metrics.Metric{Name: "hlsradio_underrun_active", Help: "1 while the segment buffer is dry and only filler (or nothing) airs.", Type: metrics.TypeGauge}
+	fillers := metrics.Metric{Name: "hlsradio_filler_segments_total", Help: "Silence segments published because the buffer was empty.", Type: metrics.TypeCounter}
+	loadFailures := metrics.Metric{Name: "hlsradio_content_load_failures_total", Help: "Contents skipped because their m3u8 could not be loaded.", Type: metrics.TypeCounter}
+	preflight := metrics.Metric{Name: "hlsradio_preflight_rejects_total", Help: "Contents skipped because their segments failed the pre-flight check.", Type: metrics.TypeCounter}
+	restarts := metrics.Metric{Name: "hlsradio_dj_restarts_total", Help: "Times the supervisor restarted a failed dj.", Type: metrics.TypeCounter}
+	fallback := metrics.Metric{Name: "hlsradio_dj_fallback_active", Help: "1 while the emergency playlist is played instead of the catalog.", Type: metrics.TypeGauge}
+	requests := metrics.Metric{Name: "hlsradio_playlist_requests_total", Help: "Live playlist requests served.", Type: metrics.TypeCounter}
+	lateness := metrics.Metric{Name: "hlsradio_update_lateness_seconds", Help: "How late the last playlist update fired relative to its schedule.", Type: metrics.TypeGauge}
+	dvrBuffered := metrics.Metric{Name: "hlsradio_dvr_buffered_seconds", Help: "Seconds of published audio listeners can rewind to.", Type: metrics.TypeGauge}
+	dvrRequests := metrics.Metric{Name: "hlsradio_dvr_playlist_requests_total", Help: "DVR playlist requests served.", Type: metrics.TypeCounter}
+	dashRequests := metrics.Metric{Name: "hlsradio_dash_manifest_requests_total", Help: "MPEG-DASH manifest requests served.", Type: metrics.TypeCounter}
+	status := metrics.Metric{Name: "hlsradio_manager_status", Help: "Current playlist manager status (1 for the active status).", Type: metrics.TypeGauge}
+
+	for _, s := range c.stations {
+		stats := s.Stats()
+		station := []metrics.Label{{Name: "station", Value: stats.Name}}
+
+		buffered.Samples = append(buffered.Samples, metrics.Sample{Labels: station, Value: stats.Manager.BufferedSeconds})
+		mediaSeq.Samples = append(mediaSeq.Samples, metrics.Sample{Labels: station, Value: float64(stats.MediaSequence)})
+		disconSeq.Samples = append(disconSeq.Samples, metrics.Sample{Labels: station, Value: float64(stats.DiscontinuitySequence)})
+		tracksPlayed.Samples = append(tracksPlayed.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.TracksPlayed)})
+		choiceFailures.Samples = append(choiceFailures.Samples, metrics.Sample{Labels: station, Value: float64(stats.DJChoiceFailures)})
+		bufferFullWaits.Samples = append(bufferFullWaits.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.BufferFullWaits)})
+		underruns.Samples = append(underruns.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.Underruns)})
+		underrunActive.Samples = append(underrunActive.Samples, metrics.Sample{Labels: station, Value: boolToFloat(!stats.Manager.UnderrunSince.IsZero())})
+		fillers.Samples = append(fillers.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.FillerSegments)})
+		loadFailures.Samples = append(loadFailures.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.LoadFailures)})
+		preflight.Samples = append(preflight.Samples, metrics.Sample{Labels: station, Value: float64(stats.Manager.PreflightRejects)})
+		restarts.Samples = append(restarts.Samples, metrics.Sample{Labels: station, Value: float64(stats.DJRestarts)})
+		fallback.Samples = append(fallback.Samples, metrics.Sample{Labels: station, Value: boolToFloat(stats.DJFallback)})
+		requests.Samples = append(requests.Samples, metrics.Sample{Labels: station, Value: float64(stats.PlaylistRequests)})
+		lateness.Samples = append(lateness.Samples, metrics.Sample{Labels: station, Value: stats.Manager.UpdateLateness.Seconds()})
+		if stats.DVR != nil {
+			dvrBuffered.Samples = append(dvrBuffered.Samples, metrics.Sample{Labels: station, Value: stats.DVR.BufferedSeconds})
+			dvrRequests.Samples = append(dvrRequests.Samples, metrics.Sample{Labels: station, Value: float64(stats.DVR.Requests)})
+		}
+		if stats.DASH != nil {
+			dashRequests.Samples = append(dashRequests.Samples, metrics.Sample{Labels: station, Value: float64(stats.DASH.Requests)})
+		}
+
+		for _, st := range allStatuses {
+			status.Samples = append(status.Samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "station", Value: stats.Name}, {Name: "status", Value: st.String()}},
+				Value:  boolToFloat(st == stats.Manager.Status),
+			})
+		}
+	}
+
+	return []metrics.Metric{buffered, mediaSeq, disconSeq, tracksPlayed, choiceFailures, bufferFullWaits, underruns, underrunActive, fillers, loadFailures, preflight, restarts, fallback, requests, lateness, dvrBuffered, dvrRequests, dashRequests, status}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
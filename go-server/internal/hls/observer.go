@@ -0,0 +1,52 @@
+package hls
+
+import "time"
+
+// PublishedSegment describes a segment that was just added to a station's live playlist
+type PublishedSegment struct {
+	Station   string
+	ContentID string
+	URI       string
+	// SourceURI is the URI of the segment file before encryption and URL rewriting.
+	// Unlike URI it carries no expiring signature, so archives keep this one.
+	SourceURI string
+	// SourceInitURI is the EXT-X-MAP of fragmented MP4 segments before URL rewriting;
+	// empty for MPEG-TS segments
+	SourceInitURI string
+	Duration      float64
+	// Discontinuity is true for the first segment of every content and for filler
+	Discontinuity bool
+	// Filler is true for silence published while the queue was empty
+	Filler bool
+	// MediaSequence is the media sequence number of this segment
+	MediaSequence int
+	// DiscontinuitySequence is the discontinuity sequence number this segment belongs to
+	DiscontinuitySequence int
+	PublishedAt           time.Time
+
+	// rawURI is URI before URL rewriting, so that a DVR can sign it again; empty when
+	// never rewritten
+	rawURI string
+	// key is the EXT-X-KEY of the segment, so that a DVR can publish it again
+	key *segmentKey
+	// initURI is the EXT-X-MAP of fragmented MP4 segments
+	initURI string
+}
+
+// ContentStart reports whether the segment is the first segment of a (non-filler) content
+func (s PublishedSegment) ContentStart() bool {
+	return s.Discontinuity && !s.Filler
+}
+
+// SegmentObserver is notified of every segment published to a live playlist.
+// It is called from the manager goroutine and should return quickly.
+type SegmentObserver interface {
+	SegmentPublished(PublishedSegment)
+}
+
+// SegmentObserverFunc adapts a function to SegmentObserver
+type SegmentObserverFunc func(PublishedSegment)
+
+func (f SegmentObserverFunc) SegmentPublished(s PublishedSegment) {
+	f(s)
+}
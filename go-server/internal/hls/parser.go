@@ -1,7 +1,7 @@
 package hls
 
 import (
-	"log/slog"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -17,29 +17,67 @@ const (
 	TagDISCONSEQ      Tag = "#EXT-X-DISCONTINUITY-SEQUENCE:"
 	TagEXTINF         Tag = "#EXTINF:"
 	TagDISCON         Tag = "#EXT-X-DISCONTINUITY"
+	TagKEY            Tag = "#EXT-X-KEY:"
+	TagPLAYLISTTYPE   Tag = "#EXT-X-PLAYLIST-TYPE:"
+	TagENDLIST        Tag = "#EXT-X-ENDLIST"
+	TagMAP            Tag = "#EXT-X-MAP:"
 )
 
 func (l m3u8Line) hasTag(tag Tag) bool {
 	return strings.HasPrefix(string(l), string(tag))
 }
 
-func (l m3u8Line) isTS() bool {
-	return strings.HasSuffix(string(l), ".ts")
+// isDiscontinuity reports whether the line is EXT-X-DISCONTINUITY, which hasTag cannot
+// tell apart from EXT-X-DISCONTINUITY-SEQUENCE
+func (l m3u8Line) isDiscontinuity() bool {
+	return string(l) == string(TagDISCON)
 }
 
-func (l m3u8Line) getTagFloat(tag Tag) float64 {
-	if l.hasTag(tag) {
-		parsed := string(l)[len(string(tag)):]
-		parsed = strings.TrimSuffix(parsed, ",")
-		value, err := strconv.ParseFloat(parsed, 64)
-		if err != nil {
-			slog.Error("failed to parse line", "tag", string(tag), "line", string(l), "error", err)
-			return 0.0
+// isURI reports whether the line is a segment URI: any line that is not a tag or comment.
+// Absolute URLs and URIs with query strings are URIs too, not only lines ending in .ts.
+func (l m3u8Line) isURI() bool {
+	return l != "" && !strings.HasPrefix(string(l), "#")
+}
+
+func (l m3u8Line) getTagFloat(tag Tag) (float64, error) {
+	if !l.hasTag(tag) {
+		return 0.0, fmt.Errorf("line %q is not %s", string(l), string(tag))
+	}
+	parsed := string(l)[len(string(tag)):]
+	parsed = strings.TrimSuffix(parsed, ",")
+	value, err := strconv.ParseFloat(parsed, 64)
+	if err != nil {
+		return 0.0, fmt.Errorf("failed to parse %s line %q: %w", string(tag), string(l), err)
+	}
+	return value, nil
+}
+
+// getAttributes parses the attribute-list of a tag such as
+// #EXT-X-KEY:METHOD=AES-128,URI="k.key". Quoted values are unquoted and may contain commas.
+func (l m3u8Line) getAttributes(tag Tag) (map[string]string, error) {
+	if !l.hasTag(tag) {
+		return nil, fmt.Errorf("line %q is not %s", string(l), string(tag))
+	}
+	rest := string(l)[len(string(tag)):]
+	attrs := make(map[string]string)
+	for rest != "" {
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed attribute list in %q", string(l))
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted string in %q", string(l))
+			}
+			attrs[name] = value[1 : end+1]
+			rest = strings.TrimPrefix(value[end+2:], ",")
+			continue
 		}
-		return value
+		value, rest, _ = strings.Cut(value, ",")
+		attrs[name] = value
 	}
-	slog.Error("This m3u8Line is not %s: %s", string(tag), string(l))
-	return 0.0
+	return attrs, nil
 }
 
 // 与えられた生文字列を行単位に分割し、空白を除いて返す
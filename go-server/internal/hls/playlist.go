@@ -1,7 +1,11 @@
 package hls
 
 import (
+	"cmp"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // PlaylistConfig defines configuration parameters for m3u8 playlist
@@ -15,6 +19,20 @@ type playlist struct {
 	metadata playlistMetadata
 	segments []segment
 	config   PlaylistConfig
+	logger   *slog.Logger
+	// updatedAt is when a segment was last published by Update
+	updatedAt time.Time
+	// availabilityStart is when presentation time zero would have been published: the end
+	// of the newest segment is its publication time. It is fixed once the window first
+	// fills, because the manager publishes without waiting until then.
+	availabilityStart time.Time
+	anchored          bool
+	// name and observers are set by the owning Station
+	name      string
+	observers []SegmentObserver
+	// formatter renders snapshot after every Update
+	formatter PlaylistFormatter
+	snapshot  atomic.Pointer[RenderedPlaylist]
 
 	rwmu sync.RWMutex
 }
@@ -25,6 +43,14 @@ type playlistMetadata struct {
 	targetDuration        float64
 	mediaSequence         int
 	discontinuitySequence int
+	playlistType          string // empty for live playlists
+	endList               bool
+	// startTime is the presentation time of the first segment: the total duration of
+	// the segments that left the window
+	startTime float64
+	// periodStart is the presentation time of the discontinuity (or the stream start)
+	// the first segment follows, which may have left the window already
+	periodStart float64
 }
 
 func NewPlaylist(config PlaylistConfig) *playlist {
@@ -35,7 +61,9 @@ func NewPlaylist(config PlaylistConfig) *playlist {
 			mediaSequence:         0,
 			discontinuitySequence: 0,
 		},
-		config: config,
+		config:    config,
+		logger:    slog.Default(),
+		formatter: &DefaultPlaylistFormatter{},
 	}
 }
 
@@ -62,24 +90,156 @@ func (p *playlist) removeOldestSegment() error {
 	if seg.discontinuity {
 		p.metadata.discontinuitySequence += 1
 	}
+	p.metadata.startTime += seg.duration
+	if len(p.segments) > 0 && p.segments[0].discontinuity {
+		p.metadata.periodStart = p.metadata.startTime
+	}
 	return nil
 }
 
-// Update adds a segment to the playlist and returns the duration of the oldest segment if the playlist is full
+// Update adds a segment to the playlist and returns the duration of the oldest segment if the playlist is full.
+// The playlist is rendered into a new snapshot and observers are notified after the playlist lock is released.
 func (p *playlist) Update(seg segment) float64 {
+	wait, published, ok := p.update(seg)
+	if ok {
+		if _, err := p.renderSnapshot(); err != nil {
+			p.logger.Error("failed to render playlist", "error", err)
+		}
+		for _, o := range p.observers {
+			o.SegmentPublished(published)
+		}
+	}
+	return wait
+}
+
+func (p *playlist) update(seg segment) (float64, PublishedSegment, bool) {
 	p.rwmu.Lock()
 	defer p.rwmu.Unlock()
 	for len(p.segments) >= p.config.MaxSegments {
 		if err := p.removeOldestSegment(); err != nil {
-			return 0.0
+			return 0.0, PublishedSegment{}, false
 		}
 	}
 
 	if err := p.appendSegment(seg); err != nil {
-		return 0.0
+		p.logger.Error("failed to append segment", "content_id", seg.contentID, "uri", seg.uri, "error", err)
+		return 0.0, PublishedSegment{}, false
+	}
+	p.updatedAt = time.Now()
+	if !p.anchored {
+		end := p.metadata.startTime
+		for _, s := range p.segments {
+			end += s.duration
+		}
+		p.availabilityStart = p.updatedAt.Add(-secondsToDuration(end))
+		// 以降は一番古いセグメントの長さだけ待って公開するので、最新セグメントの終わりが公開時刻と揃い続ける
+		p.anchored = len(p.segments) == p.config.MaxSegments
+	}
+
+	// 追加したセグメントのシーケンス番号を求める
+	disconSeq := p.metadata.discontinuitySequence
+	for _, s := range p.segments {
+		if s.discontinuity {
+			disconSeq++
+		}
+	}
+	published := PublishedSegment{
+		Station:               p.name,
+		ContentID:             seg.contentID,
+		URI:                   seg.uri,
+		SourceURI:             cmp.Or(seg.sourceURI, seg.uri),
+		SourceInitURI:         cmp.Or(seg.sourceInitURI, seg.initURI),
+		Duration:              seg.duration,
+		Discontinuity:         seg.discontinuity,
+		Filler:                seg.filler,
+		MediaSequence:         p.metadata.mediaSequence + len(p.segments) - 1,
+		DiscontinuitySequence: disconSeq,
+		PublishedAt:           p.updatedAt,
+		rawURI:                seg.rawURI,
+		key:                   seg.key,
+		initURI:               seg.initURI,
 	}
+	p.logger.Debug("published segment",
+		"content_id", seg.contentID,
+		"uri", seg.uri,
+		"media_sequence", published.MediaSequence,
+		"discontinuity_sequence", published.DiscontinuitySequence)
+
 	if oldestSegment := p.segments[0]; len(p.segments) == p.config.MaxSegments {
-		return oldestSegment.duration
+		return oldestSegment.duration, published, true
+	}
+	return 0.0, published, true
+}
+
+// playlistVersion is the EXT-X-VERSION to write: EXT-X-MAP needs version 6 in playlists
+// that are not I-frame only (RFC 8216 7). The caller must hold rwmu.
+func (p *playlist) playlistVersion() int {
+	for _, seg := range p.segments {
+		if seg.initURI != "" {
+			return max(p.metadata.version, 6)
+		}
+	}
+	return p.metadata.version
+}
+
+// sequences returns the current media sequence and discontinuity sequence
+func (p *playlist) sequences() (int, int) {
+	p.rwmu.RLock()
+	defer p.rwmu.RUnlock()
+	return p.metadata.mediaSequence, p.metadata.discontinuitySequence
+}
+
+// renderSnapshot formats the playlist and publishes the result as the current snapshot,
+// unless a newer one was published meanwhile
+func (p *playlist) renderSnapshot() (*RenderedPlaylist, error) {
+	r, err := p.render(p.formatter)
+	if err != nil {
+		return nil, err
+	}
+	return storeNewer(&p.snapshot, r), nil
+}
+
+// render formats the playlist with f, tagged with the version it was formatted at
+func (p *playlist) render(f PlaylistFormatter) (*RenderedPlaylist, error) {
+	for {
+		seq, updatedAt := p.version()
+		c, err := f.Format(p)
+		if err != nil {
+			return nil, err
+		}
+		// Format とバージョンの間に Update が入ったら取り直す
+		if after, _ := p.version(); after != seq {
+			continue
+		}
+		return newRenderedPlaylist(c, seq, updatedAt, p.config.TargetDuration), nil
 	}
-	return 0.0
+}
+
+// storeNewer stores r in snapshot unless a newer one is stored already, and returns the
+// one that is stored
+func storeNewer(snapshot *atomic.Pointer[RenderedPlaylist], r *RenderedPlaylist) *RenderedPlaylist {
+	for {
+		old := snapshot.Load()
+		if old != nil && old.NextSequence > r.NextSequence {
+			return old
+		}
+		if snapshot.CompareAndSwap(old, r) {
+			return r
+		}
+	}
+}
+
+// version returns what identifies the current contents of the playlist: the media
+// sequence number the next segment will get and when the last segment was published
+func (p *playlist) version() (int, time.Time) {
+	p.rwmu.RLock()
+	defer p.rwmu.RUnlock()
+	return p.metadata.mediaSequence + len(p.segments), p.updatedAt
+}
+
+// lastUpdated returns when a segment was last published, or the zero time if never
+func (p *playlist) lastUpdated() time.Time {
+	p.rwmu.RLock()
+	defer p.rwmu.RUnlock()
+	return p.updatedAt
 }
@@ -308,3 +308,49 @@ func TestPlaylist_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestPlaylist_UpdateNotifiesObservers(t *testing.T) {
+	p := NewPlaylist(PlaylistConfig{MaxSegments: 2, TargetDuration: 10.0})
+	p.name = "proseka"
+	var got []PublishedSegment
+	p.observers = append(p.observers, SegmentObserverFunc(func(s PublishedSegment) {
+		got = append(got, s)
+	}))
+
+	segments := []segment{
+		{duration: 10.0, uri: "a1.ts", discontinuity: true, contentID: "a"},
+		{duration: 10.0, uri: "a2.ts", contentID: "a"},
+		{duration: 10.0, uri: "b1.ts", discontinuity: true, contentID: "b"},
+		{duration: 10.0, uri: "silence.ts", discontinuity: true, contentID: fillerContentID, filler: true},
+		// a catalog content that happens to share the ID of filler
+		{duration: 10.0, uri: "filler/0.ts", discontinuity: true, contentID: fillerContentID},
+	}
+	for _, seg := range segments {
+		p.Update(seg)
+	}
+
+	want := []struct {
+		contentID             string
+		contentStart          bool
+		mediaSequence         int
+		discontinuitySequence int
+	}{
+		{"a", true, 0, 1},
+		{"a", false, 1, 1},
+		{"b", true, 2, 2},
+		{fillerContentID, false, 3, 3},
+		{fillerContentID, true, 4, 4},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("observer was notified %d times, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Station != "proseka" || got[i].ContentID != w.contentID || got[i].ContentStart() != w.contentStart {
+			t.Errorf("segment %d = %+v, want content %q (start %v)", i, got[i], w.contentID, w.contentStart)
+		}
+		if got[i].MediaSequence != w.mediaSequence || got[i].DiscontinuitySequence != w.discontinuitySequence {
+			t.Errorf("segment %d sequences = (%d, %d), want (%d, %d)", i,
+				got[i].MediaSequence, got[i].DiscontinuitySequence, w.mediaSequence, w.discontinuitySequence)
+		}
+	}
+}
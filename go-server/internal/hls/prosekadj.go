@@ -1,68 +1,42 @@
 package hls
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-
 	"log/slog"
-	"strconv"
 )
 
-func NewProsekaDJ(playlistManager *playlistManager) *dj {
-	const jsonPath = "/srv/radio/contents/index.json"
-	contents := NewProsekaContentsFromJson(jsonPath)
+const prosekaIndexPath = contentsRootDir + "/index.json"
+
+// NewCatalogDJ returns a dj that plays random tracks from catalog
+func NewCatalogDJ(playlistManager *playlistManager, catalog Catalog, logger *slog.Logger) *dj {
 	return &dj{
 		manager: playlistManager,
-		logic: randomLogic{
-			contents: contents,
-		},
+		logic:   catalogLogic{catalog: catalog},
+		logger:  logger,
 	}
-
 }
 
-// =======================
-// == gpt 4o no copy-pe ==
-// =======================
-type Track struct {
-	ID     string `json:"id"`
-	Title  string `json:"title"`
-	Length int    `json:"length"`
-	Artist string `json:"artist"`
-	M3U8   string `json:"m3u8"`
+func NewProsekaDJ(playlistManager *playlistManager, logger *slog.Logger) *dj {
+	// カタログが読めなくてもdjは作る（読み込みエラーはChoiceの失敗として報告される）
+	catalog, _ := NewJSONCatalog(prosekaIndexPath, 0)
+	return NewCatalogDJ(playlistManager, catalog, logger)
 }
 
-func NewProsekaContentsFromJson(jsonPath string) []content {
-	// ファイルを読み込む
-	data, err := os.ReadFile(jsonPath)
+func NewProsekaContentsFromJson(jsonPath string, logger *slog.Logger) ([]content, error) {
+	tracks, err := loadTracksFile(jsonPath)
 	if err != nil {
-		fmt.Println("Error reading file:", err)
-		return nil
-	}
-
-	// JSONをパース
-	var tracks []Track
-	if err := json.Unmarshal(data, &tracks); err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return nil
+		return nil, err
 	}
 
 	// contentリストに変換
 	var contents []content
 	for _, track := range tracks {
-		i, err := strconv.Atoi(track.ID)
+		c, err := newContentFromTrack(track, DefaultContentFormatter{})
 		if err != nil {
-			slog.Error("trackID cant convert to Int", "err", err)
+			logger.Error("skipping track", "content_id", track.ID, "error", err)
 			continue
 		}
-		contents = append(contents, content{
-			id:          i,
-			contentType: audio, // 固定値
-			isTmp:       false, // 固定値
-			length:      track.Length,
-			formatter:   DefaultContentFormatter{},
-		})
+		contents = append(contents, c)
 	}
 
-	return contents
+	return contents, nil
 }
@@ -9,6 +9,48 @@ type segment struct {
 	duration      float64
 	uri           string
 	discontinuity bool
+	contentID     string // ID of the content this segment belongs to
+	sourceURI     string // uri before encryption and URL rewriting; empty when never rewritten
+	rawURI        string // uri before URL rewriting; empty when never rewritten
+	key           *segmentKey
+	// initURI is the EXT-X-MAP of fragmented MP4 segments; empty for MPEG-TS
+	initURI       string
+	sourceInitURI string // initURI before URL rewriting; empty when never rewritten
+	filler        bool   // published by the manager while the queue was empty
+}
+
+// segmentKey is the EXT-X-KEY that applies to a segment; segments in the clear have none
+type segmentKey struct {
+	method string
+	uri    string
+	rawURI string // uri before KeyURLRewriter; empty when never rewritten
+	// iv is the IV attribute as a hexadecimal-sequence ("0x..."); empty when the
+	// player derives the IV from the media sequence number
+	iv string
+}
+
+const (
+	keyMethodNone   = "NONE"
+	keyMethodAES128 = "AES-128"
+)
+
+// tag renders k as an EXT-X-KEY line; a nil key renders METHOD=NONE
+func (k *segmentKey) tag() string {
+	if k == nil {
+		return string(TagKEY) + "METHOD=" + keyMethodNone
+	}
+	line := fmt.Sprintf("%sMETHOD=%s,URI=%q", TagKEY, k.method, k.uri)
+	if k.iv != "" {
+		line += ",IV=" + k.iv
+	}
+	return line
+}
+
+func sameKey(a, b *segmentKey) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
 type segmentsQueue struct {
@@ -31,7 +73,6 @@ func (s *segment) String() string {
 func (s *segmentsQueue) push(seg segment) {
 	s.segments = append(s.segments, seg)
 	s.totalDuration += seg.duration
-	fmt.Println("pushed segment", seg)
 }
 
 func (s *segmentsQueue) pop() (segment, error) {
@@ -0,0 +1,133 @@
+package hls
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Station bundles the playlist, manager and dj that make up one radio station
+type Station struct {
+	name      string
+	playlist  *playlist
+	manager   *playlistManager
+	dj        *dj
+	sup       *supervisor
+	formatter PlaylistFormatter
+	dvr       *DVR  // nil unless EnableDVR was called
+	dash      *DASH // nil unless EnableDASH was called
+
+	playlistRequests atomic.Int64
+	startedAt        atomic.Int64 // unix nanoseconds of the last Start
+}
+
+// NewStation wires the components together and makes them log through logger with the station name attached
+func NewStation(name string, p *playlist, manager *playlistManager, d *dj, supConfig SupervisorConfig, logger *slog.Logger) *Station {
+	logger = logger.With("station", name)
+	formatter := &DefaultPlaylistFormatter{Logger: logger}
+	p.name = name
+	p.logger = logger
+	p.formatter = formatter
+	manager.logger = logger
+	d.logger = logger
+
+	return &Station{
+		name:      name,
+		playlist:  p,
+		manager:   manager,
+		dj:        d,
+		sup:       newSupervisor(d, supConfig, logger),
+		formatter: formatter,
+	}
+}
+
+func (s *Station) Name() string {
+	return s.name
+}
+
+// Start runs the station's dj (which in turn runs the manager) under a supervisor until ctx is done
+func (s *Station) Start(ctx context.Context) {
+	s.startedAt.Store(time.Now().UnixNano())
+	s.sup.Run(ctx)
+}
+
+// Observe registers o to be notified of every segment the station publishes.
+// It must be called before Start.
+func (s *Station) Observe(o SegmentObserver) {
+	s.playlist.observers = append(s.playlist.observers, o)
+}
+
+func (s *Station) Kill() {
+	s.manager.Kill()
+}
+
+// Playlist returns the current live playlist and counts it as a served request
+func (s *Station) Playlist() (PlaylistContent, error) {
+	s.playlistRequests.Add(1)
+	r, err := s.Render()
+	if err != nil {
+		return nil, err
+	}
+	return r.Content, nil
+}
+
+// StationStats is a point-in-time snapshot of a station
+type StationStats struct {
+	Name                  string
+	Manager               ManagerStats
+	MediaSequence         int
+	DiscontinuitySequence int
+	LastUpdate            time.Time // zero if no segment has been published yet
+	StartedAt             time.Time // zero if the station has not been started
+	CatalogSize           int
+	DJRunning             bool
+	DJChoiceFailures      int64
+	DJRestarts            int64
+	DJFallback            bool // true while the emergency playlist is played instead of the catalog
+	LastDJError           error
+	LastDJErrorAt         time.Time
+	PlaylistRequests      int64
+	DVR                   *DVRStats  // nil when the station has no DVR
+	DASH                  *DASHStats // nil when the station serves no MPD
+}
+
+func (s *Station) Stats() StationStats {
+	mediaSeq, disconSeq := s.playlist.sequences()
+	lastErrAt, lastErr := s.dj.lastError()
+
+	var startedAt time.Time
+	if ns := s.startedAt.Load(); ns != 0 {
+		startedAt = time.Unix(0, ns)
+	}
+
+	var dvr *DVRStats
+	if s.dvr != nil {
+		stats := s.dvr.Stats()
+		dvr = &stats
+	}
+	var dash *DASHStats
+	if s.dash != nil {
+		stats := s.dash.Stats()
+		dash = &stats
+	}
+
+	return StationStats{
+		Name:                  s.name,
+		Manager:               s.manager.Stats(),
+		MediaSequence:         mediaSeq,
+		DiscontinuitySequence: disconSeq,
+		LastUpdate:            s.playlist.lastUpdated(),
+		StartedAt:             startedAt,
+		CatalogSize:           s.sup.primary.Len(),
+		DJRunning:             s.dj.running.Load(),
+		DJChoiceFailures:      s.dj.choiceFailures.Load(),
+		DJRestarts:            s.sup.restarts.Load(),
+		DJFallback:            s.sup.usingFallback.Load(),
+		LastDJError:           lastErr,
+		LastDJErrorAt:         lastErrAt,
+		PlaylistRequests:      s.playlistRequests.Load(),
+		DVR:                   dvr,
+		DASH:                  dash,
+	}
+}
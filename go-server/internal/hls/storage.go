@@ -1,8 +1,11 @@
 package hls
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // PlaylistStorage defines the storage interface for playlists
@@ -44,6 +47,32 @@ func (s *FileStorage) Load(key string) (PlaylistContent, error) {
 	return &DefaultPlaylistContent{data: data}, nil
 }
 
+// Prune deletes the stored files whose key starts with prefix and that were last stored
+// before before, and returns how many it deleted
+func (s *FileStorage) Prune(prefix string, before time.Time) (int, error) {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.directory, e.Name())); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
 // DefaultFileSystem implements FileSystem using os package
 type DefaultFileSystem struct{}
 
@@ -1,16 +1,64 @@
 package hls
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	ErrBufferFull = errors.New("segment buffer is full: maximum duration exceeded")
+	ErrBufferFull    = errors.New("segment buffer is full: maximum duration exceeded")
+	ErrManagerKilled = errors.New("playlist manager is killed")
+	ErrEmptyContent  = errors.New("content has no segments")
+	// ErrPreflightFailed is wrapped by Add when ManagerConfig.Preflight rejects a content
+	ErrPreflightFailed = errors.New("content failed pre-flight check")
+	// ErrContentUnavailable is wrapped by Add when the segments of a content cannot be
+	// loaded, e.g. because its m3u8 is missing or empty
+	ErrContentUnavailable = errors.New("content could not be loaded")
 )
 
+const (
+	defaultHighWaterMark = 100.0
+	defaultLowWaterMark  = 60.0
+)
+
+// ManagerConfig defines buffering parameters for playlistManager
+type ManagerConfig struct {
+	// HighWaterMark is the buffered duration (seconds) above which Add blocks
+	HighWaterMark float64
+	// LowWaterMark is the buffered duration (seconds) the queue must drain to before blocked Adds resume
+	LowWaterMark float64
+	// FillerURI is published (with a discontinuity) whenever the queue runs dry. Empty disables filler.
+	FillerURI string
+	// FillerDuration is the duration (seconds) of the segment at FillerURI
+	FillerDuration float64
+	// URLRewriter rewrites the segment URIs of every queued content, e.g. to point them
+	// at a CDN. Filler segments, which this server serves itself, are not rewritten.
+	URLRewriter URLRewriter
+	// Preflight checks every content before it is queued, e.g. with NewPreflight.
+	// Rejected contents are not queued. Nil disables the check.
+	Preflight func(Content) error
+	// Encryption encrypts the segments of every queued content with rotating AES-128 keys.
+	// It is applied before URLRewriter; filler segments stay in the clear. Nil disables it.
+	Encryption *KeyRotator
+}
+
+// fillerContentID is the ContentID of filler segments in logs. Catalogs may use it as
+// well, so filler is told apart by segment.filler, never by this ID.
+const fillerContentID = "filler"
+
+// DefaultManagerConfig returns the buffering parameters used when none are specified
+func DefaultManagerConfig() ManagerConfig {
+	return ManagerConfig{
+		HighWaterMark: defaultHighWaterMark,
+		LowWaterMark:  defaultLowWaterMark,
+	}
+}
+
 // PlaylistUpdater defines the interface for playlist update operations
 type PlaylistUpdater interface {
 	Update(segment) float64
@@ -19,7 +67,7 @@ type PlaylistUpdater interface {
 // StreamManager defines the interface for managing HLS streams
 type StreamManager interface {
 	Run()
-	Add(Content) error
+	Add(context.Context, Content) error
 	Kill()
 	Pause()
 	Resume()
@@ -32,25 +80,47 @@ type playlistManager struct {
 	killChan   chan struct{}
 	pauseChan  chan struct{}
 	resumeChan chan struct{}
+	// lowWaterChan is closed (and replaced) whenever the buffer drains below the low-water mark
+	lowWaterChan chan struct{}
 
-	enoughBufferDuration float64
+	config ManagerConfig
+	logger *slog.Logger
+
+	// counters exposed through Stats
+	tracksPlayed     atomic.Int64
+	bufferFullWaits  atomic.Int64
+	underruns        atomic.Int64 // times the queue ran dry while streaming
+	fillerSegments   atomic.Int64
+	preflightRejects atomic.Int64
+	loadFailures     atomic.Int64
+	updateLateness   atomic.Int64 // nanoseconds the last update fired after it was scheduled
+	underrunSince    atomic.Int64 // unix nanoseconds the queue ran dry; zero while contents air
 
 	statusMu sync.Mutex
 	segQMu   sync.Mutex
 	status   Status
 }
 
-func NewPlaylistManager(p PlaylistUpdater) *playlistManager {
+func NewPlaylistManager(p PlaylistUpdater, config ManagerConfig) *playlistManager {
+	if config.HighWaterMark <= 0 {
+		config.HighWaterMark = defaultHighWaterMark
+	}
+	if config.LowWaterMark <= 0 || config.LowWaterMark > config.HighWaterMark {
+		config.LowWaterMark = config.HighWaterMark
+	}
+
 	return &playlistManager{
 		p: p,
 		segQ: segmentsQueue{
 			segments: make([]segment, 0),
 		},
-		killChan:   make(chan struct{}),
-		pauseChan:  make(chan struct{}),
-		resumeChan: make(chan struct{}),
+		killChan:     make(chan struct{}),
+		pauseChan:    make(chan struct{}),
+		resumeChan:   make(chan struct{}),
+		lowWaterChan: make(chan struct{}),
 
-		enoughBufferDuration: 100.0,
+		config: config,
+		logger: slog.Default(),
 
 		statusMu: sync.Mutex{},
 		segQMu:   sync.Mutex{},
@@ -68,33 +138,73 @@ func (m *playlistManager) Run() {
 	m.statusMu.Unlock()
 
 	var updatePlaylistChan <-chan time.Time
-	updatePlaylistChan = time.After(time.Duration(250) * time.Millisecond)
+	var scheduledAt time.Time
+	scheduleAt := func(at time.Time) {
+		scheduledAt = at
+		updatePlaylistChan = time.After(time.Until(at))
+	}
+	schedule := func(d time.Duration) {
+		scheduleAt(time.Now().Add(d))
+	}
+	schedule(time.Duration(250) * time.Millisecond)
+	underrun := false
 
 	for {
 		select {
-		case <-updatePlaylistChan:
+		case firedAt := <-updatePlaylistChan:
+			m.updateLateness.Store(int64(firedAt.Sub(scheduledAt)))
+
 			m.statusMu.Lock()
 			if m.status != StatusStreaming {
-				updatePlaylistChan = time.After(time.Second)
+				m.statusMu.Unlock()
+				schedule(time.Second)
 				continue
 			}
 			m.statusMu.Unlock()
 
 			m.segQMu.Lock()
-			if seg, err := m.segQ.pop(); err == nil {
-				fmt.Println("pop", seg.String()) //TEST
+			seg, err := m.segQ.pop()
+			if err == nil {
+				if underrun {
+					m.logger.Info("buffer recovered from underrun", "content_id", seg.contentID)
+					underrun = false
+					m.underrunSince.Store(0)
+				}
+				if seg.discontinuity {
+					m.tracksPlayed.Add(1)
+					m.logger.Info("started content", "content_id", seg.contentID)
+				}
+			} else {
+				if !underrun {
+					m.underruns.Add(1)
+					m.logger.Warn("segment queue ran dry", "filler", m.config.FillerURI != "")
+					underrun = true
+					m.underrunSince.Store(firedAt.UnixNano())
+				}
+				if m.config.FillerURI != "" {
+					// 無音セグメントで再生を継続する（毎回DISCONTINUITYを付ける）
+					seg = NewSegment(m.config.FillerDuration, m.config.FillerURI, true)
+					seg.contentID = fillerContentID
+					seg.filler = true
+					m.fillerSegments.Add(1)
+					err = nil
+				}
+			}
+
+			if err == nil {
 				wait := m.p.Update(seg)
-				fmt.Println("wait", wait) //TEST
 				if wait >= 0 {
-					updatePlaylistChan = time.After(time.Duration(int(wait) * int(time.Second)))
+					// 前回の予定時刻から数えるので、タイマーの遅れや端数が積もって
+					// 公開がセグメントの長さより遅れていくことがない
+					scheduleAt(scheduledAt.Add(secondsToDuration(wait)))
 				} else {
-					//TODO: logging  wait is not positive
-					updatePlaylistChan = time.After(time.Second)
+					m.logger.Warn("playlist update returned negative wait", "content_id", seg.contentID, "wait", wait)
+					schedule(time.Second)
 				}
 			} else {
-				// TODO: logging  queue is empty or error
-				updatePlaylistChan = time.After(time.Second)
+				schedule(time.Second)
 			}
+			m.notifyIfDrained()
 			m.segQMu.Unlock()
 
 		case <-m.pauseChan:
@@ -121,26 +231,99 @@ func (m *playlistManager) Run() {
 	}
 }
 
-func (m *playlistManager) Add(c Content) error {
+// Add queues the segments of c, blocking while the buffer is above the high-water mark.
+// A blocked Add resumes once the buffer drains to the low-water mark, or returns when
+// ctx is done or the manager is killed.
+func (m *playlistManager) Add(ctx context.Context, c Content) error {
+	segs, err := c.ToSegments()
+	if err != nil {
+		m.loadFailures.Add(1)
+		return fmt.Errorf("content %s: %w: %w", c.ID(), ErrContentUnavailable, err)
+	}
+	if len(segs) == 0 {
+		m.loadFailures.Add(1)
+		return fmt.Errorf("content %s: %w: %w", c.ID(), ErrContentUnavailable, ErrEmptyContent)
+	}
+	if m.config.Preflight != nil {
+		if err := m.config.Preflight(c); err != nil {
+			m.preflightRejects.Add(1)
+			return fmt.Errorf("content %s: %w: %w", c.ID(), ErrPreflightFailed, err)
+		}
+	}
+	if m.config.Encryption != nil {
+		if err := m.config.Encryption.checkEncryptable(segs); err != nil {
+			m.preflightRejects.Add(1)
+			return fmt.Errorf("content %s: %w: %w", c.ID(), ErrPreflightFailed, err)
+		}
+	}
+
 	m.segQMu.Lock()
-	defer m.segQMu.Unlock()
+	if m.segQ.totalDuration > m.config.HighWaterMark {
+		m.bufferFullWaits.Add(1)
+	}
+	for m.segQ.totalDuration > m.config.HighWaterMark {
+		lowWaterChan := m.lowWaterChan
+		m.segQMu.Unlock()
+
+		select {
+		case <-lowWaterChan:
+		case <-m.killChan:
+			return ErrManagerKilled
+		case <-ctx.Done():
+			m.segQMu.Lock()
+			current := m.segQ.totalDuration
+			m.segQMu.Unlock()
+			return fmt.Errorf("buffer full (current: %.2f, max: %.2f): %w: %w",
+				current,
+				m.config.HighWaterMark,
+				ErrBufferFull,
+				ctx.Err())
+		}
 
-	if m.segQ.totalDuration > m.enoughBufferDuration {
-		return fmt.Errorf("buffer full (current: %.2f, max: %.2f): %w",
-			m.segQ.totalDuration,
-			m.enoughBufferDuration,
-			ErrBufferFull)
+		m.segQMu.Lock()
 	}
+	defer m.segQMu.Unlock()
 
-	segs := c.ToSegments()
 	segs[0].discontinuity = true // 最初のセグメントにはDISCONTINUITYを入れる
+	for i := range segs {
+		segs[i].sourceURI = segs[i].uri
+		segs[i].sourceInitURI = segs[i].initURI
+	}
+	if m.config.Encryption != nil {
+		for i := range segs {
+			if segs[i], err = m.config.Encryption.encrypt(segs[i]); err != nil {
+				return fmt.Errorf("content %s: %w", c.ID(), err)
+			}
+		}
+	}
 	for _, seg := range segs {
-		fmt.Println(seg.String()) //TEST
+		seg.contentID = c.ID()
+		if m.config.URLRewriter != nil {
+			seg.rawURI = seg.uri
+			seg.uri = m.config.URLRewriter.RewriteURL(seg.uri)
+			if seg.initURI != "" {
+				seg.initURI = m.config.URLRewriter.RewriteURL(seg.initURI)
+			}
+		}
 		m.segQ.push(seg)
 	}
+	m.logger.Debug("queued content",
+		"content_id", c.ID(),
+		"segments", len(segs),
+		"buffered_seconds", m.segQ.totalDuration)
 	return nil
 }
 
+// notifyIfDrained wakes up blocked Adds once the buffer is at or below the low-water mark.
+// The caller must hold segQMu.
+func (m *playlistManager) notifyIfDrained() {
+	if m.segQ.totalDuration > m.config.LowWaterMark {
+		return
+	}
+	close(m.lowWaterChan)
+	m.lowWaterChan = make(chan struct{})
+}
+
 func (m *playlistManager) Kill() {
 	m.statusMu.Lock()
 	defer m.statusMu.Unlock()
@@ -166,3 +349,47 @@ func (m *playlistManager) Resume() {
 		m.resumeChan <- struct{}{}
 	}
 }
+
+// ManagerStats is a point-in-time snapshot of a playlistManager
+type ManagerStats struct {
+	Status           Status
+	BufferedSeconds  float64
+	TracksPlayed     int64
+	BufferFullWaits  int64
+	Underruns        int64
+	FillerSegments   int64
+	PreflightRejects int64
+	LoadFailures     int64
+	UpdateLateness   time.Duration
+	// UnderrunSince is when the queue ran dry, zero while contents are airing. Filler keeps
+	// the playlist advancing during an underrun, so only this tells that nothing else airs.
+	UnderrunSince time.Time
+}
+
+func (m *playlistManager) Stats() ManagerStats {
+	m.statusMu.Lock()
+	status := m.status
+	m.statusMu.Unlock()
+
+	m.segQMu.Lock()
+	buffered := m.segQ.totalDuration
+	m.segQMu.Unlock()
+
+	var underrunSince time.Time
+	if ns := m.underrunSince.Load(); ns != 0 {
+		underrunSince = time.Unix(0, ns)
+	}
+
+	return ManagerStats{
+		Status:           status,
+		BufferedSeconds:  buffered,
+		TracksPlayed:     m.tracksPlayed.Load(),
+		BufferFullWaits:  m.bufferFullWaits.Load(),
+		Underruns:        m.underruns.Load(),
+		FillerSegments:   m.fillerSegments.Load(),
+		PreflightRejects: m.preflightRejects.Load(),
+		LoadFailures:     m.loadFailures.Load(),
+		UpdateLateness:   time.Duration(m.updateLateness.Load()),
+		UnderrunSince:    underrunSince,
+	}
+}
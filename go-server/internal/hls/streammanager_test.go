@@ -3,6 +3,7 @@ package hls
 import (
 	"context"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"strconv"
 	"sync"
@@ -17,12 +18,14 @@ type mockPlaylist struct {
 	lastSegment *segment
 	updateDelay time.Duration
 	err         error
+	wait        float64 // returned by Update
 }
 
 func newMockPlaylist() *mockPlaylist {
 	return &mockPlaylist{
 		mu:          sync.Mutex{},
 		updateCount: 0,
+		wait:        10.0,
 	}
 }
 
@@ -40,7 +43,7 @@ func (m *mockPlaylist) Update(seg segment) float64 {
 
 	m.updateCount++
 	m.lastSegment = &seg
-	return 10.0
+	return m.wait
 }
 
 func (m *mockPlaylist) SetError(err error) {
@@ -80,8 +83,12 @@ func newMockContent(segments []segment) mockContent {
 	}
 }
 
-func (m mockContent) ToSegments() []segment {
-	return m.segments
+func (m mockContent) ID() string {
+	return strconv.Itoa(m.id)
+}
+
+func (m mockContent) ToSegments() ([]segment, error) {
+	return m.segments, nil
 }
 
 func (m mockContent) SourcePath() string {
@@ -114,7 +121,7 @@ type testContext struct {
 func newTestContext(t *testing.T) *testContext {
 	ctx, cancel := context.WithCancel(context.Background())
 	playlist := newMockPlaylist()
-	manager := NewPlaylistManager(playlist)
+	manager := NewPlaylistManager(playlist, DefaultManagerConfig())
 
 	return &testContext{
 		ctx:      ctx,
@@ -192,8 +199,11 @@ func TestNewPlaylistManager(t *testing.T) {
 					t.Errorf("initial status = %v, want %v", tc.manager.status, StatusDefault)
 				}
 
-				if tc.manager.enoughBufferDuration != 100.0 {
-					t.Errorf("buffer duration = %v, want %v", tc.manager.enoughBufferDuration, 100.0)
+				if tc.manager.config.HighWaterMark != 100.0 {
+					t.Errorf("high water mark = %v, want %v", tc.manager.config.HighWaterMark, 100.0)
+				}
+				if tc.manager.config.LowWaterMark != 60.0 {
+					t.Errorf("low water mark = %v, want %v", tc.manager.config.LowWaterMark, 60.0)
 				}
 			},
 			timeout: time.Second,
@@ -247,6 +257,124 @@ func TestPlaylistManager_Run(t *testing.T) {
 			},
 			timeout: time.Second,
 		},
+		{
+			name: "sub_second_wait",
+			setup: func(tc *testContext) {
+				tc.playlist.wait = 0.3
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				defer tc.manager.Kill()
+				if err := tc.manager.Add(tc.ctx, newMockContent([]segment{
+					{duration: 0.3, uri: "test1.ts"},
+					{duration: 0.3, uri: "test2.ts"},
+					{duration: 0.3, uri: "test3.ts"},
+				})); err != nil {
+					return err
+				}
+				go tc.manager.Run()
+				// 250ms, 550ms, 850ms に公開される。秒に切り捨てると全部 250ms に出てしまう
+				time.Sleep(400 * time.Millisecond)
+				if n := tc.playlist.GetUpdateCount(); n != 1 {
+					t.Errorf("%d segments published after 400ms, want 1", n)
+				}
+				time.Sleep(600 * time.Millisecond)
+				if n := tc.playlist.GetUpdateCount(); n != 3 {
+					t.Errorf("%d segments published after 1s, want 3", n)
+				}
+				return nil
+			},
+			timeout: 2 * time.Second,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runTestCase(t, tc)
+		})
+	}
+}
+
+func TestPlaylistManager_Filler(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "publish_filler_on_underrun",
+			setup: func(tc *testContext) {
+				tc.manager.config.FillerURI = "/stations/test/silence.ts"
+				tc.manager.config.FillerDuration = 9.984
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				go tc.manager.Run()
+				time.Sleep(400 * time.Millisecond)
+				return nil
+			},
+			verify: func(t *testing.T, tc *testContext) {
+				last := tc.playlist.GetLastSegment()
+				if last == nil {
+					t.Fatal("no segment was published")
+				}
+				if last.uri != "/stations/test/silence.ts" || !last.discontinuity || !last.filler {
+					t.Errorf("published segment = %v, want filler with discontinuity", last.String())
+				}
+				stats := tc.manager.Stats()
+				if stats.Underruns != 1 || stats.FillerSegments != 1 {
+					t.Errorf("underruns = %v, fillers = %v, want 1 and 1", stats.Underruns, stats.FillerSegments)
+				}
+				if stats.TracksPlayed != 0 {
+					t.Errorf("tracks played = %v, filler should not count as a track", stats.TracksPlayed)
+				}
+				if stats.UnderrunSince.IsZero() {
+					t.Error("UnderrunSince is zero while filler airs")
+				}
+			},
+			timeout: time.Second,
+		},
+		{
+			name: "recover_from_underrun",
+			setup: func(tc *testContext) {
+				tc.manager.config.FillerURI = "/stations/test/silence.ts"
+				tc.manager.config.FillerDuration = 0.1
+				tc.playlist.wait = 0.1
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				defer tc.manager.Kill()
+				go tc.manager.Run()
+				time.Sleep(400 * time.Millisecond)
+				if tc.manager.Stats().UnderrunSince.IsZero() {
+					t.Error("UnderrunSince is zero while filler airs")
+				}
+				// 0.1 秒ごとに公開されるので、20 セグメントあれば確認の間は枯渇しない
+				segs := make([]segment, 20)
+				for i := range segs {
+					segs[i] = segment{duration: 1.0, uri: fmt.Sprintf("test%d.ts", i)}
+				}
+				if err := tc.manager.Add(tc.ctx, newMockContent(segs)); err != nil {
+					return err
+				}
+				time.Sleep(300 * time.Millisecond)
+				if since := tc.manager.Stats().UnderrunSince; !since.IsZero() {
+					t.Errorf("UnderrunSince = %v after a content aired, want zero", since)
+				}
+				return nil
+			},
+			timeout: 2 * time.Second,
+		},
+		{
+			name: "no_filler_configured",
+			run: func(t *testing.T, tc *testContext) error {
+				go tc.manager.Run()
+				time.Sleep(400 * time.Millisecond)
+				return nil
+			},
+			verify: func(t *testing.T, tc *testContext) {
+				if count := tc.playlist.GetUpdateCount(); count != 0 {
+					t.Errorf("update count = %v, want 0", count)
+				}
+				if stats := tc.manager.Stats(); stats.Underruns != 1 {
+					t.Errorf("underruns = %v, want 1", stats.Underruns)
+				}
+			},
+			timeout: time.Second,
+		},
 	}
 
 	for _, tc := range tests {
@@ -269,7 +397,7 @@ func TestPlaylistManager_Add(t *testing.T) {
 					{duration: 10.0, uri: "test1.ts"},
 					{duration: 10.0, uri: "test2.ts"},
 				})
-				return tc.manager.Add(content)
+				return tc.manager.Add(tc.ctx, content)
 			},
 			verify: func(t *testing.T, tc *testContext) {
 				tc.manager.segQMu.Lock()
@@ -293,7 +421,7 @@ func TestPlaylistManager_Add(t *testing.T) {
 					{duration: 60.0, uri: "test1.ts"},
 					{duration: 60.0, uri: "test2.ts"},
 				})
-				if err := tc.manager.Add(content); err != nil {
+				if err := tc.manager.Add(tc.ctx, content); err != nil {
 					t.Errorf("failed to add content: %v", err)
 				}
 			},
@@ -301,14 +429,157 @@ func TestPlaylistManager_Add(t *testing.T) {
 				content := newMockContent([]segment{
 					{duration: 10.0, uri: "test3.ts"},
 				})
-				err := tc.manager.Add(content)
+				ctx, cancel := context.WithTimeout(tc.ctx, 100*time.Millisecond)
+				defer cancel()
+				err := tc.manager.Add(ctx, content)
 				if !errors.Is(err, ErrBufferFull) {
 					t.Errorf("expected ErrBufferFull but got: %v", err)
 				}
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("expected context.DeadlineExceeded but got: %v", err)
+				}
 				return nil
 			},
 			timeout: time.Second,
 		},
+		{
+			name: "add_content_blocks_until_low_water",
+			setup: func(tc *testContext) {
+				tc.manager.config = ManagerConfig{HighWaterMark: 15.0, LowWaterMark: 10.0}
+				content := newMockContent([]segment{
+					{duration: 10.0, uri: "test1.ts"},
+					{duration: 10.0, uri: "test2.ts"},
+				})
+				if err := tc.manager.Add(tc.ctx, content); err != nil {
+					t.Errorf("failed to add content: %v", err)
+				}
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				added := make(chan error, 1)
+				go func() {
+					added <- tc.manager.Add(tc.ctx, newMockContent([]segment{
+						{duration: 10.0, uri: "test3.ts"},
+					}))
+				}()
+
+				select {
+				case err := <-added:
+					return fmt.Errorf("Add returned before the buffer drained: %v", err)
+				case <-time.After(100 * time.Millisecond):
+				}
+
+				// Runが最初のセグメントをpopするとlow water markまで下がる
+				go tc.manager.Run()
+				select {
+				case err := <-added:
+					return err
+				case <-time.After(time.Second):
+					return errors.New("Add did not resume after the buffer drained")
+				}
+			},
+			verify: func(t *testing.T, tc *testContext) {
+				tc.manager.segQMu.Lock()
+				qLen := len(tc.manager.segQ.segments)
+				tc.manager.segQMu.Unlock()
+
+				if qLen != 2 {
+					t.Errorf("queue length = %v, want %v", qLen, 2)
+				}
+			},
+			timeout: 2 * time.Second,
+		},
+		{
+			name: "add_content_unblocked_by_kill",
+			setup: func(tc *testContext) {
+				go tc.manager.Run()
+				time.Sleep(100 * time.Millisecond)
+				tc.playlist.SetUpdateDelay(time.Second)
+				content := newMockContent([]segment{
+					{duration: 60.0, uri: "test1.ts"},
+					{duration: 60.0, uri: "test2.ts"},
+					{duration: 60.0, uri: "test3.ts"},
+				})
+				if err := tc.manager.Add(tc.ctx, content); err != nil {
+					t.Errorf("failed to add content: %v", err)
+				}
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					tc.manager.Kill()
+				}()
+				err := tc.manager.Add(tc.ctx, newMockContent([]segment{
+					{duration: 10.0, uri: "test4.ts"},
+				}))
+				if !errors.Is(err, ErrManagerKilled) {
+					t.Errorf("expected ErrManagerKilled but got: %v", err)
+				}
+				return nil
+			},
+			timeout: 3 * time.Second,
+		},
+		{
+			name: "add_empty_content",
+			run: func(t *testing.T, tc *testContext) error {
+				err := tc.manager.Add(tc.ctx, newMockContent([]segment{}))
+				if !errors.Is(err, ErrEmptyContent) {
+					t.Errorf("expected ErrEmptyContent but got: %v", err)
+				}
+				return nil
+			},
+			timeout: time.Second,
+		},
+		{
+			name: "add_content_rewrites_segment_urls",
+			setup: func(tc *testContext) {
+				tc.manager.config.URLRewriter = SegmentURLBase{BaseURL: "https://cdn.example.com"}
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				return tc.manager.Add(tc.ctx, newMockContent([]segment{
+					{duration: 10.0, uri: "/contents/music/1/test1.ts"},
+					{duration: 10.0, uri: "https://origin.example.com/test2.ts"},
+					{duration: 10.0, uri: "/contents/music/2/0.m4s", initURI: "/contents/music/2/init.mp4"},
+				}))
+			},
+			verify: func(t *testing.T, tc *testContext) {
+				tc.manager.segQMu.Lock()
+				defer tc.manager.segQMu.Unlock()
+				want := []string{"https://cdn.example.com/contents/music/1/test1.ts", "https://origin.example.com/test2.ts", "https://cdn.example.com/contents/music/2/0.m4s"}
+				for i, seg := range tc.manager.segQ.segments {
+					if seg.uri != want[i] {
+						t.Errorf("segment %d uri = %q, want %q", i, seg.uri, want[i])
+					}
+				}
+				if seg := tc.manager.segQ.segments[2]; seg.initURI != "https://cdn.example.com/contents/music/2/init.mp4" || seg.sourceInitURI != "/contents/music/2/init.mp4" {
+					t.Errorf("init uri = %q, source %q", seg.initURI, seg.sourceInitURI)
+				}
+			},
+			timeout: time.Second,
+		},
+		{
+			name: "add_content_rejected_by_preflight",
+			setup: func(tc *testContext) {
+				tc.manager.config.Preflight = func(c Content) error {
+					return errors.New("continuity counter error")
+				}
+			},
+			run: func(t *testing.T, tc *testContext) error {
+				err := tc.manager.Add(tc.ctx, newMockContent([]segment{
+					{duration: 10.0, uri: "test1.ts"},
+				}))
+				if !errors.Is(err, ErrPreflightFailed) {
+					t.Errorf("expected ErrPreflightFailed but got: %v", err)
+				}
+				return nil
+			},
+			verify: func(t *testing.T, tc *testContext) {
+				stats := tc.manager.Stats()
+				if stats.BufferedSeconds != 0 || stats.PreflightRejects != 1 {
+					t.Errorf("buffered = %v, rejects = %v, want nothing queued and 1 reject", stats.BufferedSeconds, stats.PreflightRejects)
+				}
+			},
+			timeout: time.Second,
+		},
 	}
 
 	for _, tc := range tests {
@@ -450,7 +721,7 @@ func TestPlaylistManager_Concurrency(t *testing.T) {
 						content := newMockContent([]segment{
 							{duration: 10.0, uri: "test1.ts"},
 						})
-						if err := tc.manager.Add(content); err != nil {
+						if err := tc.manager.Add(tc.ctx, content); err != nil {
 							tc.errChan <- err
 						}
 					}(i)
@@ -0,0 +1,183 @@
+package hls
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SupervisorConfig defines how a station restarts its dj after a failure
+type SupervisorConfig struct {
+	// InitialBackoff is the delay before the first restart; it doubles after every consecutive failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the restart delay. A dj that ran longer than this resets the backoff.
+	MaxBackoff time.Duration
+	// FallbackAfter is the number of consecutive failures after which the fallback contents are played
+	FallbackAfter int
+	// EmergencyPlaylist is an m3u8 relative to the contents root played when the catalog is unusable.
+	// Empty disables the fallback.
+	EmergencyPlaylist string
+	// RetryPrimaryAfter is how often the catalog is tried again while the fallback is played,
+	// in case it recovered without changing
+	RetryPrimaryAfter time.Duration
+}
+
+func DefaultSupervisorConfig() SupervisorConfig {
+	return SupervisorConfig{
+		InitialBackoff:    time.Second,
+		MaxBackoff:        time.Minute,
+		FallbackAfter:     3,
+		RetryPrimaryAfter: 5 * time.Minute,
+	}
+}
+
+type supervisor struct {
+	dj       *dj
+	primary  logic
+	fallback logic // nil when no fallback is configured
+	config   SupervisorConfig
+	logger   *slog.Logger
+
+	restarts      atomic.Int64
+	usingFallback atomic.Bool
+	// retrying is set when retryPrimary switched back to the catalog, until the dj fails;
+	// addedAtRetry is the number of contents the dj had added then
+	retrying     atomic.Bool
+	addedAtRetry atomic.Int64
+	selectMu     sync.Mutex
+}
+
+func newSupervisor(d *dj, config SupervisorConfig, logger *slog.Logger) *supervisor {
+	defaults := DefaultSupervisorConfig()
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaults.InitialBackoff
+	}
+	if config.MaxBackoff < config.InitialBackoff {
+		config.MaxBackoff = config.InitialBackoff
+	}
+	if config.FallbackAfter <= 0 {
+		config.FallbackAfter = defaults.FallbackAfter
+	}
+	if config.RetryPrimaryAfter <= 0 {
+		config.RetryPrimaryAfter = defaults.RetryPrimaryAfter
+	}
+
+	s := &supervisor{
+		dj:      d,
+		primary: d.currentLogic(),
+		config:  config,
+		logger:  logger,
+	}
+	if config.EmergencyPlaylist != "" {
+		s.fallback = &loopLogic{
+			contents: []Content{NewPlaylistFileContent("emergency", config.EmergencyPlaylist)},
+		}
+	}
+	return s
+}
+
+// Run keeps the dj running until ctx is done or the manager is killed,
+// restarting it with exponential backoff whenever it fails
+func (s *supervisor) Run(ctx context.Context) {
+	backoff := s.config.InitialBackoff
+	failures := 0
+
+	if cl, ok := s.primary.(catalogLogic); ok {
+		go s.watchCatalog(ctx, cl.catalog)
+	}
+	if s.fallback != nil {
+		go s.retryPrimary(ctx)
+	}
+
+	for {
+		s.selectLogic(failures)
+
+		startedAt := time.Now()
+		err := s.dj.Start(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// しばらく正常に動いていたなら連続失敗とはみなさない
+		if time.Since(startedAt) > s.config.MaxBackoff {
+			backoff = s.config.InitialBackoff
+			failures = 0
+		}
+		// 再試行したカタログから1曲も追加できなければ、すぐに緊急プレイリストへ戻す
+		if s.retrying.Swap(false) && s.dj.added.Load() == s.addedAtRetry.Load() {
+			failures = max(failures, s.config.FallbackAfter-1)
+		}
+		failures++
+
+		s.logger.Warn("dj failed, restarting",
+			"error", err,
+			"consecutive_failures", failures,
+			"backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		s.restarts.Add(1)
+		backoff = min(backoff*2, s.config.MaxBackoff)
+	}
+}
+
+// watchCatalog returns to the catalog as soon as it changes while the fallback is playing
+func (s *supervisor) watchCatalog(ctx context.Context, catalog Catalog) {
+	for range catalog.Watch(ctx) {
+		s.logger.Info("catalog changed", "catalog_size", s.primary.Len())
+		if s.usingFallback.Load() {
+			s.selectLogic(0)
+		}
+	}
+}
+
+// retryPrimary switches back to the catalog every RetryPrimaryAfter while the fallback is
+// played: the dj does not fail while it plays the fallback, so Run would never try the
+// catalog again otherwise. If the catalog still fails, Run falls back at its next failure.
+func (s *supervisor) retryPrimary(ctx context.Context) {
+	ticker := time.NewTicker(s.config.RetryPrimaryAfter)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.usingFallback.Load() && s.primary.Len() > 0 {
+				s.logger.Info("retrying catalog", "catalog_size", s.primary.Len())
+				s.addedAtRetry.Store(s.dj.added.Load())
+				s.retrying.Store(true)
+				s.selectLogic(0)
+			}
+		}
+	}
+}
+
+// selectLogic switches the dj to the fallback contents when the catalog is unusable
+// or the dj keeps failing, and back to the catalog otherwise
+func (s *supervisor) selectLogic(failures int) {
+	s.selectMu.Lock()
+	defer s.selectMu.Unlock()
+
+	useFallback := s.fallback != nil && (s.primary.Len() == 0 || failures >= s.config.FallbackAfter)
+	if useFallback == s.usingFallback.Load() {
+		return
+	}
+
+	if useFallback {
+		s.logger.Warn("catalog unusable, switching to emergency playlist",
+			"emergency_playlist", s.config.EmergencyPlaylist,
+			"catalog_size", s.primary.Len(),
+			"consecutive_failures", failures)
+		s.dj.setLogic(s.fallback)
+	} else {
+		s.logger.Info("switching back to catalog")
+		s.dj.setLogic(s.primary)
+	}
+	s.usingFallback.Store(useFallback)
+}
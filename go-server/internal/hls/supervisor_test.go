@@ -0,0 +1,208 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSupervisor(primary logic, config SupervisorConfig) (*supervisor, *playlistManager) {
+	manager := NewPlaylistManager(newMockPlaylist(), DefaultManagerConfig())
+	d := &dj{
+		manager: manager,
+		logic:   primary,
+		logger:  slog.Default(),
+	}
+	return newSupervisor(d, config, slog.Default()), manager
+}
+
+func TestSupervisor_RestartsWithBackoff(t *testing.T) {
+	s, manager := newTestSupervisor(randomLogic{}, SupervisorConfig{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+	})
+	defer manager.Kill()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not stop after ctx was canceled")
+	}
+
+	if restarts := s.restarts.Load(); restarts < 2 {
+		t.Errorf("restarts = %v, want at least 2", restarts)
+	}
+	if s.usingFallback.Load() {
+		t.Error("fallback should not be used when none is configured")
+	}
+	if _, err := s.dj.lastError(); err == nil {
+		t.Error("last dj error should be recorded")
+	}
+}
+
+func TestSupervisor_FallbackWhenCatalogEmpty(t *testing.T) {
+	s, manager := newTestSupervisor(randomLogic{}, SupervisorConfig{
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	defer manager.Kill()
+	s.fallback = &loopLogic{contents: []Content{
+		newMockContent([]segment{{duration: 10.0, uri: "emergency.ts"}}),
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for {
+		manager.segQMu.Lock()
+		queued := len(manager.segQ.segments)
+		manager.segQMu.Unlock()
+		if queued > 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("fallback content was never queued")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if !s.usingFallback.Load() {
+		t.Error("supervisor should be using the fallback")
+	}
+	if s.restarts.Load() != 0 {
+		t.Errorf("restarts = %v, want 0", s.restarts.Load())
+	}
+}
+
+// flakyLogic fails every Choice while broken is set
+type flakyLogic struct {
+	broken  atomic.Bool
+	choices atomic.Int64
+	content Content
+}
+
+func (f *flakyLogic) Choice() (Content, error) {
+	if f.broken.Load() {
+		return nil, errors.New("catalog is broken")
+	}
+	f.choices.Add(1)
+	return f.content, nil
+}
+
+func (f *flakyLogic) Len() int {
+	return 1
+}
+
+func TestSupervisor_RetriesCatalogFromFallback(t *testing.T) {
+	primary := &flakyLogic{content: newMockContent([]segment{{duration: 0.1, uri: "primary.ts"}})}
+	primary.broken.Store(true)
+	s, manager := newTestSupervisor(primary, SupervisorConfig{
+		InitialBackoff:    5 * time.Millisecond,
+		FallbackAfter:     1,
+		RetryPrimaryAfter: 20 * time.Millisecond,
+	})
+	defer manager.Kill()
+	s.fallback = &loopLogic{contents: []Content{
+		newMockContent([]segment{{duration: 0.1, uri: "emergency.ts"}}),
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("the fallback", s.usingFallback.Load)
+
+	// カタログが変わらずに直っても、定期的な再試行で戻る
+	primary.broken.Store(false)
+	waitFor("the catalog", func() bool { return !s.usingFallback.Load() && s.dj.currentLogic() == logic(primary) })
+}
+
+// unavailableContent is a content whose m3u8 cannot be read
+type unavailableContent struct {
+	mockContent
+}
+
+func (unavailableContent) ToSegments() ([]segment, error) {
+	return nil, os.ErrNotExist
+}
+
+// sequenceLogic returns its contents in order, then the last one forever
+type sequenceLogic struct {
+	mu       sync.Mutex
+	contents []Content
+}
+
+func (l *sequenceLogic) Choice() (Content, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.contents[0]
+	if len(l.contents) > 1 {
+		l.contents = l.contents[1:]
+	}
+	return c, nil
+}
+
+func (l *sequenceLogic) Len() int {
+	return len(l.contents)
+}
+
+func TestDJ_SkipsUnavailableContents(t *testing.T) {
+	manager := NewPlaylistManager(newMockPlaylist(), DefaultManagerConfig())
+	defer manager.Kill()
+	missing := unavailableContent{newMockContent(nil)}
+	d := &dj{
+		manager: manager,
+		logic: &sequenceLogic{contents: []Content{
+			missing, missing, newMockContent([]segment{{duration: 0.1, uri: "ok.ts"}}),
+		}},
+		logger: slog.Default(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Start(ctx) }()
+
+	deadline := time.Now().Add(time.Second)
+	for d.added.Load() == 0 {
+		select {
+		case err := <-done:
+			t.Fatalf("dj stopped on an unavailable content: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dj never added the content after the unavailable ones")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if failures := manager.Stats().LoadFailures; failures != 2 {
+		t.Errorf("LoadFailures = %d, want 2", failures)
+	}
+}
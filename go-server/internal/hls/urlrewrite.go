@@ -0,0 +1,69 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URLRewriter maps the segment URIs produced by content formatters to the URIs a
+// station publishes, e.g. to serve segments from a CDN
+type URLRewriter interface {
+	RewriteURL(uri string) string
+}
+
+// URLRewriters applies rewriters in order
+type URLRewriters []URLRewriter
+
+func (rs URLRewriters) RewriteURL(uri string) string {
+	for _, r := range rs {
+		uri = r.RewriteURL(uri)
+	}
+	return uri
+}
+
+// SegmentURLBase points root-relative segment URIs at another origin or path prefix.
+// URIs that are already absolute, such as CDN URLs written in a source m3u8, are kept.
+type SegmentURLBase struct {
+	// BaseURL is prepended to root-relative URIs, e.g. "https://cdn.example.com",
+	// "https://cdn.example.com/radio" or "/edge"
+	BaseURL string
+	// StripPrefix is removed from the start of URIs before BaseURL is prepended, e.g.
+	// "/contents" when the CDN serves the contents root at BaseURL
+	StripPrefix string
+}
+
+// NewSegmentURLBase validates baseURL, which must be an http(s) URL or a root-relative path
+func NewSegmentURLBase(baseURL string, stripPrefix string) (SegmentURLBase, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return SegmentURLBase{}, fmt.Errorf("invalid segment base URL %q: %w", baseURL, err)
+	}
+	switch {
+	case u.RawQuery != "" || u.Fragment != "":
+		return SegmentURLBase{}, fmt.Errorf("segment base URL %q cannot have a query or fragment", baseURL)
+	case u.IsAbs() && (u.Scheme != "http" && u.Scheme != "https" || u.Host == ""):
+		return SegmentURLBase{}, fmt.Errorf("segment base URL %q must be http(s) with a host", baseURL)
+	case !u.IsAbs() && !strings.HasPrefix(baseURL, "/"):
+		return SegmentURLBase{}, fmt.Errorf("segment base URL %q must be absolute or start with /", baseURL)
+	}
+	if stripPrefix != "" && !strings.HasPrefix(stripPrefix, "/") {
+		return SegmentURLBase{}, fmt.Errorf("strip prefix %q must start with /", stripPrefix)
+	}
+	return SegmentURLBase{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		StripPrefix: strings.TrimSuffix(stripPrefix, "/"),
+	}, nil
+}
+
+func (b SegmentURLBase) RewriteURL(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		return uri
+	}
+	if b.StripPrefix != "" {
+		if rest, ok := strings.CutPrefix(uri, b.StripPrefix); ok && strings.HasPrefix(rest, "/") {
+			uri = rest
+		}
+	}
+	return strings.TrimSuffix(b.BaseURL, "/") + uri
+}
@@ -0,0 +1,105 @@
+package hls
+
+import "testing"
+
+func TestSegmentURLBase(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseURL     string
+		stripPrefix string
+		uri         string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:    "cdn origin",
+			baseURL: "https://cdn.example.com/",
+			uri:     "/contents/music/1/seg0.ts",
+			want:    "https://cdn.example.com/contents/music/1/seg0.ts",
+		},
+		{
+			name:        "cdn serving the contents root",
+			baseURL:     "https://cdn.example.com/radio",
+			stripPrefix: "/contents",
+			uri:         "/contents/music/1/seg0.ts",
+			want:        "https://cdn.example.com/radio/music/1/seg0.ts",
+		},
+		{
+			name:        "different path prefix",
+			baseURL:     "/edge",
+			stripPrefix: "/contents/",
+			uri:         "/contents/music/1/seg0.ts",
+			want:        "/edge/music/1/seg0.ts",
+		},
+		{
+			name:        "strip prefix only matches whole path elements",
+			baseURL:     "/edge",
+			stripPrefix: "/contents",
+			uri:         "/contents-old/seg0.ts",
+			want:        "/edge/contents-old/seg0.ts",
+		},
+		{
+			name:    "absolute URL from the source m3u8 is kept",
+			baseURL: "https://cdn.example.com",
+			uri:     "https://other.example.com/seg0.ts",
+			want:    "https://other.example.com/seg0.ts",
+		},
+		{
+			name:    "scheme relative URL is kept",
+			baseURL: "https://cdn.example.com",
+			uri:     "//other.example.com/seg0.ts",
+			want:    "//other.example.com/seg0.ts",
+		},
+		{name: "relative base", baseURL: "cdn.example.com", wantErr: true},
+		{name: "unsupported scheme", baseURL: "ftp://cdn.example.com", wantErr: true},
+		{name: "base with query", baseURL: "https://cdn.example.com/?a=b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := NewSegmentURLBase(tt.baseURL, tt.stripPrefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSegmentURLBase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := base.RewriteURL(tt.uri); got != tt.want {
+				t.Errorf("RewriteURL(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSegmentURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"seg0.ts", "/contents/music/1/seg0.ts"},
+		{"../shared/seg0.ts", "/contents/music/shared/seg0.ts"},
+		{"./hq/seg0.ts?v=2", "/contents/music/1/hq/seg0.ts?v=2"},
+		{"/other/seg0.ts", "/other/seg0.ts"},
+		{"https://cdn.example.com/seg0.ts", "https://cdn.example.com/seg0.ts"},
+	}
+	for _, tt := range tests {
+		if got := resolveSegmentURI("/contents/music/1", tt.uri); got != tt.want {
+			t.Errorf("resolveSegmentURI(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestParseNonTSURIs(t *testing.T) {
+	m3u8 := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+		"#EXTINF:10.0,\nhttps://cdn.example.com/a.ts?token=x\n" +
+		"#EXTINF:10.0,\n../shared/b.aac\n" +
+		"#EXT-X-ENDLIST\n"
+	pf := DefaultPlaylistFormatter{}
+	p, err := pf.Parse(&DefaultPlaylistContent{data: []byte(m3u8)})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(p.segments) != 2 || p.segments[0].uri != "https://cdn.example.com/a.ts?token=x" || p.segments[1].uri != "../shared/b.aac" {
+		t.Errorf("Parse() segments = %+v", p.segments)
+	}
+}
@@ -0,0 +1,144 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+const defaultDurationTolerance = 0.5
+
+// ValidationConfig configures segment validation
+type ValidationConfig struct {
+	// DurationTolerance is how far (seconds) the measured PTS duration of a segment
+	// may be from its EXTINF value
+	DurationTolerance float64
+}
+
+func DefaultValidationConfig() ValidationConfig {
+	return ValidationConfig{DurationTolerance: defaultDurationTolerance}
+}
+
+// SegmentValidation is the result of inspecting one segment of a media playlist
+type SegmentValidation struct {
+	URI string
+	// Path is the local file the URI resolves to; empty for remote segments, which are not inspected
+	Path     string
+	Extinf   float64
+	Measured float64
+	Report   *mpegts.Report
+	Problems []error
+}
+
+// PlaylistValidation is the result of validating a media playlist and its segments
+type PlaylistValidation struct {
+	Path     string
+	Segments []SegmentValidation
+	Problems []error
+}
+
+// Err joins the problems of the playlist and its segments, or returns nil if there are none
+func (v PlaylistValidation) Err() error {
+	errs := append([]error(nil), v.Problems...)
+	for _, seg := range v.Segments {
+		for _, p := range seg.Problems {
+			errs = append(errs, fmt.Errorf("%s: %w", seg.URI, p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateMediaPlaylist parses the m3u8 at path and inspects every local segment it references,
+// comparing the measured audio duration with the EXTINF value
+func ValidateMediaPlaylist(path string, config ValidationConfig) PlaylistValidation {
+	if config.DurationTolerance <= 0 {
+		config.DurationTolerance = defaultDurationTolerance
+	}
+	v := PlaylistValidation{Path: path}
+
+	segments, err := ReadMediaPlaylist(path)
+	if err != nil {
+		v.Problems = append(v.Problems, err)
+		return v
+	}
+	if _, err := MediaPlaylistDuration(segments); err != nil {
+		v.Problems = append(v.Problems, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, seg := range segments {
+		sv := SegmentValidation{URI: seg.URI, Extinf: seg.Duration}
+		// fMP4 のセグメントはTSとして検査できないので、EXTINF の検査だけにする
+		if local, ok := LocalSegmentPath(dir, seg.URI); ok && seg.InitURI == "" {
+			sv.Path = local
+			validateSegment(&sv, config)
+		}
+		v.Segments = append(v.Segments, sv)
+	}
+	return v
+}
+
+func validateSegment(sv *SegmentValidation, config ValidationConfig) {
+	f, err := os.Open(sv.Path)
+	if err != nil {
+		sv.Problems = append(sv.Problems, err)
+		return
+	}
+	defer f.Close()
+
+	report, err := mpegts.Inspect(f)
+	sv.Report = report
+	if err != nil {
+		sv.Problems = append(sv.Problems, err)
+		return
+	}
+	sv.Problems = append(sv.Problems, report.Problems...)
+	sv.Measured = report.Duration
+	if report.Duration > 0 && math.Abs(report.Duration-sv.Extinf) > config.DurationTolerance {
+		sv.Problems = append(sv.Problems,
+			fmt.Errorf("measured duration %.3fs differs from EXTINF %.3fs", report.Duration, sv.Extinf))
+	}
+}
+
+// ValidateTree validates every m3u8 under root. Hidden directories, such as ingest
+// staging directories, are skipped.
+func ValidateTree(root string, config ValidationConfig) ([]PlaylistValidation, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".m3u8" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+	sort.Strings(paths)
+
+	results := make([]PlaylistValidation, len(paths))
+	for i, path := range paths {
+		results[i] = ValidateMediaPlaylist(path, config)
+	}
+	return results, nil
+}
+
+// NewPreflight returns a ManagerConfig.Preflight that validates the m3u8 and segments
+// of every content before it is queued
+func NewPreflight(config ValidationConfig) func(Content) error {
+	return func(c Content) error {
+		return ValidateMediaPlaylist(c.SourcePath(), config).Err()
+	}
+}
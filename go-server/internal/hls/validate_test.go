@@ -0,0 +1,143 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+// writeSilenceContent writes {dir}/{name}.m3u8 with one generated silence segment per extinf value
+func writeSilenceContent(t *testing.T, dir string, name string, extinfs []float64) string {
+	t.Helper()
+	data, _, err := mpegts.GenerateSilence(mpegts.DefaultSilenceConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n")
+	for i, d := range extinfs {
+		uri := fmt.Sprintf("%s_%d.ts", name, i)
+		if err := os.WriteFile(filepath.Join(dir, uri), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", d, uri)
+	}
+	path := filepath.Join(dir, name+".m3u8")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateMediaPlaylist(t *testing.T) {
+	// 生成される無音セグメントは 1024 サンプル単位で 10 秒を超える最小の長さになる
+	tests := []struct {
+		name    string
+		extinfs []float64
+		modify  func(t *testing.T, dir string)
+		wantErr string
+	}{
+		{
+			name:    "valid content",
+			extinfs: []float64{10.0, 10.0},
+		},
+		{
+			name:    "extinf disagrees with measured duration",
+			extinfs: []float64{10.0, 4.0},
+			wantErr: "differs from EXTINF",
+		},
+		{
+			name:    "missing segment",
+			extinfs: []float64{10.0},
+			modify: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "c_0.ts"))
+			},
+			wantErr: "no such file",
+		},
+		{
+			name:    "corrupted segment",
+			extinfs: []float64{10.0},
+			modify: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "c_0.ts")
+				data, _ := os.ReadFile(path)
+				data[mpegts.PacketSize*3] = 0
+				os.WriteFile(path, data, 0644)
+			},
+			wantErr: mpegts.ErrSyncLost.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeSilenceContent(t, dir, "c", tt.extinfs)
+			if tt.modify != nil {
+				tt.modify(t, dir)
+			}
+
+			err := ValidateMediaPlaylist(path, DefaultValidationConfig()).Err()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateMediaPlaylist() = %v, want no problems", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateMediaPlaylist() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTree(t *testing.T) {
+	root := t.TempDir()
+	writeSilenceContent(t, filepath.Join(root, "music", "1"), "1", []float64{10.0})
+	writeSilenceContent(t, filepath.Join(root, "music", "2"), "2", []float64{3.0})
+	writeSilenceContent(t, filepath.Join(root, "music", ".ingest-3-x"), "3", []float64{3.0})
+
+	results, err := ValidateTree(root, DefaultValidationConfig())
+	if err != nil {
+		t.Fatalf("ValidateTree() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("ValidateTree() returned %d playlists, want 2 (staging directories are skipped)", len(results))
+	}
+	if results[0].Err() != nil || results[1].Err() == nil {
+		t.Errorf("ValidateTree() problems = [%v] [%v], want only the second to fail", results[0].Err(), results[1].Err())
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	path := writeSilenceContent(t, t.TempDir(), "broken", []float64{2.0})
+	preflight := NewPreflight(DefaultValidationConfig())
+
+	manager := NewPlaylistManager(newMockPlaylist(), ManagerConfig{Preflight: preflight})
+	err := manager.Add(context.Background(), fileContent{path: path})
+	if !errors.Is(err, ErrPreflightFailed) {
+		t.Errorf("Add() error = %v, want ErrPreflightFailed", err)
+	}
+}
+
+// fileContent is a content backed by an m3u8 at an arbitrary path
+type fileContent struct {
+	mockContent
+	path string
+}
+
+func (c fileContent) SourcePath() string {
+	return c.path
+}
+
+func (c fileContent) ToSegments() ([]segment, error) {
+	return loadSegments(c.path, func(seg segment) segment { return seg })
+}
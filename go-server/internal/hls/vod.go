@@ -0,0 +1,46 @@
+package hls
+
+import "math"
+
+// PlaylistTypeVOD is the EXT-X-PLAYLIST-TYPE of playlists that never change
+const PlaylistTypeVOD = "VOD"
+
+// FormatVOD renders published segments, e.g. a range of an archive, as a VOD playlist.
+// Segments and the EXT-X-MAP of fMP4 segments are referenced by their SourceURI and
+// SourceInitURI, passed through rewriter when it is not nil so that CDN bases and
+// signatures are fresh. Filler, which this server serves itself, is not rewritten, as in
+// the live playlist. A gap in the media sequence, as left by a restart of the station,
+// starts a discontinuity.
+func FormatVOD(segs []PublishedSegment, rewriter URLRewriter) (PlaylistContent, error) {
+	p := &playlist{
+		metadata: playlistMetadata{
+			version:      3,
+			playlistType: PlaylistTypeVOD,
+			endList:      true,
+		},
+		segments: make([]segment, 0, len(segs)),
+	}
+	for i, seg := range segs {
+		uri := seg.SourceURI
+		if uri == "" {
+			uri = seg.URI
+		}
+		initURI := seg.SourceInitURI
+		if rewriter != nil && !seg.Filler {
+			uri = rewriter.RewriteURL(uri)
+			if initURI != "" {
+				initURI = rewriter.RewriteURL(initURI)
+			}
+		}
+		// VOD の TARGETDURATION は最長セグメントを切り上げた値でなければならない
+		p.metadata.targetDuration = max(p.metadata.targetDuration, math.Ceil(seg.Duration))
+		p.segments = append(p.segments, segment{
+			duration:      seg.Duration,
+			uri:           uri,
+			discontinuity: seg.Discontinuity || i > 0 && seg.MediaSequence != segs[i-1].MediaSequence+1,
+			contentID:     seg.ContentID,
+			initURI:       initURI,
+		})
+	}
+	return (&DefaultPlaylistFormatter{}).Format(p)
+}
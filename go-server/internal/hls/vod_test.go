@@ -0,0 +1,61 @@
+package hls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatVOD(t *testing.T) {
+	at := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	segs := []PublishedSegment{
+		{ContentID: "a", URI: "/contents/a/1.ts?token=expired", SourceURI: "/contents/a/1.ts", Duration: 9.8, Discontinuity: true, MediaSequence: 41, PublishedAt: at},
+		{ContentID: "a", URI: "/contents/a/2.ts?token=expired", SourceURI: "/contents/a/2.ts", Duration: 10.2, MediaSequence: 42, PublishedAt: at.Add(10 * time.Second)},
+		// the station restarted: the media sequence starts over
+		{ContentID: "a", SourceURI: "/contents/a/3.ts", Duration: 4, MediaSequence: 0, PublishedAt: at.Add(time.Hour)},
+		// filler is served by this server and never sent to the CDN
+		{ContentID: fillerContentID, URI: "/silence.ts", Duration: 2, Discontinuity: true, Filler: true, MediaSequence: 1, PublishedAt: at.Add(time.Hour)},
+		{ContentID: "b", SourceURI: "/contents/b/0.m4s", SourceInitURI: "/contents/b/init.mp4", Duration: 6, Discontinuity: true, MediaSequence: 2, PublishedAt: at.Add(time.Hour)},
+	}
+
+	cdn, err := NewSegmentURLBase("https://cdn.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := FormatVOD(segs, cdn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:11.000
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-DISCONTINUITY
+#EXTINF:9.800,
+https://cdn.example.com/contents/a/1.ts
+#EXTINF:10.200,
+https://cdn.example.com/contents/a/2.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4.000,
+https://cdn.example.com/contents/a/3.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.000,
+/silence.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="https://cdn.example.com/contents/b/init.mp4"
+#EXTINF:6.000,
+https://cdn.example.com/contents/b/0.m4s
+#EXT-X-ENDLIST
+`
+	if c.String() != want {
+		t.Errorf("FormatVOD() =\n%s\nwant:\n%s", c, want)
+	}
+
+	parsed, err := (&DefaultPlaylistFormatter{}).Parse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.metadata.playlistType != PlaylistTypeVOD || !parsed.metadata.endList || len(parsed.segments) != 5 {
+		t.Errorf("parsed = %+v, want a VOD playlist with ENDLIST and 5 segments", parsed.metadata)
+	}
+}
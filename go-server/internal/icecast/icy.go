@@ -0,0 +1,79 @@
+package icecast
+
+import (
+	"io"
+	"strings"
+)
+
+// DefaultMetaInt is the number of audio bytes between ICY metadata blocks
+const DefaultMetaInt = 16000
+
+// maxMetadataLength is the most a metadata block can carry: its length byte counts 16 bytes
+const maxMetadataLength = 255 * 16
+
+// icyWriter inserts an ICY metadata block after every metaint bytes of audio. The block
+// carries the title only when it changed since the last block; otherwise it is a single
+// zero byte, as Icecast does.
+type icyWriter struct {
+	w       io.Writer
+	metaint int
+	// remaining is the number of audio bytes before the next metadata block
+	remaining int
+	title     string
+	sent      string
+	sentOnce  bool
+}
+
+func newICYWriter(w io.Writer, metaint int) *icyWriter {
+	return &icyWriter{w: w, metaint: metaint, remaining: metaint}
+}
+
+// SetTitle sets the StreamTitle of the next metadata block
+func (w *icyWriter) SetTitle(title string) {
+	w.title = title
+}
+
+// Write writes audio, interleaving metadata blocks
+func (w *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.remaining)
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		w.remaining -= n
+		if w.remaining == 0 {
+			if _, err := w.w.Write(w.metadata()); err != nil {
+				return written, err
+			}
+			w.remaining = w.metaint
+		}
+	}
+	return written, nil
+}
+
+func (w *icyWriter) metadata() []byte {
+	if w.sentOnce && w.title == w.sent {
+		return []byte{0}
+	}
+	w.sent, w.sentOnce = w.title, true
+	return encodeMetadata(w.title)
+}
+
+// encodeMetadata renders StreamTitle as a metadata block: a length byte, in units of 16
+// bytes, followed by the NUL padded text
+func encodeMetadata(title string) []byte {
+	// 引用符はエスケープできないので、タイトル中の ' は置き換える
+	text := "StreamTitle='" + strings.ReplaceAll(title, "'", "’") + "';"
+	if len(text) > maxMetadataLength {
+		text = strings.ToValidUTF8(text[:maxMetadataLength-2], "") + "';"
+	}
+	blocks := (len(text) + 15) / 16
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], text)
+	return block
+}
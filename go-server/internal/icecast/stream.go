@@ -0,0 +1,374 @@
+package icecast
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+const (
+	// defaultLiveEdgeSegments is how far behind the last published segment listeners start.
+	// HLS players start three target durations from the end of the playlist (RFC 8216
+	// 6.3.3), so starting as many segments back keeps both kinds of listeners in sync.
+	defaultLiveEdgeSegments = 3
+	defaultListenerBuffer   = 8
+	segmentQueueSize        = 16
+	writeTimeout            = 30 * time.Second
+)
+
+// TrackLookup resolves content IDs to tracks for StreamTitle; hls.Catalog implements it
+type TrackLookup interface {
+	Get(id string) (hls.Track, error)
+}
+
+// Config configures a Stream
+type Config struct {
+	Station string
+	// Name, Description and Genre are sent as icy-name, icy-description and icy-genre;
+	// Name defaults to Station
+	Name        string
+	Description string
+	Genre       string
+	// Root is the contents root on disk served under URLPrefix; hls.DefaultContentsRoot
+	// and hls.DefaultContentsURLPrefix when empty
+	Root      string
+	URLPrefix string
+	// Filler is the TS segment the station publishes as filler, which is not under Root
+	Filler []byte
+	// Tracks resolves StreamTitle; nil sends content IDs
+	Tracks TrackLookup
+	// MetaInt is the icy-metaint announced to listeners; DefaultMetaInt when zero
+	MetaInt int
+	// LiveEdgeSegments is the number of published segments sent to new listeners at once;
+	// defaultLiveEdgeSegments when zero
+	LiveEdgeSegments int
+	// ListenerBuffer is the number of segments queued for a slow listener before it is
+	// disconnected; defaultListenerBuffer when zero
+	ListenerBuffer int
+	// MaxListeners rejects listeners beyond it with 503; zero is unlimited
+	MaxListeners int
+	Logger       *slog.Logger
+}
+
+// chunk is the audio of one published segment
+type chunk struct {
+	audio []byte
+	title string
+}
+
+type listener struct {
+	chunks  chan chunk
+	dropped bool
+}
+
+// Stream serves a station as an Icecast compatible progressive AAC stream. It follows the
+// segments the station publishes, demuxes each of them once and sends the ADTS audio to
+// every listener as the segment goes live, so listeners are paced by the live playlist.
+type Stream struct {
+	config Config
+	logger *slog.Logger
+	queue  chan hls.PublishedSegment
+
+	mu        sync.Mutex
+	recent    []chunk // the last LiveEdgeSegments chunks
+	listeners map[*listener]struct{}
+
+	connections atomic.Int64
+	dropped     atomic.Int64
+	rejected    atomic.Int64
+	bytesSent   atomic.Int64
+	segments    atomic.Int64
+	skipped     atomic.Int64
+	failures    atomic.Int64
+}
+
+func NewStream(config Config) *Stream {
+	if config.Name == "" {
+		config.Name = config.Station
+	}
+	if config.Root == "" {
+		config.Root = hls.DefaultContentsRoot
+	}
+	if config.URLPrefix == "" {
+		config.URLPrefix = hls.DefaultContentsURLPrefix
+	}
+	if config.MetaInt <= 0 {
+		config.MetaInt = DefaultMetaInt
+	}
+	if config.LiveEdgeSegments <= 0 {
+		config.LiveEdgeSegments = defaultLiveEdgeSegments
+	}
+	if config.ListenerBuffer <= 0 {
+		config.ListenerBuffer = defaultListenerBuffer
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	return &Stream{
+		config:    config,
+		logger:    config.Logger,
+		queue:     make(chan hls.PublishedSegment, segmentQueueSize),
+		listeners: make(map[*listener]struct{}),
+	}
+}
+
+// SegmentPublished implements hls.SegmentObserver. Fragmented MP4 segments are skipped:
+// only MPEG-TS can be demuxed to ADTS, so listeners hear nothing new until the next
+// MPEG-TS content or filler.
+func (s *Stream) SegmentPublished(seg hls.PublishedSegment) {
+	if seg.SourceInitURI != "" {
+		s.skipped.Add(1)
+		s.logger.Debug("skipping fmp4 segment for icecast", "station", seg.Station, "uri", seg.SourceURI)
+		return
+	}
+	select {
+	case s.queue <- seg:
+	default:
+		s.failures.Add(1)
+		s.logger.Warn("icecast queue is full, dropping segment", "station", seg.Station, "media_sequence", seg.MediaSequence)
+	}
+}
+
+// Run demuxes published segments and sends them to the listeners until ctx is done, then
+// disconnects every listener
+func (s *Stream) Run(ctx context.Context) {
+	defer s.closeListeners()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case seg := <-s.queue:
+			c, err := s.demux(seg)
+			if err != nil {
+				s.failures.Add(1)
+				s.logger.Error("failed to demux segment for icecast", "station", seg.Station, "uri", seg.SourceURI, "error", err)
+				continue
+			}
+			s.segments.Add(1)
+			s.broadcast(c)
+		}
+	}
+}
+
+func (s *Stream) demux(seg hls.PublishedSegment) (chunk, error) {
+	var ts []byte
+	if seg.Filler {
+		ts = s.config.Filler
+	} else {
+		file, ok := hls.ContentsPath(s.config.Root, s.config.URLPrefix, seg.SourceURI)
+		if !ok {
+			return chunk{}, fmt.Errorf("%s is not under %s", seg.SourceURI, s.config.URLPrefix)
+		}
+		var err error
+		if ts, err = os.ReadFile(file); err != nil {
+			return chunk{}, err
+		}
+	}
+	var audio bytes.Buffer
+	if _, err := mpegts.CopyADTS(&audio, bytes.NewReader(ts)); err != nil {
+		return chunk{}, err
+	}
+	return chunk{audio: audio.Bytes(), title: s.title(seg)}, nil
+}
+
+// title is the StreamTitle of seg: "Artist - Title" of its track, or the station name
+// while filler plays
+func (s *Stream) title(seg hls.PublishedSegment) string {
+	if seg.Filler {
+		return s.config.Name
+	}
+	if s.config.Tracks == nil {
+		return seg.ContentID
+	}
+	t, err := s.config.Tracks.Get(seg.ContentID)
+	if err != nil || t.Title == "" {
+		return seg.ContentID
+	}
+	if t.Artist == "" {
+		return t.Title
+	}
+	return t.Artist + " - " + t.Title
+}
+
+func (s *Stream) broadcast(c chunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append(s.recent, c)
+	if len(s.recent) > s.config.LiveEdgeSegments {
+		s.recent = s.recent[len(s.recent)-s.config.LiveEdgeSegments:]
+	}
+	for l := range s.listeners {
+		select {
+		case l.chunks <- c:
+		default:
+			// 追いつけないリスナーはライブから遅れ続けるので切る
+			l.dropped = true
+			s.dropped.Add(1)
+			delete(s.listeners, l)
+			close(l.chunks)
+		}
+	}
+}
+
+// subscribe adds a listener, primed with the segments up to the live edge
+func (s *Stream) subscribe() (*listener, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config.MaxListeners > 0 && len(s.listeners) >= s.config.MaxListeners {
+		return nil, false
+	}
+	l := &listener{chunks: make(chan chunk, s.config.ListenerBuffer+s.config.LiveEdgeSegments)}
+	for _, c := range s.recent {
+		l.chunks <- c
+	}
+	s.listeners[l] = struct{}{}
+	return l, true
+}
+
+func (s *Stream) unsubscribe(l *listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.listeners[l]; ok {
+		delete(s.listeners, l)
+		close(l.chunks)
+	}
+}
+
+func (s *Stream) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l := range s.listeners {
+		delete(s.listeners, l)
+		close(l.chunks)
+	}
+}
+
+// Handler serves the stream. Clients that send "Icy-MetaData: 1" get StreamTitle metadata
+// every icy-metaint bytes.
+func (s *Stream) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		l, ok := s.subscribe()
+		if !ok {
+			s.rejected.Add(1)
+			http.Error(w, "Too many listeners", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.unsubscribe(l)
+		s.connections.Add(1)
+
+		h := w.Header()
+		h.Set("Content-Type", "audio/aac")
+		h.Set("Cache-Control", "no-cache, no-store")
+		h.Set("icy-name", s.config.Name)
+		h.Set("icy-pub", "0")
+		if s.config.Description != "" {
+			h.Set("icy-description", s.config.Description)
+		}
+		if s.config.Genre != "" {
+			h.Set("icy-genre", s.config.Genre)
+		}
+		var icy *icyWriter
+		if r.Header.Get("Icy-MetaData") == "1" {
+			h.Set("icy-metaint", strconv.Itoa(s.config.MetaInt))
+			icy = newICYWriter(w, s.config.MetaInt)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		_ = rc.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case c, ok := <-l.chunks:
+				if !ok {
+					if l.dropped {
+						s.logger.Info("disconnected slow icecast listener", "station", s.config.Station, "remote_addr", r.RemoteAddr)
+					}
+					return
+				}
+				_ = rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+				var err error
+				if icy != nil {
+					icy.SetTitle(c.title)
+					_, err = icy.Write(c.audio)
+				} else {
+					_, err = w.Write(c.audio)
+				}
+				if err == nil {
+					err = rc.Flush()
+				}
+				if err != nil {
+					return
+				}
+				s.bytesSent.Add(int64(len(c.audio)))
+			}
+		}
+	})
+}
+
+// Stats is a point-in-time snapshot of a Stream
+type Stats struct {
+	Listeners int
+	// Connections counts every listener that connected
+	Connections int64
+	// Dropped counts listeners disconnected for falling behind; Rejected those over MaxListeners
+	Dropped  int64
+	Rejected int64
+	// BytesSent is the audio sent, without metadata
+	BytesSent int64
+	Segments  int64
+	// Skipped counts fragmented MP4 segments, which cannot be demuxed to ADTS
+	Skipped int64
+	// Failures counts segments that could not be queued or demuxed
+	Failures int64
+}
+
+func (s *Stream) Stats() Stats {
+	s.mu.Lock()
+	listeners := len(s.listeners)
+	s.mu.Unlock()
+	return Stats{
+		Listeners:   listeners,
+		Connections: s.connections.Load(),
+		Dropped:     s.dropped.Load(),
+		Rejected:    s.rejected.Load(),
+		BytesSent:   s.bytesSent.Load(),
+		Segments:    s.segments.Load(),
+		Skipped:     s.skipped.Load(),
+		Failures:    s.failures.Load(),
+	}
+}
+
+// Collect implements metrics.Collector
+func (s *Stream) Collect() []metrics.Metric {
+	stats := s.Stats()
+	station := []metrics.Label{{Name: "station", Value: s.config.Station}}
+	metric := func(name string, help string, typ metrics.MetricType, value float64) metrics.Metric {
+		return metrics.Metric{Name: name, Help: help, Type: typ, Samples: []metrics.Sample{{Labels: station, Value: value}}}
+	}
+	return []metrics.Metric{
+		metric("hlsradio_icecast_listeners", "Listeners connected to the icecast stream.", metrics.TypeGauge, float64(stats.Listeners)),
+		metric("hlsradio_icecast_connections_total", "Listeners that connected to the icecast stream.", metrics.TypeCounter, float64(stats.Connections)),
+		metric("hlsradio_icecast_listeners_dropped_total", "Icecast listeners disconnected for falling behind the live edge.", metrics.TypeCounter, float64(stats.Dropped)),
+		metric("hlsradio_icecast_bytes_sent_total", "Audio bytes sent to icecast listeners.", metrics.TypeCounter, float64(stats.BytesSent)),
+		metric("hlsradio_icecast_segments_skipped_total", "Fragmented MP4 segments the icecast stream skipped.", metrics.TypeCounter, float64(stats.Skipped)),
+		metric("hlsradio_icecast_segment_failures_total", "Published segments the icecast stream could not demux.", metrics.TypeCounter, float64(stats.Failures)),
+	}
+}
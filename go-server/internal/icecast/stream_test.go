@@ -0,0 +1,226 @@
+package icecast
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+func TestICYWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newICYWriter(&out, 4)
+	w.SetTitle("A")
+	if _, err := w.Write([]byte("abcdefghij")); err != nil {
+		t.Fatal(err)
+	}
+	w.SetTitle("B")
+	if _, err := w.Write([]byte("xy")); err != nil {
+		t.Fatal(err)
+	}
+
+	block := func(title string) string { return string(encodeMetadata(title)) }
+	// the title is repeated only when it changes
+	want := "abcd" + block("A") + "efgh" + "\x00" + "ij" + "xy" + block("B")
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"padded to 16 bytes", "Artist - Song", "StreamTitle='Artist - Song';"},
+		{"quotes replaced", "Don't Stop", "StreamTitle='Don’t Stop';"},
+		{"empty", "", "StreamTitle='';"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeMetadata(tt.title)
+			if int(got[0])*16 != len(got)-1 {
+				t.Errorf("length byte %d does not match %d bytes", got[0], len(got)-1)
+			}
+			if text := strings.TrimRight(string(got[1:]), "\x00"); text != tt.want {
+				t.Errorf("text = %q, want %q", text, tt.want)
+			}
+		})
+	}
+
+	long := encodeMetadata(strings.Repeat("あ", 2000))
+	if long[0] != 255 || !strings.HasSuffix(strings.TrimRight(string(long[1:]), "\x00"), "';") {
+		t.Errorf("long title: %d blocks, %q", long[0], long[len(long)-20:])
+	}
+}
+
+type fakeTracks map[string]hls.Track
+
+func (f fakeTracks) Get(id string) (hls.Track, error) {
+	t, ok := f[id]
+	if !ok {
+		return hls.Track{}, hls.ErrTrackNotFound
+	}
+	return t, nil
+}
+
+// readICY reads n bytes of audio from an ICY stream and returns the titles it carried
+func readICY(t *testing.T, r *bufio.Reader, metaint int, n int, offset *int) []string {
+	t.Helper()
+	var titles []string
+	for n > 0 {
+		audio := min(n, metaint-*offset)
+		if _, err := io.ReadFull(r, make([]byte, audio)); err != nil {
+			t.Fatalf("read audio: %v", err)
+		}
+		n -= audio
+		*offset += audio
+		if *offset < metaint {
+			continue
+		}
+		*offset = 0
+		length, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := make([]byte, int(length)*16)
+		if _, err := io.ReadFull(r, meta); err != nil {
+			t.Fatal(err)
+		}
+		if length > 0 {
+			text := strings.TrimRight(string(meta), "\x00")
+			titles = append(titles, strings.TrimSuffix(strings.TrimPrefix(text, "StreamTitle='"), "';"))
+		}
+	}
+	return titles
+}
+
+func TestStream(t *testing.T) {
+	root := t.TempDir()
+	ts, _, err := mpegts.GenerateSilence(mpegts.DefaultSilenceConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "music", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "music", "1", "0.ts"), ts, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var audio bytes.Buffer
+	if _, err := mpegts.CopyADTS(&audio, bytes.NewReader(ts)); err != nil {
+		t.Fatal(err)
+	}
+	chunkLen := audio.Len()
+
+	const metaint = 1000
+	s := NewStream(Config{
+		Station:          "proseka",
+		Name:             "Proseka Radio",
+		Root:             root,
+		URLPrefix:        "/contents",
+		Filler:           ts,
+		Tracks:           fakeTracks{"1": {ID: "1", Title: "Tell Your World", Artist: "livetune"}, "2": {ID: "2", Title: "Untitled"}},
+		MetaInt:          metaint,
+		LiveEdgeSegments: 2,
+		MaxListeners:     1,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	publish := func(segs ...hls.PublishedSegment) {
+		t.Helper()
+		want := s.Stats().Segments + s.Stats().Failures + int64(len(segs))
+		for _, seg := range segs {
+			s.SegmentPublished(seg)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for s.Stats().Segments+s.Stats().Failures < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("segments were not processed: %+v", s.Stats())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	content := func(id string) hls.PublishedSegment {
+		return hls.PublishedSegment{Station: "proseka", ContentID: id, SourceURI: "/contents/music/1/0.ts", URI: "https://cdn.example.com/contents/music/1/0.ts"}
+	}
+	// fMP4 segments are skipped without being counted as failures
+	s.SegmentPublished(hls.PublishedSegment{Station: "proseka", ContentID: "3", SourceURI: "/contents/music/3/0.m4s", SourceInitURI: "/contents/music/3/init.mp4"})
+	publish(content("2"), content("1"), hls.PublishedSegment{Station: "proseka", SourceURI: "/contents/missing.ts"}, content("1"))
+	if stats := s.Stats(); stats.Segments != 3 || stats.Failures != 1 || stats.Skipped != 1 {
+		t.Fatalf("Stats() = %+v, want 3 segments, 1 failure and 1 skipped", stats)
+	}
+
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Icy-MetaData", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "audio/aac" || resp.Header.Get("icy-metaint") != strconv.Itoa(metaint) || resp.Header.Get("icy-name") != "Proseka Radio" {
+		t.Fatalf("headers = %v", resp.Header)
+	}
+
+	// a new listener starts LiveEdgeSegments behind, like an HLS player
+	body := bufio.NewReader(resp.Body)
+	offset := 0
+	titles := readICY(t, body, metaint, 2*chunkLen, &offset)
+	if len(titles) != 1 || titles[0] != "livetune - Tell Your World" {
+		t.Errorf("titles of the live edge = %q", titles)
+	}
+
+	// then it follows the published segments
+	publish(hls.PublishedSegment{Station: "proseka", ContentID: "filler", Filler: true, URI: "/stations/proseka/silence.ts"}, content("2"))
+	titles = readICY(t, body, metaint, 2*chunkLen, &offset)
+	if strings.Join(titles, "|") != "Proseka Radio|Untitled" {
+		t.Errorf("titles = %q, want the station name for filler, then the next track", titles)
+	}
+
+	// MaxListeners
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("second listener = %d, want 503", rec.Code)
+	}
+	if stats := s.Stats(); stats.Listeners != 1 || stats.Rejected != 1 || stats.Connections != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestStream_DropsSlowListeners(t *testing.T) {
+	s := NewStream(Config{Station: "proseka", LiveEdgeSegments: 1, ListenerBuffer: 2})
+	l, _ := s.subscribe()
+	// room for the live edge and the buffer, then one more
+	for range 4 {
+		s.broadcast(chunk{audio: []byte{0}})
+	}
+	if _, ok := s.listeners[l]; ok || !l.dropped {
+		t.Fatal("listener with a full buffer was not dropped")
+	}
+	n := 0
+	for range l.chunks {
+		n++
+	}
+	if n != 3 || s.Stats().Dropped != 1 {
+		t.Errorf("received %d chunks before the disconnect, dropped = %d", n, s.Stats().Dropped)
+	}
+}
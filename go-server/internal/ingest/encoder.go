@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Encoder turns an audio file into an HLS media playlist
+type Encoder interface {
+	// Encode writes {outDir}/{name}.m3u8 and the .ts segments it references, using
+	// plain file names as segment URIs
+	Encode(ctx context.Context, input string, outDir string, name string) error
+}
+
+// FFmpegEncoder segments audio with a locally installed ffmpeg binary
+type FFmpegEncoder struct {
+	// Path is the ffmpeg binary; "ffmpeg" is looked up in PATH when empty
+	Path string
+	// SegmentDuration is the target segment length in seconds
+	SegmentDuration int
+	// Bitrate is the AAC bitrate passed to ffmpeg, e.g. "128k"
+	Bitrate string
+	// SampleRate is the output sample rate in Hz
+	SampleRate int
+}
+
+func DefaultFFmpegEncoder() FFmpegEncoder {
+	return FFmpegEncoder{
+		Path:            "ffmpeg",
+		SegmentDuration: 10,
+		Bitrate:         "128k",
+		SampleRate:      44100,
+	}
+}
+
+func (e FFmpegEncoder) args(input string, outDir string, name string) []string {
+	return []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error",
+		"-i", input,
+		"-vn",
+		"-c:a", "aac",
+		"-b:a", e.Bitrate,
+		"-ar", strconv.Itoa(e.SampleRate),
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(e.SegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, name+"_%04d.ts"),
+		filepath.Join(outDir, name+".m3u8"),
+	}
+}
+
+func (e FFmpegEncoder) Encode(ctx context.Context, input string, outDir string, name string) error {
+	path := e.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, path, e.args(input, outDir, name)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed on %s: %w: %s", input, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Package ingest adds new contents to the contents tree: it segments audio files
+// (or takes an already prepared HLS folder), places the result under
+// {root}/{type}/{id}/{id}.m3u8 and registers the track in a catalog.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+var (
+	// ErrContentExists is returned when the content directory for an ID already exists
+	ErrContentExists = errors.New("content already exists")
+	// ErrInvalidSource is returned when a prepared HLS folder or encoder output is unusable
+	ErrInvalidSource = errors.New("invalid source")
+)
+
+// Config configures an Ingester
+type Config struct {
+	// Root is the contents root; hls.DefaultContentsRoot when empty
+	Root string
+	// Type is the content type directory; "music" when empty
+	Type string
+	// Encoder segments audio files; prepared HLS folders are accepted without one
+	Encoder Encoder
+	// Catalog is consulted to assign IDs and reject duplicates (optional)
+	Catalog hls.Catalog
+	// Registrar adds the track to the catalog. When nil the track is only placed on
+	// disk with its sidecar, which is all a DirCatalog needs.
+	Registrar Registrar
+	Logger    *slog.Logger
+}
+
+// Request describes one content to ingest
+type Request struct {
+	// Input is an audio file, or a directory holding one .m3u8 and its .ts segments
+	Input string
+	// ID is assigned automatically when empty
+	ID     string
+	Title  string
+	Artist string
+	Tags   []string
+}
+
+type Ingester struct {
+	config Config
+}
+
+func New(config Config) *Ingester {
+	if config.Root == "" {
+		config.Root = hls.DefaultContentsRoot
+	}
+	if config.Type == "" {
+		config.Type = "music"
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	return &Ingester{config: config}
+}
+
+// Ingest places req.Input into the contents tree and registers it. Nothing is left
+// behind in the contents tree if any step fails.
+func (in *Ingester) Ingest(ctx context.Context, req Request) (hls.Track, error) {
+	info, err := os.Stat(req.Input)
+	if err != nil {
+		return hls.Track{}, fmt.Errorf("failed to stat input: %w", err)
+	}
+
+	typeDir := filepath.Join(in.config.Root, in.config.Type)
+	if err := os.MkdirAll(typeDir, 0755); err != nil {
+		return hls.Track{}, fmt.Errorf("failed to create %s: %w", typeDir, err)
+	}
+
+	id := req.ID
+	if id == "" {
+		id, err = in.nextID(typeDir)
+		if err != nil {
+			return hls.Track{}, err
+		}
+	} else if err := in.checkID(id); err != nil {
+		return hls.Track{}, err
+	}
+	dest := hls.ContentDir(in.config.Root, in.config.Type, id)
+	if _, err := os.Stat(dest); err == nil {
+		return hls.Track{}, fmt.Errorf("%w: %s", ErrContentExists, dest)
+	}
+
+	// 同じファイルシステム上で作業してから rename で配置する
+	staging, err := os.MkdirTemp(typeDir, ".ingest-"+id+"-")
+	if err != nil {
+		return hls.Track{}, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if info.IsDir() {
+		err = copyPrepared(req.Input, staging, id)
+	} else if in.config.Encoder == nil {
+		err = errors.New("no encoder is configured for audio input")
+	} else {
+		err = in.config.Encoder.Encode(ctx, req.Input, staging, id)
+	}
+	if err != nil {
+		return hls.Track{}, err
+	}
+
+	length, err := validateSegments(staging, id)
+	if err != nil {
+		return hls.Track{}, err
+	}
+
+	track := hls.Track{
+		ID:     id,
+		Title:  req.Title,
+		Length: int(math.Round(length)),
+		Artist: req.Artist,
+		M3U8:   id + ".m3u8",
+		Type:   in.config.Type,
+		Tags:   req.Tags,
+	}
+	if track.Title == "" {
+		track.Title = strings.TrimSuffix(filepath.Base(req.Input), filepath.Ext(req.Input))
+	}
+	if err := writeSidecar(filepath.Join(staging, id+".json"), track); err != nil {
+		return hls.Track{}, err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return hls.Track{}, err
+	}
+
+	if err := os.Rename(staging, dest); err != nil {
+		return hls.Track{}, fmt.Errorf("failed to move content into place: %w", err)
+	}
+	if in.config.Registrar != nil {
+		if err := in.config.Registrar.Register(ctx, track); err != nil {
+			_ = os.RemoveAll(dest)
+			return hls.Track{}, fmt.Errorf("failed to register %s: %w", id, err)
+		}
+	}
+
+	in.config.Logger.Info("ingested content", "content_id", id, "title", track.Title, "length", track.Length, "path", dest)
+	return track, nil
+}
+
+// checkID rejects IDs that the player cannot resolve or that are already taken
+func (in *Ingester) checkID(id string) error {
+	if strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("content id cannot be used as a directory name: %q", id)
+	}
+	if in.config.Catalog != nil {
+		if _, err := in.config.Catalog.Get(id); err == nil {
+			return fmt.Errorf("%w: %s", ErrDuplicateTrack, id)
+		}
+	}
+	return nil
+}
+
+// nextID returns one more than the largest numeric ID in the catalog and on disk;
+// slugs and UUIDs are ignored
+func (in *Ingester) nextID(typeDir string) (string, error) {
+	highest := 0
+	consider := func(id string) {
+		if n, err := strconv.Atoi(id); err == nil && n > highest {
+			highest = n
+		}
+	}
+	if in.config.Catalog != nil {
+		for _, t := range in.config.Catalog.List() {
+			consider(t.ID)
+		}
+	}
+	entries, err := os.ReadDir(typeDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to scan %s: %w", typeDir, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			consider(e.Name())
+		}
+	}
+	return strconv.Itoa(highest + 1), nil
+}
+
+// copyPrepared copies the single m3u8 in src as {dst}/{id}.m3u8 along with the segments it references
+func copyPrepared(src string, dst string, id string) error {
+	matches, err := filepath.Glob(filepath.Join(src, "*.m3u8"))
+	if err != nil {
+		return err
+	}
+	if len(matches) != 1 {
+		return fmt.Errorf("%w: %s must contain exactly one .m3u8, found %d", ErrInvalidSource, src, len(matches))
+	}
+
+	segments, err := hls.ReadMediaPlaylist(matches[0])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	files, err := segmentFiles(segments)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := copyFile(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSource, err)
+		}
+	}
+	return copyFile(matches[0], filepath.Join(dst, id+".m3u8"))
+}
+
+// validateSegments checks {dir}/{id}.m3u8 and returns its total duration
+func validateSegments(dir string, id string) (float64, error) {
+	path := filepath.Join(dir, id+".m3u8")
+	segments, err := hls.ReadMediaPlaylist(path)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	length, err := hls.MediaPlaylistDuration(segments)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	files, err := segmentFiles(segments)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range files {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return 0, fmt.Errorf("%w: missing segment %s", ErrInvalidSource, name)
+		}
+		if info.Size() == 0 {
+			return 0, fmt.Errorf("%w: empty segment %s", ErrInvalidSource, name)
+		}
+	}
+	return length, nil
+}
+
+// segmentFiles returns the files a media playlist references: its segments and the
+// EXT-X-MAP initialization sections of fragmented MP4, each once
+func segmentFiles(segments []hls.MediaSegment) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, seg := range segments {
+		for _, uri := range []string{seg.InitURI, seg.URI} {
+			if uri == "" || seen[uri] {
+				continue
+			}
+			if err := checkSegmentURI(uri); err != nil {
+				return nil, err
+			}
+			seen[uri] = true
+			files = append(files, uri)
+		}
+	}
+	return files, nil
+}
+
+// checkSegmentURI only allows plain file names, which DefaultContentFormatter resolves
+// relative to the content directory
+func checkSegmentURI(uri string) error {
+	if uri != filepath.Base(uri) || strings.Contains(uri, "/") || strings.Contains(uri, ":") || uri == ".." {
+		return fmt.Errorf("%w: segment uri %q is not a plain file name", ErrInvalidSource, uri)
+	}
+	return nil
+}
+
+func writeSidecar(path string, t hls.Track) error {
+	data, err := json.MarshalIndent(hls.Sidecar{Title: t.Title, Artist: t.Artist, Tags: t.Tags}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sidecar: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,297 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+// fakeEncoder writes a playlist of fixed-length segments instead of running ffmpeg
+type fakeEncoder struct {
+	durations []float64
+	err       error
+	inputs    []string
+}
+
+func (e *fakeEncoder) Encode(ctx context.Context, input string, outDir string, name string) error {
+	e.inputs = append(e.inputs, input)
+	if e.err != nil {
+		return e.err
+	}
+	return writePlaylist(outDir, name, e.durations)
+}
+
+func writePlaylist(dir string, name string, durations []float64) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n")
+	for i, d := range durations {
+		uri := fmt.Sprintf("%s_%04d.ts", name, i)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", d, uri)
+		if err := os.WriteFile(filepath.Join(dir, uri), []byte{0x47}, 0644); err != nil {
+			return err
+		}
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(filepath.Join(dir, name+".m3u8"), []byte(b.String()), 0644)
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func writeAudio(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIngestAudioFile(t *testing.T) {
+	root := t.TempDir()
+	catalog := hls.NewMemoryCatalog(hls.Track{ID: "100", Title: "existing"})
+	index := NewIndexFile(filepath.Join(root, "index.json"))
+	encoder := &fakeEncoder{durations: []float64{10, 10, 4.6}}
+
+	in := New(Config{Root: root, Encoder: encoder, Catalog: catalog, Registrar: index, Logger: discardLogger()})
+	track, err := in.Ingest(context.Background(), Request{
+		Input:  writeAudio(t, "Tell Your World.wav"),
+		Artist: "livetune",
+		Tags:   []string{"miku"},
+	})
+	if err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+
+	if track.ID != "101" || track.Title != "Tell Your World" || track.Length != 25 || track.M3U8 != "101.m3u8" || track.Type != "music" {
+		t.Errorf("Ingest() = %+v", track)
+	}
+
+	// DirCatalog が同じトラックとして読めること
+	dc, err := hls.NewDirCatalog(root, 0)
+	if err != nil {
+		t.Fatalf("NewDirCatalog() error = %v", err)
+	}
+	got, err := dc.Get("101")
+	if err != nil {
+		t.Fatalf("DirCatalog.Get() error = %v", err)
+	}
+	if got.Title != track.Title || got.Artist != "livetune" || got.Length != track.Length {
+		t.Errorf("DirCatalog.Get() = %+v, want %+v", got, track)
+	}
+
+	jc, err := hls.NewJSONCatalog(index.Path, 0)
+	if err != nil {
+		t.Fatalf("NewJSONCatalog() error = %v", err)
+	}
+	if _, err := jc.Get("101"); err != nil {
+		t.Errorf("index.json does not contain the ingested track: %v", err)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(root, "music", ".ingest-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("staging directories left behind: %v", leftovers)
+	}
+}
+
+func TestIngestPreparedFolder(t *testing.T) {
+	src := t.TempDir()
+	if err := writePlaylist(src, "master", []float64{10, 9.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	in := New(Config{Root: root, Logger: discardLogger()})
+	track, err := in.Ingest(context.Background(), Request{Input: src, ID: "42", Title: "Melt"})
+	if err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	if track.ID != "42" || track.Length != 20 {
+		t.Errorf("Ingest() = %+v", track)
+	}
+	for _, name := range []string{"42.m3u8", "42.json", "master_0000.ts", "master_0001.ts"} {
+		if _, err := os.Stat(filepath.Join(root, "music", "42", name)); err != nil {
+			t.Errorf("%s was not placed: %v", name, err)
+		}
+	}
+}
+
+func TestIngestPreparedFMP4(t *testing.T) {
+	src := t.TempDir()
+	m3u8 := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:10\n#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:10.0,\nseg_0.m4s\n#EXTINF:10.0,\nseg_1.m4s\n#EXT-X-ENDLIST\n"
+	for name, data := range map[string]string{"x.m3u8": m3u8, "init.mp4": "ftyp", "seg_0.m4s": "moof", "seg_1.m4s": "moof"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := t.TempDir()
+	in := New(Config{Root: root, Logger: discardLogger()})
+	if _, err := in.Ingest(context.Background(), Request{Input: src, ID: "42"}); err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	for _, name := range []string{"42.m3u8", "init.mp4", "seg_0.m4s", "seg_1.m4s"} {
+		if _, err := os.Stat(filepath.Join(root, "music", "42", name)); err != nil {
+			t.Errorf("%s was not placed: %v", name, err)
+		}
+	}
+}
+
+func TestIngestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, root string) (Config, Request)
+		wantErr error
+	}{
+		{
+			name: "encoder failure",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				return Config{Encoder: &fakeEncoder{err: errors.New("boom")}}, Request{Input: writeAudio(t, "a.wav")}
+			},
+		},
+		{
+			name: "encoder output without segments",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				return Config{Encoder: &fakeEncoder{}}, Request{Input: writeAudio(t, "a.wav")}
+			},
+			wantErr: ErrInvalidSource,
+		},
+		{
+			name: "prepared folder with missing segment",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				src := t.TempDir()
+				if err := writePlaylist(src, "x", []float64{10, 10}); err != nil {
+					t.Fatal(err)
+				}
+				os.Remove(filepath.Join(src, "x_0001.ts"))
+				return Config{}, Request{Input: src}
+			},
+			wantErr: ErrInvalidSource,
+		},
+		{
+			name: "prepared folder with absolute segment uri",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				src := t.TempDir()
+				m3u8 := "#EXTM3U\n#EXTINF:10.0,\n/etc/passwd.ts\n"
+				if err := os.WriteFile(filepath.Join(src, "x.m3u8"), []byte(m3u8), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return Config{}, Request{Input: src}
+			},
+			wantErr: ErrInvalidSource,
+		},
+		{
+			name: "prepared folder with missing init section",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				src := t.TempDir()
+				m3u8 := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:10.0,\nseg_0.m4s\n"
+				if err := os.WriteFile(filepath.Join(src, "x.m3u8"), []byte(m3u8), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(src, "seg_0.m4s"), []byte("moof"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return Config{}, Request{Input: src}
+			},
+			wantErr: ErrInvalidSource,
+		},
+		{
+			name: "prepared folder with init section outside of it",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				src := t.TempDir()
+				m3u8 := "#EXTM3U\n#EXT-X-MAP:URI=\"../init.mp4\"\n#EXTINF:10.0,\nseg_0.m4s\n"
+				if err := os.WriteFile(filepath.Join(src, "x.m3u8"), []byte(m3u8), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(src, "seg_0.m4s"), []byte("moof"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return Config{}, Request{Input: src}
+			},
+			wantErr: ErrInvalidSource,
+		},
+		{
+			name: "id already in catalog",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				catalog := hls.NewMemoryCatalog(hls.Track{ID: "7"})
+				return Config{Encoder: &fakeEncoder{durations: []float64{10}}, Catalog: catalog},
+					Request{Input: writeAudio(t, "a.wav"), ID: "7"}
+			},
+			wantErr: ErrDuplicateTrack,
+		},
+		{
+			name: "content directory already exists",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				if err := os.MkdirAll(filepath.Join(root, "music", "7"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				return Config{Encoder: &fakeEncoder{durations: []float64{10}}}, Request{Input: writeAudio(t, "a.wav"), ID: "7"}
+			},
+			wantErr: ErrContentExists,
+		},
+		{
+			name: "registration failure",
+			setup: func(t *testing.T, root string) (Config, Request) {
+				failing := RegistrarFunc(func(ctx context.Context, tr hls.Track) error { return errors.New("database is locked") })
+				return Config{Encoder: &fakeEncoder{durations: []float64{10}}, Registrar: failing},
+					Request{Input: writeAudio(t, "a.wav"), ID: "7"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			config, req := tt.setup(t, root)
+			config.Root = root
+			config.Logger = discardLogger()
+
+			_, err := New(config).Ingest(context.Background(), req)
+			if err == nil {
+				t.Fatal("Ingest() error = nil")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Ingest() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// 失敗時は新しいコンテンツも作業ディレクトリも残らない
+			entries, _ := os.ReadDir(filepath.Join(root, "music"))
+			for _, e := range entries {
+				if e.Name() != "7" || !errors.Is(tt.wantErr, ErrContentExists) {
+					t.Errorf("left behind %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestIndexFileRejectsDuplicates(t *testing.T) {
+	index := NewIndexFile(filepath.Join(t.TempDir(), "index.json"))
+	ctx := context.Background()
+	if err := index.Register(ctx, hls.Track{ID: "1", Title: "a"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := index.Register(ctx, hls.Track{ID: "1", Title: "b"}); !errors.Is(err, ErrDuplicateTrack) {
+		t.Errorf("Register() of duplicate error = %v, want ErrDuplicateTrack", err)
+	}
+}
+
+func TestFFmpegEncoderArgs(t *testing.T) {
+	args := DefaultFFmpegEncoder().args("in.flac", "/tmp/out", "42")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{"-i in.flac", "-c:a aac", "-hls_time 10", "-hls_segment_filename /tmp/out/42_%04d.ts", "/tmp/out/42.m3u8"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args %q do not contain %q", joined, want)
+		}
+	}
+}
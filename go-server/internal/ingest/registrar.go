@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+// ErrDuplicateTrack is returned when a track with the same ID is already registered
+var ErrDuplicateTrack = errors.New("track already registered")
+
+// Registrar adds an ingested track to a catalog
+type Registrar interface {
+	Register(ctx context.Context, t hls.Track) error
+}
+
+// RegistrarFunc adapts a function such as (*store.Store).PutTrack to Registrar
+type RegistrarFunc func(ctx context.Context, t hls.Track) error
+
+func (f RegistrarFunc) Register(ctx context.Context, t hls.Track) error {
+	return f(ctx, t)
+}
+
+// IndexFile registers tracks by appending them to an index.json read by hls.JSONCatalog.
+// The file is replaced atomically so that a polling catalog never sees a partial write.
+type IndexFile struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewIndexFile(path string) *IndexFile {
+	return &IndexFile{Path: path}
+}
+
+func (f *IndexFile) Register(ctx context.Context, t hls.Track) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var tracks []hls.Track
+	data, err := os.ReadFile(f.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read %s: %w", f.Path, err)
+	default:
+		if err := json.Unmarshal(data, &tracks); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", f.Path, err)
+		}
+	}
+
+	for _, existing := range tracks {
+		if existing.ID == t.ID {
+			return fmt.Errorf("%w: %s", ErrDuplicateTrack, t.ID)
+		}
+	}
+	tracks = append(tracks, t)
+
+	data, err = json.MarshalIndent(tracks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	return writeFileAtomic(f.Path, append(data, '\n'))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package listeners
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/auth"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+)
+
+const (
+	defaultWindow        = 60 * time.Second
+	defaultSweepInterval = 10 * time.Second
+
+	// CookieName is the cookie that keeps a listener's session ID across playlist requests
+	CookieName = "hlsradio_sid"
+	// QueryParam lets apps that manage their own sessions pass the session ID
+	QueryParam = "sid"
+
+	maxSessionIDLength = 64
+)
+
+// Session is one listener's continuous listening of a station
+type Session struct {
+	ID      string `json:"id"`
+	Station string `json:"station"`
+	// Subject is the authenticated listener, if any
+	Subject   string    `json:"subject,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Requests  int64     `json:"requests"`
+}
+
+// Duration is how long the listener listened: from the first to the last playlist request
+func (s Session) Duration() time.Duration {
+	return s.LastSeen.Sub(s.StartedAt)
+}
+
+// Sink stores ended sessions, e.g. for daily reports
+type Sink interface {
+	RecordSession(ctx context.Context, s Session) error
+}
+
+// Config configures a Tracker
+type Config struct {
+	// Window is how long a listener counts as active after its last playlist request;
+	// the session ends when it passes. defaultWindow when zero.
+	Window time.Duration
+	// SweepInterval is how often Run ends expired sessions; defaultSweepInterval when zero
+	SweepInterval time.Duration
+	// Sink receives ended sessions; nil keeps only the counters
+	Sink   Sink
+	Logger *slog.Logger
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time
+}
+
+type sessionKey struct {
+	station string
+	id      string
+}
+
+type stationCounters struct {
+	started   int64
+	ended     int64
+	listening time.Duration // of ended sessions
+}
+
+// Tracker follows listener sessions through their playlist requests. Players poll the
+// live playlist every few seconds, so a listener is active while it keeps polling.
+type Tracker struct {
+	config Config
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*Session
+	counters map[string]*stationCounters
+}
+
+func NewTracker(config Config) *Tracker {
+	if config.Window <= 0 {
+		config.Window = defaultWindow
+	}
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = defaultSweepInterval
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Tracker{
+		config:   config,
+		sessions: make(map[sessionKey]*Session),
+		counters: make(map[string]*stationCounters),
+	}
+}
+
+// station returns the counters of name; the caller must hold mu
+func (t *Tracker) station(name string) *stationCounters {
+	c, ok := t.counters[name]
+	if !ok {
+		c = &stationCounters{}
+		t.counters[name] = c
+	}
+	return c
+}
+
+// Touch records a playlist request of session id, starting the session if needed
+func (t *Tracker) Touch(station string, id string, subject string) {
+	now := t.config.Now()
+	var ended *Session
+
+	t.mu.Lock()
+	key := sessionKey{station, id}
+	s, ok := t.sessions[key]
+	if ok && now.Sub(s.LastSeen) > t.config.Window {
+		// 掃除前に戻ってきたリスナーは新しいセッションにする
+		ended = t.end(key, s)
+		ok = false
+	}
+	if !ok {
+		s = &Session{ID: id, Station: station, Subject: subject, StartedAt: now}
+		t.sessions[key] = s
+		t.station(station).started++
+	}
+	s.LastSeen = now
+	s.Requests++
+	if subject != "" {
+		s.Subject = subject
+	}
+	t.mu.Unlock()
+
+	if ended != nil {
+		t.record(context.Background(), *ended)
+	}
+}
+
+// end removes a session and counts it as ended; the caller must hold mu
+func (t *Tracker) end(key sessionKey, s *Session) *Session {
+	delete(t.sessions, key)
+	c := t.station(key.station)
+	c.ended++
+	c.listening += s.Duration()
+	return s
+}
+
+func (t *Tracker) record(ctx context.Context, s Session) {
+	if t.config.Sink == nil {
+		return
+	}
+	if err := t.config.Sink.RecordSession(ctx, s); err != nil {
+		t.config.Logger.Error("failed to record listener session", "station", s.Station, "session", s.ID, "error", err)
+	}
+}
+
+// Sweep ends the sessions that have been idle for longer than the window
+func (t *Tracker) Sweep(ctx context.Context) {
+	t.endWhere(ctx, func(s *Session, now time.Time) bool {
+		return now.Sub(s.LastSeen) > t.config.Window
+	})
+}
+
+func (t *Tracker) endWhere(ctx context.Context, expired func(*Session, time.Time) bool) {
+	now := t.config.Now()
+	var ended []Session
+	t.mu.Lock()
+	for key, s := range t.sessions {
+		if expired(s, now) {
+			ended = append(ended, *t.end(key, s))
+		}
+	}
+	t.mu.Unlock()
+
+	for _, s := range ended {
+		t.record(ctx, s)
+	}
+}
+
+// Run sweeps expired sessions until ctx is done, then ends all remaining sessions so
+// that they are recorded before shutdown
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			t.endWhere(context.WithoutCancel(ctx), func(*Session, time.Time) bool { return true })
+			return
+		case <-ticker.C:
+			t.Sweep(ctx)
+		}
+	}
+}
+
+// Middleware tracks the listeners of station through the requests to next. Listeners
+// are identified by the sid query parameter, the session cookie, or else a hash of
+// their address and User-Agent, which is then handed out as the cookie.
+func (t *Tracker) Middleware(station string, next http.Handler) http.Handler {
+	t.mu.Lock()
+	t.station(station)
+	t.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, known := identify(r)
+		if !known {
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieName,
+				Value:    id,
+				Path:     "/",
+				MaxAge:   int((24 * time.Hour).Seconds()),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		var subject string
+		if p, ok := auth.PrincipalFrom(r.Context()); ok {
+			subject = p.Subject
+		}
+		t.Touch(station, id, subject)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identify returns the session ID of a request and whether the client already holds it
+func identify(r *http.Request) (string, bool) {
+	if id := r.URL.Query().Get(QueryParam); validSessionID(id) {
+		return id, true
+	}
+	if c, err := r.Cookie(CookieName); err == nil && validSessionID(c.Value) {
+		return c.Value, true
+	}
+	sum := sha256.Sum256([]byte(clientIP(r) + "\n" + r.UserAgent()))
+	return hex.EncodeToString(sum[:8]), false
+}
+
+func validSessionID(id string) bool {
+	if id == "" || len(id) > maxSessionIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// clientIP prefers X-Real-IP, which nginx sets in front of us
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// StationStats is a point-in-time snapshot of the listeners of a station
+type StationStats struct {
+	Station string `json:"station"`
+	// Active is the number of sessions seen within the window
+	Active           int     `json:"active"`
+	SessionsStarted  int64   `json:"sessions_started"`
+	SessionsEnded    int64   `json:"sessions_ended"`
+	ListeningSeconds float64 `json:"listening_seconds"` // total of ended sessions
+}
+
+// Stats returns the stats of every station, sorted by name
+func (t *Tracker) Stats() []StationStats {
+	now := t.config.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byStation := make(map[string]*StationStats, len(t.counters))
+	for name, c := range t.counters {
+		byStation[name] = &StationStats{
+			Station:          name,
+			SessionsStarted:  c.started,
+			SessionsEnded:    c.ended,
+			ListeningSeconds: c.listening.Seconds(),
+		}
+	}
+	for key, s := range t.sessions {
+		if now.Sub(s.LastSeen) <= t.config.Window {
+			byStation[key.station].Active++
+		}
+	}
+
+	stats := make([]StationStats, 0, len(byStation))
+	for _, s := range byStation {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Station < stats[j].Station })
+	return stats
+}
+
+// StationStats returns the stats of one station
+func (t *Tracker) StationStats(station string) (StationStats, bool) {
+	for _, s := range t.Stats() {
+		if s.Station == station {
+			return s, true
+		}
+	}
+	return StationStats{}, false
+}
+
+// Handler serves GET /api/stations/{name}/listeners
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, ok := t.StationStats(r.PathValue("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := json.Marshal(struct {
+			StationStats
+			WindowSeconds float64 `json:"window_seconds"`
+		}{stats, t.config.Window.Seconds()})
+		if err != nil {
+			http.Error(w, "Failed to encode listeners", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(body)
+	})
+}
+
+// Collect implements metrics.Collector
+func (t *Tracker) Collect() []metrics.Metric {
+	active := metrics.Metric{Name: "hlsradio_listeners_active", Help: "Listeners that requested the playlist within the session window.", Type: metrics.TypeGauge}
+	sessions := metrics.Metric{Name: "hlsradio_listener_sessions_total", Help: "Listener sessions started.", Type: metrics.TypeCounter}
+	listening := metrics.Metric{Name: "hlsradio_listening_seconds_total", Help: "Listening time of ended sessions.", Type: metrics.TypeCounter}
+	for _, s := range t.Stats() {
+		station := []metrics.Label{{Name: "station", Value: s.Station}}
+		active.Samples = append(active.Samples, metrics.Sample{Labels: station, Value: float64(s.Active)})
+		sessions.Samples = append(sessions.Samples, metrics.Sample{Labels: station, Value: float64(s.SessionsStarted)})
+		listening.Samples = append(listening.Samples, metrics.Sample{Labels: station, Value: s.ListeningSeconds})
+	}
+	return []metrics.Metric{active, sessions, listening}
+}
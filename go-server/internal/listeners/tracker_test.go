@@ -0,0 +1,192 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/auth"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type memorySink struct {
+	mu       sync.Mutex
+	sessions []Session
+}
+
+func (m *memorySink) RecordSession(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = append(m.sessions, s)
+	return nil
+}
+
+func TestTracker_Sessions(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	sink := &memorySink{}
+	tracker := NewTracker(Config{Window: time.Minute, Sink: sink, Now: clock.Now})
+
+	tracker.Touch("proseka", "a", "")
+	tracker.Touch("proseka", "b", "")
+	clock.Advance(30 * time.Second)
+	tracker.Touch("proseka", "a", "user-1")
+	tracker.Touch("other", "a", "")
+
+	if stats, _ := tracker.StationStats("proseka"); stats.Active != 2 || stats.SessionsStarted != 2 {
+		t.Fatalf("proseka stats = %+v, want 2 active and 2 started", stats)
+	}
+
+	// b has been idle for 61s, a for 31s
+	clock.Advance(31 * time.Second)
+	if stats, _ := tracker.StationStats("proseka"); stats.Active != 1 {
+		t.Errorf("active after b went idle = %d, want 1", stats.Active)
+	}
+	tracker.Sweep(context.Background())
+	if len(sink.sessions) != 1 || sink.sessions[0].ID != "b" || sink.sessions[0].Duration() != 0 {
+		t.Fatalf("recorded sessions = %+v, want only b", sink.sessions)
+	}
+
+	// a comes back after the window without a sweep in between: a new session
+	clock.Advance(2 * time.Minute)
+	tracker.Touch("proseka", "a", "")
+	if len(sink.sessions) != 2 {
+		t.Fatalf("recorded sessions = %+v, want a's first session too", sink.sessions)
+	}
+	first := sink.sessions[1]
+	if first.ID != "a" || first.Subject != "user-1" || first.Requests != 2 || first.Duration() != 30*time.Second {
+		t.Errorf("a's first session = %+v", first)
+	}
+	stats, _ := tracker.StationStats("proseka")
+	if stats.SessionsStarted != 3 || stats.SessionsEnded != 2 || stats.ListeningSeconds != 30 || stats.Active != 1 {
+		t.Errorf("proseka stats = %+v", stats)
+	}
+
+	// Run ends every session when it stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracker.Run(ctx)
+	if len(sink.sessions) != 4 {
+		t.Errorf("sessions recorded on shutdown = %d, want 4 in total", len(sink.sessions))
+	}
+	if stats, _ := tracker.StationStats("proseka"); stats.Active != 0 {
+		t.Errorf("active after shutdown = %d", stats.Active)
+	}
+}
+
+func TestTracker_Middleware(t *testing.T) {
+	tracker := NewTracker(Config{})
+	mux := http.NewServeMux()
+	mux.Handle("/stream.m3u8", tracker.Middleware("proseka", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	mux.Handle("GET /api/stations/{name}/listeners", tracker.Handler())
+
+	request := func(target string, header http.Header, remote string, ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		req.RemoteAddr = remote
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	bg := context.Background()
+
+	// a new listener gets the hash of its address as a cookie, and keeps it
+	rec := request("/stream.m3u8", http.Header{"User-Agent": {"AppleCoreMedia"}}, "192.0.2.1:5000", bg)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName {
+		t.Fatalf("cookies = %v, want the session cookie", cookies)
+	}
+	rec = request("/stream.m3u8", http.Header{"Cookie": {CookieName + "=" + cookies[0].Value}}, "192.0.2.99:6000", bg)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("cookie set again for a known session")
+	}
+	// the same address and User-Agent without the cookie is the same listener
+	request("/stream.m3u8", http.Header{"User-Agent": {"AppleCoreMedia"}}, "192.0.2.1:5001", bg)
+	// behind nginx the address comes from X-Real-IP
+	request("/stream.m3u8", http.Header{"User-Agent": {"AppleCoreMedia"}, "X-Real-Ip": {"198.51.100.7"}}, "10.0.0.2:80", bg)
+	// apps can pass their own session IDs; invalid ones are ignored
+	request("/stream.m3u8?sid=app-session_1", nil, "192.0.2.2:5000", auth.WithPrincipal(bg, auth.Principal{Subject: "user-1"}))
+	request("/stream.m3u8?sid="+strings.Repeat("x", 65), nil, "192.0.2.3:5000", bg)
+
+	if got := len(tracker.sessions); got != 4 {
+		t.Errorf("sessions = %d, want 4", got)
+	}
+	if s := tracker.sessions[sessionKey{"proseka", "app-session_1"}]; s == nil || s.Subject != "user-1" {
+		t.Errorf("app session = %+v, want subject user-1", s)
+	}
+
+	rec = request("/api/stations/proseka/listeners", nil, "192.0.2.1:5000", bg)
+	var stats struct {
+		StationStats
+		WindowSeconds float64 `json:"window_seconds"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Station != "proseka" || stats.Active != 4 || stats.WindowSeconds != 60 {
+		t.Errorf("listeners API = %+v", stats)
+	}
+	if rec := request("/api/stations/unknown/listeners", nil, "192.0.2.1:5000", bg); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown station = %d, want 404", rec.Code)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1_700_000_000, 0).UTC()
+	for _, id := range []string{"a", "b"} {
+		if err := sink.RecordSession(context.Background(), Session{ID: id, Station: "proseka", StartedAt: start, LastSeen: start.Add(90 * time.Second), Requests: 18}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q, want 2", lines)
+	}
+	var got struct {
+		Session
+		DurationSeconds float64 `json:"duration_seconds"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "b" || got.DurationSeconds != 90 || !got.StartedAt.Equal(start) {
+		t.Errorf("second line = %+v", got)
+	}
+}
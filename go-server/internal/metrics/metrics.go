@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType is the TYPE of a metric family in the text exposition format
+type MetricType string
+
+const (
+	TypeCounter MetricType = "counter"
+	TypeGauge   MetricType = "gauge"
+)
+
+// Label is a single name/value pair attached to a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a metric family
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Metric is a metric family: a name, its help text, its type and its samples
+type Metric struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Samples []Sample
+}
+
+// Collector produces metrics on every scrape
+type Collector interface {
+	Collect() []Metric
+}
+
+// Registry gathers metrics from registered collectors
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather collects all metrics, merging families with the same name and sorting them by name
+func (r *Registry) Gather() []Metric {
+	r.mu.Lock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	families := make(map[string]*Metric)
+	var names []string
+	for _, c := range collectors {
+		for _, m := range c.Collect() {
+			family, ok := families[m.Name]
+			if !ok {
+				family = &Metric{Name: m.Name, Help: m.Help, Type: m.Type}
+				families[m.Name] = family
+				names = append(names, m.Name)
+			}
+			family.Samples = append(family.Samples, m.Samples...)
+		}
+	}
+
+	sort.Strings(names)
+	gathered := make([]Metric, 0, len(names))
+	for _, name := range names {
+		gathered = append(gathered, *families[name])
+	}
+	return gathered
+}
+
+// Handler serves the gathered metrics in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteText(w, r.Gather())
+	})
+}
+
+// WriteText writes metrics in the Prometheus text exposition format (version 0.0.4)
+func WriteText(w io.Writer, metrics []Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		if m.Help != "" {
+			bw.WriteString("# HELP " + m.Name + " " + escapeHelp(m.Help) + "\n")
+		}
+		if m.Type != "" {
+			bw.WriteString("# TYPE " + m.Name + " " + string(m.Type) + "\n")
+		}
+		for _, s := range m.Samples {
+			bw.WriteString(m.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteString("{")
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteString(",")
+					}
+					bw.WriteString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+				}
+				bw.WriteString("}")
+			}
+			bw.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
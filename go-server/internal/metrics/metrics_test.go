@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type staticCollector []Metric
+
+func (c staticCollector) Collect() []Metric {
+	return c
+}
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []Metric
+		want    string
+	}{
+		{
+			name: "gauge without labels",
+			metrics: []Metric{
+				{Name: "up", Help: "Whether the server is up.", Type: TypeGauge, Samples: []Sample{{Value: 1}}},
+			},
+			want: "# HELP up Whether the server is up.\n# TYPE up gauge\nup 1\n",
+		},
+		{
+			name: "counter with labels",
+			metrics: []Metric{
+				{Name: "requests_total", Type: TypeCounter, Samples: []Sample{
+					{Labels: []Label{{Name: "station", Value: "proseka"}, {Name: "code", Value: "200"}}, Value: 42},
+				}},
+			},
+			want: "# TYPE requests_total counter\nrequests_total{station=\"proseka\",code=\"200\"} 42\n",
+		},
+		{
+			name: "escaped help and label value",
+			metrics: []Metric{
+				{Name: "m", Help: "line\\one\nline two", Samples: []Sample{
+					{Labels: []Label{{Name: "title", Value: "say \"hi\"\n"}}, Value: 0.25},
+				}},
+			},
+			want: "# HELP m line\\\\one\\nline two\nm{title=\"say \\\"hi\\\"\\n\"} 0.25\n",
+		},
+		{
+			name: "special float values",
+			metrics: []Metric{
+				{Name: "m", Samples: []Sample{{Value: math.Inf(1)}, {Value: math.Inf(-1)}, {Value: math.NaN()}}},
+			},
+			want: "m +Inf\nm -Inf\nm NaN\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteText(&buf, tt.metrics); err != nil {
+				t.Fatalf("WriteText() error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("WriteText() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Gather(t *testing.T) {
+	r := NewRegistry()
+	r.Register(staticCollector{
+		{Name: "b_total", Type: TypeCounter, Samples: []Sample{{Labels: []Label{{Name: "station", Value: "one"}}, Value: 1}}},
+		{Name: "a", Type: TypeGauge, Samples: []Sample{{Value: 3}}},
+	})
+	r.Register(staticCollector{
+		{Name: "b_total", Type: TypeCounter, Samples: []Sample{{Labels: []Label{{Name: "station", Value: "two"}}, Value: 2}}},
+	})
+
+	got := r.Gather()
+	if len(got) != 2 {
+		t.Fatalf("Gather() returned %d families, want 2", len(got))
+	}
+	if got[0].Name != "a" || got[1].Name != "b_total" {
+		t.Errorf("Gather() families = %s, %s, want sorted by name", got[0].Name, got[1].Name)
+	}
+	if len(got[1].Samples) != 2 {
+		t.Errorf("merged samples = %d, want 2", len(got[1].Samples))
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Register(staticCollector{
+		{Name: "up", Type: TypeGauge, Samples: []Sample{{Value: 1}}},
+	})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want text exposition format", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "up 1\n") {
+		t.Errorf("body = %q, want it to contain the sample", rec.Body.String())
+	}
+}
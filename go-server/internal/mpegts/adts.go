@@ -0,0 +1,156 @@
+package mpegts
+
+import (
+	"fmt"
+)
+
+// SamplesPerFrame is the number of PCM samples carried by one AAC-LC frame
+const SamplesPerFrame = 1024
+
+const adtsHeaderSize = 7
+
+// AudioConfig describes the AAC stream carried in a segment
+type AudioConfig struct {
+	SampleRate int
+	Channels   int
+}
+
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+func sampleRateIndex(rate int) (int, error) {
+	for i, r := range sampleRates {
+		if r == rate {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported AAC sample rate: %d", rate)
+}
+
+// adtsHeader builds a 7 byte ADTS header (MPEG-4, AAC-LC, no CRC) for a raw frame of payloadLen bytes
+func adtsHeader(cfg AudioConfig, payloadLen int) ([]byte, error) {
+	srIndex, err := sampleRateIndex(cfg.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Channels < 1 || cfg.Channels > 7 {
+		return nil, fmt.Errorf("unsupported AAC channel count: %d", cfg.Channels)
+	}
+
+	const profileLC = 1 // audio object type 2 (LC) - 1
+	frameLen := adtsHeaderSize + payloadLen
+	const bufferFullness = 0x7FF // variable bitrate
+
+	return []byte{
+		0xFF,
+		0xF1, // syncword, MPEG-4, layer 0, protection absent
+		byte(profileLC<<6 | srIndex<<2 | (cfg.Channels>>2)&0x1),
+		byte((cfg.Channels&0x3)<<6 | (frameLen>>11)&0x3),
+		byte(frameLen >> 3),
+		byte((frameLen&0x7)<<5 | bufferFullness>>6),
+		byte((bufferFullness&0x3F)<<2 | 0), // one raw data block
+	}, nil
+}
+
+// silentRawFrame returns an AAC-LC raw_data_block that decodes to silence:
+// one element per channel pair with max_sfb = 0, so no spectral data is coded
+func silentRawFrame(channels int) ([]byte, error) {
+	const (
+		idSCE = 0
+		idCPE = 1
+		idEND = 7
+	)
+
+	var bw bitWriter
+	writeICS := func() {
+		bw.write(160, 8) // global_gain (unused without scalefactor bands)
+		// ics_info
+		bw.write(0, 1) // ics_reserved_bit
+		bw.write(0, 2) // window_sequence: ONLY_LONG_SEQUENCE
+		bw.write(1, 1) // window_shape: KBD
+		bw.write(0, 6) // max_sfb
+		bw.write(0, 1) // predictor_data_present
+		// no section, scalefactor or spectral data because max_sfb == 0
+		bw.write(0, 1) // pulse_data_present
+		bw.write(0, 1) // tns_data_present
+		bw.write(0, 1) // gain_control_data_present
+	}
+
+	switch channels {
+	case 1:
+		bw.write(idSCE, 3)
+		bw.write(0, 4) // element_instance_tag
+		writeICS()
+	case 2:
+		bw.write(idCPE, 3)
+		bw.write(0, 4) // element_instance_tag
+		bw.write(0, 1) // common_window
+		writeICS()
+		writeICS()
+	default:
+		return nil, fmt.Errorf("unsupported channel count for silence: %d", channels)
+	}
+	bw.write(idEND, 3)
+	return bw.bytes(), nil
+}
+
+// bitWriter packs values MSB first
+type bitWriter struct {
+	buf   []byte
+	nbits int
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 1 << uint(7-w.nbits%8)
+		}
+		w.nbits++
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+// ADTSFrame is one ADTS frame found in an elementary stream
+type ADTSFrame struct {
+	Config AudioConfig
+	// Samples is the number of PCM samples per channel the frame decodes to
+	Samples int
+	// Data is the whole frame including its header
+	Data []byte
+}
+
+// ParseADTS splits data into ADTS frames
+func ParseADTS(data []byte) ([]ADTSFrame, error) {
+	var frames []ADTSFrame
+	for len(data) > 0 {
+		if len(data) < adtsHeaderSize {
+			return frames, fmt.Errorf("truncated ADTS header: %d bytes left", len(data))
+		}
+		if data[0] != 0xFF || data[1]&0xF0 != 0xF0 {
+			return frames, fmt.Errorf("ADTS sync word not found: % x", data[:2])
+		}
+		srIndex := int(data[2] >> 2 & 0x0F)
+		if srIndex >= len(sampleRates) {
+			return frames, fmt.Errorf("invalid ADTS sample rate index: %d", srIndex)
+		}
+		channels := int(data[2]&0x1)<<2 | int(data[3]>>6)
+		frameLen := int(data[3]&0x3)<<11 | int(data[4])<<3 | int(data[5]>>5)
+		if frameLen < adtsHeaderSize || frameLen > len(data) {
+			return frames, fmt.Errorf("invalid ADTS frame length %d with %d bytes left", frameLen, len(data))
+		}
+		blocks := int(data[6]&0x3) + 1
+
+		frames = append(frames, ADTSFrame{
+			Config:  AudioConfig{SampleRate: sampleRates[srIndex], Channels: channels},
+			Samples: blocks * SamplesPerFrame,
+			Data:    data[:frameLen],
+		})
+		data = data[frameLen:]
+	}
+	return frames, nil
+}
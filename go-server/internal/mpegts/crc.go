@@ -0,0 +1,17 @@
+package mpegts
+
+// crc32MPEG2 computes the CRC used by PSI sections (poly 0x04C11DB7, no reflection, no final xor)
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
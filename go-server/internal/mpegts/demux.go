@@ -0,0 +1,312 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	// ErrSyncLost is returned when a packet does not start with the sync byte
+	ErrSyncLost = errors.New("sync byte lost")
+	// ErrTruncated is returned when the stream ends in the middle of a packet
+	ErrTruncated = errors.New("truncated packet")
+
+	ErrContinuity = errors.New("continuity counter error")
+	ErrCRC        = errors.New("section CRC mismatch")
+	ErrMalformed  = errors.New("malformed packet")
+)
+
+const (
+	pidNull    uint16 = 0x1FFF
+	ptsMask           = 1<<33 - 1
+	tableIDPAT        = 0x00
+	tableIDPMT        = 0x02
+)
+
+// PES is a reassembled packetized elementary stream packet
+type PES struct {
+	PID      uint16
+	StreamID byte
+	// PTS is the presentation timestamp in ClockRate units; valid only when HasPTS
+	PTS    uint64
+	HasPTS bool
+	// Data is the elementary stream payload, e.g. ADTS frames
+	Data []byte
+}
+
+// Demuxer reads a transport stream and reassembles the PES packets of the streams
+// declared in its PMT. Recoverable problems such as continuity errors are recorded
+// and reported by Problems instead of stopping the demuxer.
+type Demuxer struct {
+	r   io.Reader
+	pkt [PacketSize]byte
+
+	packets  int
+	pmtPID   uint16
+	havePAT  bool
+	havePMT  bool
+	streams  map[uint16]byte // elementary PID -> stream_type
+	cc       map[uint16]byte
+	sections map[uint16][]byte
+	pending  map[uint16][]byte
+	ready    []*PES
+	problems []error
+	eof      bool
+}
+
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r:        r,
+		streams:  make(map[uint16]byte),
+		cc:       make(map[uint16]byte),
+		sections: make(map[uint16][]byte),
+		pending:  make(map[uint16][]byte),
+	}
+}
+
+// Packets returns the number of packets read so far
+func (d *Demuxer) Packets() int {
+	return d.packets
+}
+
+// PMTPID returns the PID of the PMT announced by the PAT, and whether a PAT was seen
+func (d *Demuxer) PMTPID() (uint16, bool) {
+	return d.pmtPID, d.havePAT
+}
+
+// Streams returns the elementary stream PIDs and stream types from the PMT, or nil
+// before a PMT was seen
+func (d *Demuxer) Streams() map[uint16]byte {
+	if !d.havePMT {
+		return nil
+	}
+	streams := make(map[uint16]byte, len(d.streams))
+	for pid, st := range d.streams {
+		streams[pid] = st
+	}
+	return streams
+}
+
+// Problems returns the recoverable problems found so far
+func (d *Demuxer) Problems() []error {
+	return append([]error(nil), d.problems...)
+}
+
+func (d *Demuxer) problem(format string, args ...any) {
+	d.problems = append(d.problems, fmt.Errorf("packet %d: "+format, append([]any{d.packets - 1}, args...)...))
+}
+
+// NextPES returns the next complete PES packet, or io.EOF at the end of the stream
+func (d *Demuxer) NextPES() (*PES, error) {
+	for len(d.ready) == 0 {
+		if d.eof {
+			return nil, io.EOF
+		}
+		if err := d.readPacket(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			d.eof = true
+			d.flushAll()
+		}
+	}
+	pes := d.ready[0]
+	d.ready = d.ready[1:]
+	return pes, nil
+}
+
+func (d *Demuxer) readPacket() error {
+	n, err := io.ReadFull(d.r, d.pkt[:])
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %d trailing bytes after packet %d", ErrTruncated, n, d.packets)
+	}
+	if err != nil {
+		return err
+	}
+	d.packets++
+
+	b := d.pkt[:]
+	if b[0] != syncByte {
+		return fmt.Errorf("%w at packet %d (byte offset %d)", ErrSyncLost, d.packets-1, (d.packets-1)*PacketSize)
+	}
+	pusi := b[1]&0x40 != 0
+	pid := uint16(b[1]&0x1F)<<8 | uint16(b[2])
+	afc := b[3] >> 4 & 0x3
+	cc := b[3] & 0x0F
+	if pid == pidNull {
+		return nil
+	}
+
+	start := 4
+	discontinuity := false
+	if afc&0x2 != 0 {
+		afLen := int(b[4])
+		if 5+afLen > PacketSize {
+			d.problem("%w: adaptation field length %d on PID %#x", ErrMalformed, afLen, pid)
+			return nil
+		}
+		discontinuity = afLen > 0 && b[5]&0x80 != 0
+		start = 5 + afLen
+	}
+	if afc&0x1 == 0 {
+		return nil
+	}
+
+	last, seen := d.cc[pid]
+	d.cc[pid] = cc
+	if seen && !discontinuity {
+		switch cc {
+		case last:
+			// 重複パケットは一度だけ許され、内容は捨てる
+			return nil
+		case (last + 1) & 0x0F:
+		default:
+			d.problem("%w on PID %#x: expected %d, got %d", ErrContinuity, pid, (last+1)&0x0F, cc)
+		}
+	}
+
+	payload := b[start:]
+	switch {
+	case pid == PIDPAT || (d.havePAT && pid == d.pmtPID):
+		d.readSection(pid, pusi, payload)
+	case d.isElementary(pid):
+		if pusi {
+			d.flush(pid)
+			d.pending[pid] = append([]byte(nil), payload...)
+		} else if buf, ok := d.pending[pid]; ok {
+			d.pending[pid] = append(buf, payload...)
+		}
+		if buf := d.pending[pid]; len(buf) >= 6 {
+			// PES_packet_length が分かっていれば次の PUSI を待たずに完了させる
+			if n := int(binary.BigEndian.Uint16(buf[4:6])); n > 0 && len(buf) >= 6+n {
+				d.pending[pid] = buf[:6+n]
+				d.flush(pid)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Demuxer) isElementary(pid uint16) bool {
+	_, ok := d.streams[pid]
+	return ok
+}
+
+func (d *Demuxer) readSection(pid uint16, pusi bool, payload []byte) {
+	if pusi {
+		if len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+			d.problem("%w: pointer field out of range on PID %#x", ErrMalformed, pid)
+			return
+		}
+		d.sections[pid] = append([]byte(nil), payload[1+int(payload[0]):]...)
+	} else if buf, ok := d.sections[pid]; ok {
+		d.sections[pid] = append(buf, payload...)
+	} else {
+		return
+	}
+
+	buf := d.sections[pid]
+	if len(buf) < 3 {
+		return
+	}
+	sectionLen := int(buf[1]&0x0F)<<8 | int(buf[2])
+	if len(buf) < 3+sectionLen {
+		return
+	}
+	delete(d.sections, pid)
+	section := buf[:3+sectionLen]
+	if sectionLen < 9 {
+		d.problem("%w: section too short on PID %#x", ErrMalformed, pid)
+		return
+	}
+
+	body := section[:len(section)-4]
+	if crc32MPEG2(body) != binary.BigEndian.Uint32(section[len(section)-4:]) {
+		d.problem("%w on PID %#x", ErrCRC, pid)
+		return
+	}
+
+	switch section[0] {
+	case tableIDPAT:
+		for i := 8; i+4 <= len(body); i += 4 {
+			program := binary.BigEndian.Uint16(body[i:])
+			if program == 0 {
+				continue // network PID
+			}
+			d.pmtPID = binary.BigEndian.Uint16(body[i+2:]) & 0x1FFF
+			d.havePAT = true
+			break
+		}
+	case tableIDPMT:
+		if len(body) < 12 {
+			d.problem("%w: PMT too short", ErrMalformed)
+			return
+		}
+		i := 12 + int(binary.BigEndian.Uint16(body[10:])&0x0FFF)
+		for i+5 <= len(body) {
+			streamPID := binary.BigEndian.Uint16(body[i+1:]) & 0x1FFF
+			d.streams[streamPID] = body[i]
+			i += 5 + int(binary.BigEndian.Uint16(body[i+3:])&0x0FFF)
+		}
+		d.havePMT = true
+	}
+}
+
+// flush completes the pending PES of pid
+func (d *Demuxer) flush(pid uint16) {
+	buf, ok := d.pending[pid]
+	if !ok {
+		return
+	}
+	delete(d.pending, pid)
+
+	pes, err := parsePES(pid, buf)
+	if err != nil {
+		d.problem("%w", err)
+		return
+	}
+	d.ready = append(d.ready, pes)
+}
+
+func (d *Demuxer) flushAll() {
+	pids := make([]uint16, 0, len(d.pending))
+	for pid := range d.pending {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	for _, pid := range pids {
+		d.flush(pid)
+	}
+}
+
+func parsePES(pid uint16, buf []byte) (*PES, error) {
+	if len(buf) < 9 || buf[0] != 0 || buf[1] != 0 || buf[2] != 1 {
+		return nil, fmt.Errorf("%w: PES on PID %#x has no start code", ErrMalformed, pid)
+	}
+	pes := &PES{PID: pid, StreamID: buf[3]}
+	headerLen := int(buf[8])
+	if 9+headerLen > len(buf) {
+		return nil, fmt.Errorf("%w: PES header on PID %#x is truncated", ErrMalformed, pid)
+	}
+	if buf[7]&0x80 != 0 {
+		if headerLen < 5 {
+			return nil, fmt.Errorf("%w: PES on PID %#x has no room for its PTS", ErrMalformed, pid)
+		}
+		pes.PTS = decodeTimestamp(buf[9:14])
+		pes.HasPTS = true
+	}
+	pes.Data = buf[9+headerLen:]
+	return pes, nil
+}
+
+// decodeTimestamp is the inverse of encodeTimestamp
+func decodeTimestamp(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 |
+		uint64(b[1])<<22 |
+		uint64(b[2]>>1)<<15 |
+		uint64(b[3])<<7 |
+		uint64(b[4]>>1)
+}
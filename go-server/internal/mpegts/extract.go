@@ -0,0 +1,50 @@
+package mpegts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CopyADTS writes the ADTS frames of the first audio stream of a transport stream to w,
+// turning an HLS segment into a plain .aac stream. Segments copied one after another
+// concatenate into a playable file. It returns the number of PCM samples per channel
+// written; frames of a PES that fails to parse are skipped.
+func CopyADTS(w io.Writer, r io.Reader) (int, error) {
+	d := NewDemuxer(r)
+	var audioPID uint16
+	samples := 0
+	for {
+		pes, err := d.NextPES()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return samples, err
+		}
+		if audioPID == 0 {
+			audioPID = firstAudioPID(d.Streams())
+			if audioPID == 0 {
+				return samples, ErrNoAudio
+			}
+			if st := d.Streams()[audioPID]; st != StreamTypeADTS {
+				return samples, fmt.Errorf("%w: %s", ErrUnsupportedCodec, codecName(st))
+			}
+		}
+		if pes.PID != audioPID {
+			continue
+		}
+		// 壊れたPESは飛ばす。途中で切れた分はフレーム単位で書き出せる所まで書く
+		frames, _ := ParseADTS(pes.Data)
+		for _, f := range frames {
+			if _, err := w.Write(f.Data); err != nil {
+				return samples, err
+			}
+			samples += f.Samples
+		}
+	}
+	if audioPID == 0 {
+		return samples, ErrNoAudio
+	}
+	return samples, nil
+}
@@ -0,0 +1,189 @@
+package mpegts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	ErrNoPAT            = errors.New("no PAT")
+	ErrNoPMT            = errors.New("no PMT")
+	ErrNoAudio          = errors.New("no audio stream")
+	ErrUnsupportedCodec = errors.New("unsupported audio codec")
+	ErrNoPTS            = errors.New("no PTS")
+)
+
+// Stream is an elementary stream declared in the PMT
+type Stream struct {
+	PID   uint16
+	Type  byte
+	Codec string
+}
+
+// codecNames maps PMT stream types to the codec names used in reports
+var codecNames = map[byte]string{
+	0x03:           "mp3",
+	0x04:           "mp2",
+	StreamTypeADTS: "aac",
+	0x11:           "aac-latm",
+	0x1B:           "h264",
+	0x24:           "hevc",
+	0x81:           "ac3",
+}
+
+func codecName(streamType byte) string {
+	if name, ok := codecNames[streamType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%#x)", streamType)
+}
+
+func isAudio(streamType byte) bool {
+	switch streamType {
+	case 0x03, 0x04, StreamTypeADTS, 0x11, 0x81:
+		return true
+	}
+	return false
+}
+
+// Report summarizes a transport stream segment
+type Report struct {
+	Packets    int
+	PMTPID     uint16
+	Streams    []Stream
+	AudioPID   uint16
+	Codec      string
+	Audio      AudioConfig
+	PESPackets int
+	ADTSFrames int
+	// FirstPTS is the PTS of the first audio PES in ClockRate units
+	FirstPTS uint64
+	// Duration is the audio duration in seconds measured from the first PTS to the
+	// end of the last ADTS frame
+	Duration float64
+	// Problems lists everything that makes the segment unsafe to publish
+	Problems []error
+}
+
+// Err joins Problems into one error, or returns nil for a clean segment
+func (r *Report) Err() error {
+	return errors.Join(r.Problems...)
+}
+
+// Inspect reads a whole transport stream segment and checks sync bytes, PAT/PMT,
+// continuity counters and the audio codec, and measures the audio duration from PTS.
+// Only ADTS AAC audio, which the silence filler also uses, is accepted.
+// An error is returned only when r cannot be read as a sequence of TS packets.
+func Inspect(r io.Reader) (*Report, error) {
+	d := NewDemuxer(r)
+	report := &Report{}
+
+	type span struct{ start, end uint64 }
+	var spans []span
+	for {
+		pes, err := d.NextPES()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			report.Packets = d.Packets()
+			return report, err
+		}
+
+		if report.PESPackets == 0 {
+			report.AudioPID = firstAudioPID(d.Streams())
+		}
+		if pes.PID != report.AudioPID {
+			continue
+		}
+		report.PESPackets++
+		if !pes.HasPTS {
+			report.Problems = append(report.Problems, fmt.Errorf("%w in PES %d", ErrNoPTS, report.PESPackets-1))
+			continue
+		}
+		if len(spans) == 0 {
+			report.FirstPTS = pes.PTS
+		}
+
+		samples := 0
+		if d.streams[pes.PID] == StreamTypeADTS {
+			frames, err := ParseADTS(pes.Data)
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Errorf("PES %d: %w", report.PESPackets-1, err))
+			}
+			for _, f := range frames {
+				if report.ADTSFrames == 0 {
+					report.Audio = f.Config
+				} else if f.Config != report.Audio {
+					report.Problems = append(report.Problems, fmt.Errorf("audio config changed from %+v to %+v", report.Audio, f.Config))
+					report.Audio = f.Config
+				}
+				report.ADTSFrames++
+				samples += f.Samples
+			}
+		}
+
+		// 33bit の PTS の折り返しを考慮して先頭からの差分で扱う
+		start := (pes.PTS - report.FirstPTS) & ptsMask
+		end := start
+		if report.Audio.SampleRate > 0 {
+			end += uint64(samples) * ClockRate / uint64(report.Audio.SampleRate)
+		}
+		spans = append(spans, span{start, end})
+	}
+
+	report.Packets = d.Packets()
+	report.PMTPID, _ = d.PMTPID()
+	report.Problems = append(d.Problems(), report.Problems...)
+
+	if _, ok := d.PMTPID(); !ok {
+		report.Problems = append(report.Problems, ErrNoPAT)
+		return report, nil
+	}
+	streams := d.Streams()
+	if streams == nil {
+		report.Problems = append(report.Problems, ErrNoPMT)
+		return report, nil
+	}
+	for pid, st := range streams {
+		report.Streams = append(report.Streams, Stream{PID: pid, Type: st, Codec: codecName(st)})
+	}
+	sort.Slice(report.Streams, func(i, j int) bool { return report.Streams[i].PID < report.Streams[j].PID })
+
+	if report.AudioPID == 0 {
+		report.AudioPID = firstAudioPID(streams)
+	}
+	audioType, ok := streams[report.AudioPID]
+	if !ok {
+		report.Problems = append(report.Problems, ErrNoAudio)
+		return report, nil
+	}
+	report.Codec = codecName(audioType)
+	if audioType != StreamTypeADTS {
+		report.Problems = append(report.Problems, fmt.Errorf("%w: %s", ErrUnsupportedCodec, report.Codec))
+	}
+	if len(spans) == 0 {
+		report.Problems = append(report.Problems, fmt.Errorf("%w: no audio PES with a timestamp", ErrNoPTS))
+		return report, nil
+	}
+
+	var end uint64
+	for _, s := range spans {
+		end = max(end, s.end)
+	}
+	report.Duration = float64(end) / ClockRate
+	return report, nil
+}
+
+// firstAudioPID returns the lowest PID carrying audio, or 0 if there is none
+func firstAudioPID(streams map[uint16]byte) uint16 {
+	var found uint16
+	for pid, st := range streams {
+		if isAudio(st) && (found == 0 || pid < found) {
+			found = pid
+		}
+	}
+	return found
+}
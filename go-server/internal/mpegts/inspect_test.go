@@ -0,0 +1,174 @@
+package mpegts
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func silence(t *testing.T) ([]byte, float64) {
+	t.Helper()
+	data, duration, err := GenerateSilence(DefaultSilenceConfig())
+	if err != nil {
+		t.Fatalf("GenerateSilence() error = %v", err)
+	}
+	return data, duration
+}
+
+func TestInspect(t *testing.T) {
+	data, duration := silence(t)
+
+	report, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("Inspect() found problems in generated silence: %v", err)
+	}
+	if report.Codec != "aac" || report.AudioPID != PIDAudio || report.PMTPID != PIDPMT {
+		t.Errorf("Inspect() = codec %s, audio PID %#x, PMT PID %#x", report.Codec, report.AudioPID, report.PMTPID)
+	}
+	if report.Audio != (AudioConfig{SampleRate: 44100, Channels: 2}) {
+		t.Errorf("Audio = %+v", report.Audio)
+	}
+	if report.Packets != len(data)/PacketSize {
+		t.Errorf("Packets = %d, want %d", report.Packets, len(data)/PacketSize)
+	}
+	if math.Abs(report.Duration-duration) > 0.001 {
+		t.Errorf("Duration = %f, want %f", report.Duration, duration)
+	}
+}
+
+func TestInspectProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+		// wantErr is returned by Inspect itself; wantProblem is reported in Problems
+		wantErr     error
+		wantProblem error
+	}{
+		{
+			name: "lost sync byte",
+			corrupt: func(b []byte) []byte {
+				b[5*PacketSize] = 0x00
+				return b
+			},
+			wantErr: ErrSyncLost,
+		},
+		{
+			name: "truncated packet",
+			corrupt: func(b []byte) []byte {
+				return b[:len(b)-10]
+			},
+			wantErr: ErrTruncated,
+		},
+		{
+			name: "continuity counter jump",
+			corrupt: func(b []byte) []byte {
+				// 音声パケットを1つ抜く
+				i := 10 * PacketSize
+				return append(b[:i], b[i+PacketSize:]...)
+			},
+			wantProblem: ErrContinuity,
+		},
+		{
+			name: "PAT CRC mismatch",
+			corrupt: func(b []byte) []byte {
+				b[5+8] ^= 0xFF // PAT の program_number を壊す
+				return b
+			},
+			wantProblem: ErrNoPAT,
+		},
+		{
+			name: "missing PMT",
+			corrupt: func(b []byte) []byte {
+				return append(b[:PacketSize], b[2*PacketSize:]...)
+			},
+			wantProblem: ErrNoPMT,
+		},
+		{
+			name: "non AAC stream type",
+			corrupt: func(b []byte) []byte {
+				pmt := b[PacketSize : 2*PacketSize]
+				section := pmt[5 : 5+21]
+				section[12] = 0x03 // stream_type = MPEG-1 audio
+				crc := crc32MPEG2(section[:17])
+				pmt[5+17], pmt[5+18], pmt[5+19], pmt[5+20] = byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)
+				return b
+			},
+			wantProblem: ErrUnsupportedCodec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := silence(t)
+			report, err := Inspect(bytes.NewReader(tt.corrupt(data)))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Inspect() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if !errors.Is(report.Err(), tt.wantProblem) {
+				t.Errorf("Problems = %v, want %v", report.Problems, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestParseADTS(t *testing.T) {
+	frame, err := silentRawFrame(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := adtsHeader(AudioConfig{SampleRate: 48000, Channels: 2}, len(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := append(header, frame...)
+	data := append(append([]byte{}, one...), one...)
+
+	frames, err := ParseADTS(data)
+	if err != nil {
+		t.Fatalf("ParseADTS() error = %v", err)
+	}
+	if len(frames) != 2 || frames[0].Samples != SamplesPerFrame || frames[1].Config.SampleRate != 48000 {
+		t.Errorf("ParseADTS() = %+v", frames)
+	}
+
+	if _, err := ParseADTS(data[:len(data)-1]); err == nil {
+		t.Error("ParseADTS() should reject a truncated frame")
+	}
+}
+
+func TestCopyADTS(t *testing.T) {
+	segment, duration := silence(t)
+
+	var out bytes.Buffer
+	for range 2 {
+		samples, err := CopyADTS(&out, bytes.NewReader(segment))
+		if err != nil {
+			t.Fatalf("CopyADTS() error = %v", err)
+		}
+		if got := float64(samples) / 44100; got != duration {
+			t.Errorf("copied %v seconds, want %v", got, duration)
+		}
+	}
+	frames, err := ParseADTS(out.Bytes())
+	if err != nil {
+		t.Fatalf("output is not an ADTS stream: %v", err)
+	}
+	if want := 2 * 430; len(frames) != want {
+		t.Errorf("frames = %d, want %d", len(frames), want)
+	}
+
+	if _, err := CopyADTS(io.Discard, bytes.NewReader(segment[:PacketSize])); !errors.Is(err, ErrNoAudio) {
+		t.Errorf("CopyADTS() of a segment without audio PES = %v, want ErrNoAudio", err)
+	}
+}
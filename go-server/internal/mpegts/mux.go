@@ -0,0 +1,186 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	PacketSize = 188
+	syncByte   = 0x47
+
+	PIDPAT   uint16 = 0x0000
+	PIDPMT   uint16 = 0x1000
+	PIDAudio uint16 = 0x0101
+
+	// StreamTypeADTS is the PMT stream_type for AAC in ADTS framing
+	StreamTypeADTS = 0x0F
+	streamIDAudio  = 0xC0
+
+	programNumber = 1
+
+	// ClockRate is the frequency of PTS/DTS values
+	ClockRate = 90000
+)
+
+// Muxer writes a single-program MPEG-TS carrying one ADTS audio stream
+type Muxer struct {
+	w  io.Writer
+	cc map[uint16]byte
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		w:  w,
+		cc: make(map[uint16]byte),
+	}
+}
+
+// WriteTables writes the PAT and PMT; call it at the start of every segment
+func (m *Muxer) WriteTables() error {
+	pat := []byte{
+		0x00,       // table_id
+		0xB0, 0x0D, // section_syntax_indicator, section_length = 13
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0x00, programNumber,
+		0xE0 | byte(PIDPMT>>8), byte(PIDPMT & 0xFF),
+	}
+	if err := m.writeSection(PIDPAT, pat); err != nil {
+		return err
+	}
+
+	pmt := []byte{
+		0x02,       // table_id
+		0xB0, 0x12, // section_syntax_indicator, section_length = 18
+		0x00, programNumber,
+		0xC1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0xE0 | byte(PIDAudio>>8), byte(PIDAudio & 0xFF), // PCR_PID
+		0xF0, 0x00, // program_info_length
+		StreamTypeADTS,
+		0xE0 | byte(PIDAudio>>8), byte(PIDAudio & 0xFF),
+		0xF0, 0x00, // ES_info_length
+	}
+	return m.writeSection(PIDPMT, pmt)
+}
+
+func (m *Muxer) writeSection(pid uint16, section []byte) error {
+	payload := make([]byte, 0, len(section)+5)
+	payload = append(payload, 0x00) // pointer_field
+	payload = append(payload, section...)
+	payload = binary.BigEndian.AppendUint32(payload, crc32MPEG2(section))
+
+	pkt := m.header(pid, true, false)
+	pkt = append(pkt, payload...)
+	for len(pkt) < PacketSize {
+		pkt = append(pkt, 0xFF)
+	}
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+// WriteAudioPES writes data (one or more ADTS frames) as a PES packet with the given PTS.
+// The first TS packet carries a PCR so that the audio PID can serve as PCR_PID.
+func (m *Muxer) WriteAudioPES(pts uint64, data []byte) error {
+	pes := make([]byte, 0, 14+len(data))
+	pes = append(pes, 0x00, 0x00, 0x01, streamIDAudio)
+	pesLen := 3 + 5 + len(data)
+	if pesLen > 0xFFFF {
+		pesLen = 0 // unbounded
+	}
+	pes = binary.BigEndian.AppendUint16(pes, uint16(pesLen))
+	pes = append(pes, 0x80, 0x80, 0x05) // marker bits, PTS only, header length
+	pes = append(pes, encodeTimestamp(0x2, pts)...)
+	pes = append(pes, data...)
+
+	first := true
+	for len(pes) > 0 {
+		var af []byte
+		if first {
+			// adaptation field: random_access_indicator + PCR
+			af = append([]byte{0x07, 0x50}, encodePCR(pts)...)
+		}
+
+		space := PacketSize - 4 - len(af)
+		if len(pes) < space {
+			af = stuffAdaptationField(af, space-len(pes))
+		}
+
+		pkt := m.header(PIDAudio, first, len(af) > 0)
+		pkt = append(pkt, af...)
+		n := PacketSize - len(pkt)
+		pkt = append(pkt, pes[:n]...)
+		pes = pes[n:]
+
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+// header builds a 4 byte TS header and advances the PID's continuity counter
+func (m *Muxer) header(pid uint16, pusi bool, adaptation bool) []byte {
+	b1 := byte(pid>>8) & 0x1F
+	if pusi {
+		b1 |= 0x40
+	}
+	afc := byte(0x10) // payload only
+	if adaptation {
+		afc = 0x30 // adaptation field followed by payload
+	}
+	cc := m.cc[pid]
+	m.cc[pid] = (cc + 1) & 0x0F
+
+	pkt := make([]byte, 4, PacketSize)
+	pkt[0] = syncByte
+	pkt[1] = b1
+	pkt[2] = byte(pid)
+	pkt[3] = afc | cc
+	return pkt
+}
+
+// stuffAdaptationField grows af (possibly empty) by n bytes of stuffing
+func stuffAdaptationField(af []byte, n int) []byte {
+	if n <= 0 {
+		return af
+	}
+	if len(af) == 0 {
+		if n == 1 {
+			return []byte{0x00} // adaptation_field_length = 0
+		}
+		af = []byte{0x00, 0x00} // length, flags
+		n -= 2
+	}
+	for i := 0; i < n; i++ {
+		af = append(af, 0xFF)
+	}
+	af[0] = byte(len(af) - 1)
+	return af
+}
+
+// encodeTimestamp encodes a 33 bit PTS/DTS with the 4 bit prefix
+func encodeTimestamp(prefix byte, ts uint64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 1,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 1,
+	}
+}
+
+// encodePCR encodes a program clock reference whose base equals pts (extension 0)
+func encodePCR(base uint64) []byte {
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base&1)<<7 | 0x7E,
+		0x00,
+	}
+}
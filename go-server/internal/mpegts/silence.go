@@ -0,0 +1,63 @@
+package mpegts
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+const (
+	framesPerPES = 5
+	// initialPTS leaves room for players that expect a non-zero start time
+	initialPTS = ClockRate
+)
+
+// SilenceConfig defines the silent segment to generate
+type SilenceConfig struct {
+	Duration time.Duration // upper bound; the segment is a whole number of AAC frames
+	Audio    AudioConfig
+}
+
+func DefaultSilenceConfig() SilenceConfig {
+	return SilenceConfig{
+		Duration: 10 * time.Second,
+		Audio: AudioConfig{
+			SampleRate: 44100,
+			Channels:   2,
+		},
+	}
+}
+
+// GenerateSilence returns an MPEG-TS segment of AAC-LC silence and its exact duration in seconds
+func GenerateSilence(config SilenceConfig) ([]byte, float64, error) {
+	raw, err := silentRawFrame(config.Audio.Channels)
+	if err != nil {
+		return nil, 0, err
+	}
+	header, err := adtsHeader(config.Audio, len(raw))
+	if err != nil {
+		return nil, 0, err
+	}
+	frame := append(header, raw...)
+
+	frames := int(config.Duration.Seconds() * float64(config.Audio.SampleRate) / SamplesPerFrame)
+	if frames <= 0 {
+		return nil, 0, fmt.Errorf("silence duration %s is shorter than one AAC frame", config.Duration)
+	}
+
+	var buf bytes.Buffer
+	mux := NewMuxer(&buf)
+	if err := mux.WriteTables(); err != nil {
+		return nil, 0, err
+	}
+	for i := 0; i < frames; i += framesPerPES {
+		n := min(framesPerPES, frames-i)
+		pts := uint64(initialPTS) + uint64(i)*SamplesPerFrame*ClockRate/uint64(config.Audio.SampleRate)
+		if err := mux.WriteAudioPES(pts, bytes.Repeat(frame, n)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	duration := float64(frames*SamplesPerFrame) / float64(config.Audio.SampleRate)
+	return buf.Bytes(), duration, nil
+}
@@ -0,0 +1,104 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSilentRawFrame(t *testing.T) {
+	// 広く使われている無音のモノラルAAC-LCフレーム
+	want := []byte{0x01, 0x40, 0x20, 0x07}
+	got, err := silentRawFrame(1)
+	if err != nil {
+		t.Fatalf("silentRawFrame() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("silentRawFrame(1) = % x, want % x", got, want)
+	}
+
+	if _, err := silentRawFrame(6); err == nil {
+		t.Error("silentRawFrame(6) should fail")
+	}
+}
+
+func TestADTSHeader(t *testing.T) {
+	got, err := adtsHeader(AudioConfig{SampleRate: 44100, Channels: 2}, 7)
+	if err != nil {
+		t.Fatalf("adtsHeader() error = %v", err)
+	}
+	// LC, 44.1kHz (index 4), stereo, frame length 14
+	want := []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0xDF, 0xFC}
+	if !bytes.Equal(got, want) {
+		t.Errorf("adtsHeader() = % x, want % x", got, want)
+	}
+
+	if _, err := adtsHeader(AudioConfig{SampleRate: 44000, Channels: 2}, 7); err == nil {
+		t.Error("adtsHeader() should reject unsupported sample rates")
+	}
+}
+
+func TestGenerateSilence(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       SilenceConfig
+		wantDuration float64
+		wantErr      bool
+	}{
+		{
+			name:         "default stereo",
+			config:       DefaultSilenceConfig(),
+			wantDuration: 430 * 1024 / 44100.0,
+		},
+		{
+			name:         "mono 48kHz",
+			config:       SilenceConfig{Duration: 2 * time.Second, Audio: AudioConfig{SampleRate: 48000, Channels: 1}},
+			wantDuration: 93 * 1024 / 48000.0,
+		},
+		{
+			name:    "shorter than a frame",
+			config:  SilenceConfig{Duration: time.Millisecond, Audio: AudioConfig{SampleRate: 44100, Channels: 2}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, duration, err := GenerateSilence(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateSilence() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if duration != tt.wantDuration {
+				t.Errorf("duration = %v, want %v", duration, tt.wantDuration)
+			}
+			if duration > tt.config.Duration.Seconds() {
+				t.Errorf("duration %v exceeds requested %v", duration, tt.config.Duration)
+			}
+			if len(data)%PacketSize != 0 {
+				t.Fatalf("length %d is not a multiple of %d", len(data), PacketSize)
+			}
+
+			cc := map[uint16]int{}
+			for off := 0; off < len(data); off += PacketSize {
+				pkt := data[off : off+PacketSize]
+				if pkt[0] != syncByte {
+					t.Fatalf("packet at %d has sync byte %#x", off, pkt[0])
+				}
+				pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+				counter := int(pkt[3] & 0x0F)
+				if prev, ok := cc[pid]; ok && counter != (prev+1)%16 {
+					t.Errorf("pid %#x continuity counter jumped from %d to %d", pid, prev, counter)
+				}
+				cc[pid] = counter
+			}
+			for _, pid := range []uint16{PIDPAT, PIDPMT, PIDAudio} {
+				if _, ok := cc[pid]; !ok {
+					t.Errorf("pid %#x not present", pid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package podcast
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidConfig = errors.New("invalid podcast config")
+
+// Enclosure decides what the episodes of a feed point at
+type Enclosure string
+
+const (
+	// EnclosureAAC assembles the AAC audio of every episode from its TS segments into an
+	// .m4a file
+	EnclosureAAC Enclosure = "aac"
+)
+
+const defaultEpisodes = 50
+
+// Config is the podcast configuration file
+type Config struct {
+	// BaseURL is the public origin of the server, e.g. https://radio.example.com. Feeds
+	// need absolute URLs.
+	BaseURL  string                `json:"base_url"`
+	Stations map[string]FeedConfig `json:"stations"`
+}
+
+// FeedConfig is the podcast of one station
+type FeedConfig struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Author      string `json:"author,omitempty"`
+	// Email is published as the itunes:owner of the feed
+	Email    string `json:"email,omitempty"`
+	Language string `json:"language"` // e.g. "ja"
+	// Artwork is the URL of a square JPEG or PNG, 1400 to 3000 pixels
+	Artwork  string `json:"artwork"`
+	Category string `json:"category"` // an Apple Podcasts category, e.g. "Music"
+	Explicit bool   `json:"explicit"`
+	// Link is the website of the station; the server when empty
+	Link string `json:"link,omitempty"`
+	// Enclosure is EnclosureAAC when empty
+	Enclosure Enclosure `json:"enclosure,omitempty"`
+	// Episodes is the number of latest episodes in the feed; defaultEpisodes when zero
+	Episodes int    `json:"episodes,omitempty"`
+	Shows    []Show `json:"shows"`
+}
+
+// Show is a program aired on a weekly schedule; every airing is an episode
+type Show struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// Artwork overrides the artwork of the feed for the episodes of this show
+	Artwork string `json:"artwork,omitempty"`
+	// Days are the weekdays the show airs on ("mon" .. "sun"); every day when empty
+	Days []string `json:"days,omitempty"`
+	// Start is the local time the show starts, "HH:MM"
+	Start string `json:"start"`
+	// Duration is a Go duration such as "1h" or "90m"
+	Duration string `json:"duration"`
+	// Timezone is an IANA time zone name; UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+
+	days     [7]bool
+	hour     int
+	minute   int
+	duration time.Duration
+	location *time.Location
+}
+
+var showIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// LoadConfig reads and validates a Config from a JSON file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read podcast config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse podcast config %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// validate checks the config and fills in defaults and the parsed schedules
+func (c *Config) validate() error {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%w: base_url must be an absolute URL, got %q", ErrInvalidConfig, c.BaseURL)
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+
+	for name, feed := range c.Stations {
+		if feed.Title == "" || feed.Description == "" || feed.Language == "" || feed.Artwork == "" || feed.Category == "" {
+			return fmt.Errorf("%w: station %s needs a title, description, language, artwork and category", ErrInvalidConfig, name)
+		}
+		switch feed.Enclosure {
+		case "":
+			feed.Enclosure = EnclosureAAC
+		case EnclosureAAC:
+		case "hls":
+			return fmt.Errorf("%w: station %s: Apple Podcasts does not accept HLS enclosures", ErrInvalidConfig, name)
+		default:
+			return fmt.Errorf("%w: station %s: unknown enclosure %q", ErrInvalidConfig, name, feed.Enclosure)
+		}
+		if feed.Episodes <= 0 {
+			feed.Episodes = defaultEpisodes
+		}
+		seen := make(map[string]bool)
+		for i := range feed.Shows {
+			show := &feed.Shows[i]
+			if !showIDPattern.MatchString(show.ID) || seen[show.ID] {
+				return fmt.Errorf("%w: station %s: show IDs must be unique lowercase slugs, got %q", ErrInvalidConfig, name, show.ID)
+			}
+			seen[show.ID] = true
+			if err := show.parse(); err != nil {
+				return fmt.Errorf("%w: station %s show %s: %w", ErrInvalidConfig, name, show.ID, err)
+			}
+		}
+		c.Stations[name] = feed
+	}
+	return nil
+}
+
+func (s *Show) parse() error {
+	if s.Title == "" {
+		return errors.New("missing title")
+	}
+	if _, err := fmt.Sscanf(s.Start, "%d:%d", &s.hour, &s.minute); err != nil || s.hour < 0 || s.hour > 23 || s.minute < 0 || s.minute > 59 {
+		return fmt.Errorf("start must be HH:MM, got %q", s.Start)
+	}
+	d, err := time.ParseDuration(s.Duration)
+	if err != nil || d <= 0 || d > 24*time.Hour {
+		return fmt.Errorf("duration must be between 0 and 24h, got %q", s.Duration)
+	}
+	s.duration = d
+	if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+		return err
+	}
+
+	if len(s.Days) == 0 {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, day := range s.Days {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		s.days[wd] = true
+	}
+	return nil
+}
+
+// Airing is one broadcast of a show
+type Airing struct {
+	Show  *Show
+	Start time.Time
+	End   time.Time
+}
+
+// EpisodeID identifies the airing in feeds and file names, e.g. "night-20261001T1200Z"
+func (a Airing) EpisodeID() string {
+	return a.Show.ID + "-" + a.Start.UTC().Format("20060102T1504Z")
+}
+
+// airings returns the airings of show that ended in (from, to], newest first
+func (s *Show) airings(from time.Time, to time.Time) []Airing {
+	var airings []Airing
+	day := to.In(s.location)
+	// 日付をまたぐ番組のために1日余分に遡る
+	last := from.In(s.location).AddDate(0, 0, -1)
+	for y, m, d := day.Date(); ; d-- {
+		start := time.Date(y, m, d, s.hour, s.minute, 0, 0, s.location)
+		if start.Before(last) {
+			break
+		}
+		end := start.Add(s.duration)
+		if s.days[start.Weekday()] && end.After(from) && !end.After(to) {
+			airings = append(airings, Airing{Show: s, Start: start, End: end})
+		}
+	}
+	return airings
+}
@@ -0,0 +1,132 @@
+package podcast
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ITunesNamespace is the namespace of the Apple Podcasts tags
+const ITunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	ITunes  string     `xml:"xmlns:itunes,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	AtomLink    atomLink     `xml:"atom:link"`
+	Language    string       `xml:"language"`
+	Description string       `xml:"description"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Owner       *itunesOwner `xml:"itunes:owner"`
+	Image       itunesImage  `xml:"itunes:image"`
+	Category    itunesText   `xml:"itunes:category"`
+	Explicit    string       `xml:"itunes:explicit"`
+	Type        string       `xml:"itunes:type"`
+	LastBuild   string       `xml:"lastBuildDate,omitempty"`
+	Items       []rssItem    `xml:"item"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type itunesOwner struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesText struct {
+	Text string `xml:"text,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    int          `xml:"itunes:duration"`
+	EpisodeType string       `xml:"itunes:episodeType"`
+	Image       *itunesImage `xml:"itunes:image"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Feed renders the podcast RSS feed of station
+func (p *Publisher) Feed(ctx context.Context, station string) ([]byte, error) {
+	feed, ok := p.config.Stations[station]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownStation, station)
+	}
+	episodes, err := p.Episodes(ctx, station)
+	if err != nil {
+		return nil, err
+	}
+
+	base := p.config.BaseURL
+	channel := rssChannel{
+		Title:       feed.Title,
+		Link:        cmp.Or(feed.Link, base+"/"),
+		AtomLink:    atomLink{Href: base + "/stations/" + station + "/podcast.xml", Rel: "self", Type: "application/rss+xml"},
+		Language:    feed.Language,
+		Description: feed.Description,
+		Author:      feed.Author,
+		Image:       itunesImage{Href: feed.Artwork},
+		Category:    itunesText{Text: feed.Category},
+		Explicit:    strconv.FormatBool(feed.Explicit),
+		Type:        "episodic",
+		Items:       make([]rssItem, 0, len(episodes)),
+	}
+	if feed.Email != "" {
+		channel.Owner = &itunesOwner{Name: feed.Author, Email: feed.Email}
+	}
+	if len(episodes) > 0 {
+		channel.LastBuild = episodes[0].End.UTC().Format(time.RFC1123Z)
+	}
+	for _, e := range episodes {
+		item := rssItem{
+			Title:       e.Title,
+			Description: e.Description,
+			GUID:        rssGUID{Value: station + "/" + e.ID},
+			PubDate:     e.Start.UTC().Format(time.RFC1123Z),
+			Enclosure:   rssEnclosure{URL: base + e.URL, Length: e.Length, Type: e.Type},
+			Duration:    int(e.Duration + 0.5),
+			EpisodeType: "full",
+		}
+		if e.Artwork != "" {
+			item.Image = &itunesImage{Href: e.Artwork}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	body, err := xml.MarshalIndent(rss{Version: "2.0", ITunes: ITunesNamespace, Atom: "http://www.w3.org/2005/Atom", Channel: channel}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
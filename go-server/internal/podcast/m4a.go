@@ -0,0 +1,229 @@
+package podcast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// m4aContentType is the enclosure type of assembled episodes. Apple Podcasts only accepts
+// audio in MP3 or MPEG-4 files, not raw ADTS (audio/aac) or HLS playlists.
+const m4aContentType = "audio/x-m4a"
+
+const (
+	adtsHeaderSize    = 7
+	adtsCRCSize       = 2
+	aacFrameSamples   = 1024
+	m4aMaxSampleRate  = math.MaxUint16 // mp4a carries the sample rate as 16.16 fixed point
+	mpeg4AudioObject  = 0x40           // objectTypeIndication of MPEG-4 audio
+	audioStreamType   = 0x05
+	languageUndefined = 0x55C4 // "und" packed as ISO 639-2/T
+)
+
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacTrack is what the moov box needs to know of an ADTS stream
+type aacTrack struct {
+	objectType int // MPEG-4 audio object type, 2 for AAC-LC
+	rateIndex  int
+	channels   int
+	sizes      []uint32 // raw frame sizes, without their ADTS headers
+	total      int64    // sum of sizes
+}
+
+func (t *aacTrack) rate() int {
+	return adtsSampleRates[t.rateIndex]
+}
+
+// readADTS calls fn with the header and raw payload of every ADTS frame in r. The
+// payload is only valid during the call.
+func readADTS(r io.Reader, fn func(header []byte, payload []byte) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, adtsHeaderSize+adtsCRCSize)
+	var payload []byte
+	for {
+		if _, err := io.ReadFull(br, header[:adtsHeaderSize]); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated ADTS header: %w", err)
+		}
+		// syncword, then layer 0
+		if header[0] != 0xFF || header[1]&0xF6 != 0xF0 {
+			return fmt.Errorf("ADTS sync word not found: % x", header[:2])
+		}
+		size := adtsHeaderSize
+		if header[1]&0x01 == 0 { // protection_absent が 0 なら CRC が続く
+			size += adtsCRCSize
+			if _, err := io.ReadFull(br, header[adtsHeaderSize:size]); err != nil {
+				return fmt.Errorf("truncated ADTS header: %w", err)
+			}
+		}
+		frameLen := int(header[3]&0x3)<<11 | int(header[4])<<3 | int(header[5]>>5)
+		if frameLen <= size {
+			return fmt.Errorf("invalid ADTS frame length %d", frameLen)
+		}
+		if blocks := int(header[6]&0x3) + 1; blocks != 1 {
+			return fmt.Errorf("ADTS frames with %d raw data blocks are not supported", blocks)
+		}
+		if cap(payload) < frameLen-size {
+			payload = make([]byte, frameLen-size)
+		}
+		payload = payload[:frameLen-size]
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return fmt.Errorf("truncated ADTS frame: %w", err)
+		}
+		if err := fn(header[:size], payload); err != nil {
+			return err
+		}
+	}
+}
+
+// scanADTS reads the frame sizes and the audio configuration of an ADTS stream, which
+// must stay the same across frames
+func scanADTS(r io.Reader) (*aacTrack, error) {
+	var t *aacTrack
+	err := readADTS(r, func(header []byte, payload []byte) error {
+		objectType := int(header[2]>>6) + 1
+		rateIndex := int(header[2] >> 2 & 0x0F)
+		channels := int(header[2]&0x1)<<2 | int(header[3]>>6)
+		if t == nil {
+			if rateIndex >= len(adtsSampleRates) || adtsSampleRates[rateIndex] > m4aMaxSampleRate {
+				return fmt.Errorf("unsupported ADTS sample rate index %d", rateIndex)
+			}
+			if channels == 0 {
+				return errors.New("ADTS channel configurations in the bitstream are not supported")
+			}
+			t = &aacTrack{objectType: objectType, rateIndex: rateIndex, channels: channels}
+		} else if objectType != t.objectType || rateIndex != t.rateIndex || channels != t.channels {
+			return fmt.Errorf("ADTS frame %d changes the audio configuration", len(t.sizes))
+		}
+		t.sizes = append(t.sizes, uint32(len(payload)))
+		t.total += int64(len(payload))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, errors.New("no ADTS frames")
+	}
+	return t, nil
+}
+
+// writeM4A remuxes the ADTS stream in r into an MPEG-4 audio file. The moov box is
+// written before the media data, so that players can start before the download ends.
+// r is read from its start. It returns the number of PCM samples per channel and the
+// sample rate.
+func writeM4A(w io.Writer, r io.ReadSeeker) (samples int, rate int, err error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	t, err := scanADTS(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if 8+t.total > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("%d bytes of audio do not fit in an mdat box", t.total)
+	}
+	ftyp := mp4Box("ftyp", []byte("M4A "), u32(0), []byte("M4A "), []byte("mp42"), []byte("isom"))
+	// moov の大きさは stco の値によらないので、先に仮の値で組んで位置を求める
+	moov := t.moov(0)
+	moov = t.moov(uint32(len(ftyp) + len(moov) + 8))
+
+	for _, b := range [][]byte{ftyp, moov, u32(uint32(8 + t.total)), []byte("mdat")} {
+		if _, err := w.Write(b); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	err = readADTS(r, func(header []byte, payload []byte) error {
+		_, err := w.Write(payload)
+		return err
+	})
+	return len(t.sizes) * aacFrameSamples, t.rate(), err
+}
+
+// moov builds the moov box of a single audio track whose samples are one chunk at offset
+func (t *aacTrack) moov(offset uint32) []byte {
+	rate := uint32(t.rate())
+	duration := uint32(len(t.sizes) * aacFrameSamples)
+	matrix := concat(u32(0x00010000), u32(0), u32(0), u32(0), u32(0x00010000), u32(0), u32(0), u32(0), u32(0x40000000))
+
+	mvhd := mp4FullBox("mvhd", 0, 0, u32(0), u32(0), u32(rate), u32(duration),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix, make([]byte, 24), u32(2))
+	tkhd := mp4FullBox("tkhd", 0, 0x000003, // enabled, in movie
+		u32(0), u32(0), u32(1), u32(0), u32(duration), make([]byte, 8),
+		u16(0), u16(0), u16(0x0100), u16(0), matrix, u32(0), u32(0))
+	mdhd := mp4FullBox("mdhd", 0, 0, u32(0), u32(0), u32(rate), u32(duration), u16(languageUndefined), u16(0))
+	hdlr := mp4FullBox("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+
+	// AudioSpecificConfig: audioObjectType(5) samplingFrequencyIndex(4) channelConfiguration(4)
+	asc := u16(uint16(t.objectType<<11 | t.rateIndex<<7 | t.channels<<3))
+	maxSize := uint32(0)
+	for _, s := range t.sizes {
+		maxSize = max(maxSize, s)
+	}
+	bitrate := uint32(t.total * 8 * int64(rate) / int64(duration))
+	esds := mp4FullBox("esds", 0, 0, descriptor(0x03, // ES_Descriptor
+		u16(1), []byte{0},
+		descriptor(0x04, // DecoderConfigDescriptor
+			[]byte{mpeg4AudioObject, audioStreamType<<2 | 1}, u32(maxSize)[1:], u32(bitrate), u32(bitrate),
+			descriptor(0x05, asc)), // DecoderSpecificInfo
+		descriptor(0x06, []byte{0x02}))) // SLConfigDescriptor
+	mp4a := mp4Box("mp4a", make([]byte, 6), u16(1), make([]byte, 8),
+		u16(uint16(t.channels)), u16(16), u16(0), u16(0), u32(rate<<16), esds)
+
+	stsz := make([]byte, 0, 4*len(t.sizes))
+	for _, s := range t.sizes {
+		stsz = append(stsz, u32(s)...)
+	}
+	n := uint32(len(t.sizes))
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), mp4a),
+		mp4FullBox("stts", 0, 0, u32(1), u32(n), u32(aacFrameSamples)),
+		mp4FullBox("stsc", 0, 0, u32(1), u32(1), u32(n), u32(1)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(n), stsz),
+		mp4FullBox("stco", 0, 0, u32(1), u32(offset)))
+	minf := mp4Box("minf",
+		mp4FullBox("smhd", 0, 0, u16(0), u16(0)),
+		mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1))), // self-contained
+		stbl)
+	return mp4Box("moov", mvhd, mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf)))
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := concat(payload...)
+	return concat(u32(uint32(8+len(body))), []byte(typ), body)
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	return mp4Box(typ, append([][]byte{u32(uint32(version)<<24 | flags)}, payload...)...)
+}
+
+// descriptor builds an MPEG-4 descriptor; every descriptor here is shorter than 128 bytes,
+// so its size takes one byte
+func descriptor(tag byte, payload ...[]byte) []byte {
+	body := concat(payload...)
+	return concat([]byte{tag, byte(len(body))}, body)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
@@ -0,0 +1,525 @@
+package podcast
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+type fakeArchive []hls.PublishedSegment
+
+func (a fakeArchive) ArchiveSegments(ctx context.Context, station string, start time.Time, end time.Time) ([]hls.PublishedSegment, error) {
+	var segs []hls.PublishedSegment
+	for _, seg := range a {
+		if seg.Station == station && !seg.PublishedAt.Before(start) && seg.PublishedAt.Before(end) {
+			segs = append(segs, seg)
+		}
+	}
+	return segs, nil
+}
+
+// itunesFeed is the feed as Apple Podcasts reads it; the itunes tags only match in the
+// iTunes namespace
+type itunesFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Language    string `xml:"language"`
+		Description string `xml:"description"`
+		Image       struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Categories []struct {
+			Text string `xml:"text,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+		Explicit string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+		Owner    struct {
+			Email string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd email"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd owner"`
+		Items []struct {
+			Title string `xml:"title"`
+			GUID  string `xml:"guid"`
+			// PubDate must be RFC 2822
+			PubDate   string `xml:"pubDate"`
+			Enclosure *struct {
+				URL    string `xml:"url,attr"`
+				Length string `xml:"length,attr"`
+				Type   string `xml:"type,attr"`
+			} `xml:"enclosure"`
+			Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			EpisodeType string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// Apple Podcasts categories used in these tests; the full list is in the Apple Podcasts
+// category reference
+var itunesCategories = []string{"Arts", "Music", "News", "Technology"}
+
+// itunesEnclosureTypes are the enclosure types Apple Podcasts accepts
+var itunesEnclosureTypes = []string{"audio/x-m4a", "audio/mpeg", "video/quicktime", "video/mp4", "video/x-m4v", "application/pdf"}
+
+var itunesDuration = regexp.MustCompile(`^(\d+|\d{1,2}:\d{2}(:\d{2})?)$`)
+
+// validateITunes checks a feed against the tags Apple Podcasts requires and the formats
+// it accepts, and returns it parsed
+func validateITunes(t *testing.T, data []byte) itunesFeed {
+	t.Helper()
+	var feed itunesFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatalf("feed is not XML: %v\n%s", err, data)
+	}
+	if !bytes.Contains(data, []byte(`xmlns:itunes="`+ITunesNamespace+`"`)) {
+		t.Error("feed does not declare the iTunes namespace")
+	}
+	c := feed.Channel
+	if feed.Version != "2.0" {
+		t.Errorf("rss version = %q, want 2.0", feed.Version)
+	}
+	if c.Title == "" || c.Description == "" || c.Language == "" {
+		t.Errorf("channel title, description and language are required: %+v", c)
+	}
+	if u, err := url.Parse(c.Image.Href); err != nil || !u.IsAbs() || !slices.Contains([]string{".jpg", ".jpeg", ".png"}, filepath.Ext(u.Path)) {
+		t.Errorf("itunes:image = %q, want an absolute JPEG or PNG URL", c.Image.Href)
+	}
+	if len(c.Categories) == 0 || !slices.Contains(itunesCategories, c.Categories[0].Text) {
+		t.Errorf("itunes:category = %+v, want an Apple Podcasts category", c.Categories)
+	}
+	if c.Explicit != "true" && c.Explicit != "false" {
+		t.Errorf("itunes:explicit = %q, want true or false", c.Explicit)
+	}
+
+	guids := make(map[string]bool)
+	for _, item := range c.Items {
+		if item.Title == "" {
+			t.Error("item without a title")
+		}
+		if item.GUID == "" || guids[item.GUID] {
+			t.Errorf("item %s: guid %q is missing or not unique", item.Title, item.GUID)
+		}
+		guids[item.GUID] = true
+		if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+			t.Errorf("item %s: pubDate %q is not RFC 2822", item.Title, item.PubDate)
+		}
+		if e := item.Enclosure; e == nil {
+			t.Errorf("item %s: no enclosure", item.Title)
+		} else {
+			if u, err := url.Parse(e.URL); err != nil || !u.IsAbs() {
+				t.Errorf("item %s: enclosure url %q is not absolute", item.Title, e.URL)
+			}
+			if _, err := strconv.ParseInt(e.Length, 10, 64); err != nil {
+				t.Errorf("item %s: enclosure length %q is not a number of bytes", item.Title, e.Length)
+			}
+			if !slices.Contains(itunesEnclosureTypes, e.Type) {
+				t.Errorf("item %s: enclosure type %q is not accepted by Apple Podcasts", item.Title, e.Type)
+			}
+		}
+		if !itunesDuration.MatchString(item.Duration) {
+			t.Errorf("item %s: itunes:duration = %q", item.Title, item.Duration)
+		}
+		if !slices.Contains([]string{"full", "trailer", "bonus"}, item.EpisodeType) {
+			t.Errorf("item %s: itunes:episodeType = %q", item.Title, item.EpisodeType)
+		}
+	}
+	return feed
+}
+
+// checkM4A walks the boxes of an .m4a file, checks that the sample table points at the
+// media data and returns the number of samples per channel of its audio
+func checkM4A(t *testing.T, data []byte) int {
+	t.Helper()
+	type mp4Box struct {
+		typ      string
+		offset   int // of the payload in data
+		payload  []byte
+		children map[string]mp4Box
+	}
+	var parse func(data []byte, base int) ([]string, map[string]mp4Box)
+	parse = func(data []byte, base int) ([]string, map[string]mp4Box) {
+		var order []string
+		boxes := make(map[string]mp4Box)
+		for off := 0; off < len(data); {
+			if len(data)-off < 8 {
+				t.Fatalf("truncated box header at %d", base+off)
+			}
+			size := int(binary.BigEndian.Uint32(data[off:]))
+			typ := string(data[off+4 : off+8])
+			if size < 8 || off+size > len(data) {
+				t.Fatalf("box %q at %d has size %d", typ, base+off, size)
+			}
+			b := mp4Box{typ: typ, offset: base + off + 8, payload: data[off+8 : off+size]}
+			switch typ {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				_, b.children = parse(b.payload, b.offset)
+			}
+			order = append(order, typ)
+			boxes[typ] = b
+			off += size
+		}
+		return order, boxes
+	}
+	order, top := parse(data, 0)
+	if !slices.Equal(order, []string{"ftyp", "moov", "mdat"}) {
+		t.Fatalf("top-level boxes = %q, want ftyp, moov and mdat in this order", order)
+	}
+	if brand := string(top["ftyp"].payload[:4]); brand != "M4A " {
+		t.Errorf("major brand = %q", brand)
+	}
+	mdia := top["moov"].children["trak"].children["mdia"]
+	stbl := mdia.children["minf"].children["stbl"]
+	for _, typ := range []string{"stsd", "stts", "stsc", "stsz", "stco"} {
+		if _, ok := stbl.children[typ]; !ok {
+			t.Fatalf("stbl has no %s", typ)
+		}
+	}
+	if !bytes.Contains(stbl.children["stsd"].payload, []byte("mp4a")) || !bytes.Contains(stbl.children["stsd"].payload, []byte("esds")) {
+		t.Error("stsd does not describe mp4a audio")
+	}
+	if handler := string(mdia.children["hdlr"].payload[8:12]); handler != "soun" {
+		t.Errorf("handler = %q", handler)
+	}
+
+	stsz := stbl.children["stsz"].payload
+	count := int(binary.BigEndian.Uint32(stsz[8:]))
+	total := 0
+	for i := range count {
+		total += int(binary.BigEndian.Uint32(stsz[12+4*i:]))
+	}
+	if mdat := top["mdat"]; total != len(mdat.payload) {
+		t.Errorf("samples have %d bytes, mdat %d", total, len(mdat.payload))
+	}
+	if offset := int(binary.BigEndian.Uint32(stbl.children["stco"].payload[8:])); offset != top["mdat"].offset {
+		t.Errorf("chunk offset = %d, mdat data starts at %d", offset, top["mdat"].offset)
+	}
+	stts := stbl.children["stts"].payload
+	samples := int(binary.BigEndian.Uint32(stts[8:]) * binary.BigEndian.Uint32(stts[12:]))
+	if samples != count*1024 {
+		t.Errorf("stts describes %d samples for %d frames", samples, count)
+	}
+	if duration := int(binary.BigEndian.Uint32(mdia.children["mdhd"].payload[16:])); duration != samples {
+		t.Errorf("mdhd duration = %d, want %d", duration, samples)
+	}
+	return samples
+}
+
+func TestWriteM4A(t *testing.T) {
+	ts, _, err := mpegts.GenerateSilence(mpegts.DefaultSilenceConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var adts bytes.Buffer
+	n, err := mpegts.CopyADTS(&adts, bytes.NewReader(ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := mpegts.ParseADTS(adts.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m4a bytes.Buffer
+	samples, rate, err := writeM4A(&m4a, bytes.NewReader(adts.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples != n || rate != frames[0].Config.SampleRate {
+		t.Errorf("writeM4A() = %d samples at %d Hz, want %d at %d", samples, rate, n, frames[0].Config.SampleRate)
+	}
+	if got := checkM4A(t, m4a.Bytes()); got != n {
+		t.Errorf("m4a has %d samples, want %d", got, n)
+	}
+	// the media data is the raw frames without their ADTS headers
+	var raw []byte
+	for _, f := range frames {
+		raw = append(raw, f.Data[7:]...)
+	}
+	if !bytes.HasSuffix(m4a.Bytes(), raw) {
+		t.Error("mdat does not end with the raw AAC frames")
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": adts.Bytes()[:adts.Len()-1],
+		"not adts":  ts,
+	} {
+		if _, _, err := writeM4A(io.Discard, bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: writeM4A() succeeded", name)
+		}
+	}
+}
+
+func testConfig(enclosure Enclosure, episodes int) Config {
+	return Config{
+		BaseURL: "https://radio.example.com/",
+		Stations: map[string]FeedConfig{
+			"proseka": {
+				Title:       "Proseka Radio",
+				Description: "Replays of our shows",
+				Author:      "Proseka Radio",
+				Email:       "radio@example.com",
+				Language:    "ja",
+				Artwork:     "https://radio.example.com/artwork.png",
+				Category:    "Music",
+				Enclosure:   enclosure,
+				Episodes:    episodes,
+				Shows: []Show{
+					{ID: "morning", Title: "Morning Mix", Days: []string{"thu", "fri"}, Start: "09:00", Duration: "1h"},
+					{ID: "night", Title: "Night Talk", Description: "Late talk", Start: "21:00", Duration: "30m", Timezone: "Asia/Tokyo"},
+				},
+			},
+		},
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	root := t.TempDir()
+	ts, duration, err := mpegts.GenerateSilence(mpegts.DefaultSilenceConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "music", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"0.ts", "1.ts"} {
+		if err := os.WriteFile(filepath.Join(root, "music", "1", name), ts, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	seg := func(published string, uri string) hls.PublishedSegment {
+		return hls.PublishedSegment{Station: "proseka", SourceURI: uri, URI: uri + "?token=x", Duration: duration, PublishedAt: at(published)}
+	}
+	archive := fakeArchive{
+		// Wednesday: not a morning show day
+		seg("2026-09-30T09:10:00Z", "/contents/music/1/0.ts"),
+		seg("2026-10-01T09:00:00Z", "/contents/music/1/0.ts"),
+		seg("2026-10-01T09:00:10Z", "/stations/proseka/silence.ts"),
+		seg("2026-10-01T09:00:12Z", "/contents/music/1/1.ts"),
+		// 21:05 in Tokyo
+		seg("2026-10-01T12:05:00Z", "/contents/music/1/0.ts"),
+		seg("2026-10-02T09:30:00Z", "/contents/music/1/1.ts"),
+		// tonight's night show is still on air
+		seg("2026-10-02T12:00:00Z", "/contents/music/1/0.ts"),
+	}
+	now := func() time.Time { return at("2026-10-02T12:10:00Z") }
+	wantIDs := []string{"morning-20261002T0900Z", "night-20261001T1200Z", "morning-20261001T0900Z"}
+
+	t.Run("aac", func(t *testing.T) {
+		dir := t.TempDir()
+		newPublisher := func(episodes int) *Publisher {
+			p, err := NewPublisher(testConfig(EnclosureAAC, episodes), PublisherConfig{
+				Archive: archive, Dir: dir, Root: root, URLPrefix: "/contents",
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Now: now,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}
+		p := newPublisher(0)
+		if err := p.Build(context.Background()); err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		episodes, err := p.Episodes(context.Background(), "proseka")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, e := range episodes {
+			ids = append(ids, e.ID)
+		}
+		if !slices.Equal(ids, wantIDs) {
+			t.Fatalf("episodes = %q, want %q", ids, wantIDs)
+		}
+		// the filler is left out
+		if e := episodes[2]; e.Duration != 2*duration || e.Title != "Morning Mix 2026-10-01" {
+			t.Errorf("episode = %+v, want two segments of audio", e)
+		}
+		if e := episodes[1]; e.Title != "Night Talk 2026-10-01" || e.Description != "Late talk" {
+			t.Errorf("night episode = %+v, titled by its Tokyo date", e)
+		}
+
+		m4a, err := os.ReadFile(filepath.Join(dir, "proseka", wantIDs[2]+".m4a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(m4a)) != episodes[2].Length {
+			t.Errorf("episode length = %d, file has %d bytes", episodes[2].Length, len(m4a))
+		}
+		if samples := checkM4A(t, m4a); samples != 2*430*1024 {
+			t.Errorf("episode audio has %d samples, want two segments", samples)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("GET /stations/{name}/podcast.xml", p.FeedHandler())
+		mux.Handle("GET /stations/{name}/episodes/{file}", p.EpisodeHandler())
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations/proseka/podcast.xml", nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+			t.Fatalf("feed = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		feed := validateITunes(t, rec.Body.Bytes())
+		if len(feed.Channel.Items) != 3 || feed.Channel.Owner.Email != "radio@example.com" {
+			t.Fatalf("feed = %+v", feed.Channel)
+		}
+		enclosure := feed.Channel.Items[2].Enclosure
+		if enclosure.URL != "https://radio.example.com/stations/proseka/episodes/"+wantIDs[2]+".m4a" ||
+			enclosure.Type != "audio/x-m4a" || enclosure.Length != strconv.Itoa(len(m4a)) {
+			t.Errorf("enclosure = %+v", enclosure)
+		}
+
+		// podcast apps seek with range requests
+		req := httptest.NewRequest(http.MethodGet, "/stations/proseka/episodes/"+wantIDs[2]+".m4a", nil)
+		req.Header.Set("Range", "bytes=0-99")
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPartialContent || rec.Body.Len() != 100 || !bytes.Equal(rec.Body.Bytes(), m4a[:100]) ||
+			rec.Header().Get("Content-Type") != "audio/x-m4a" {
+			t.Errorf("range request = %d with %d bytes", rec.Code, rec.Body.Len())
+		}
+		for _, target := range []string{
+			"/stations/proseka/episodes/" + wantIDs[2] + ".json",
+			"/stations/proseka/episodes/" + wantIDs[2] + ".aac",
+			"/stations/proseka/episodes/morning-20260101T0900Z.m4a",
+			"/stations/other/episodes/" + wantIDs[2] + ".m4a",
+			"/stations/other/podcast.xml",
+		} {
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s = %d, want 404", target, rec.Code)
+			}
+		}
+
+		// shared caches must not keep what only members may hear
+		for _, private := range []bool{false, true} {
+			p, err := NewPublisher(testConfig(EnclosureAAC, 0), PublisherConfig{
+				Archive: archive, Dir: dir, Root: root, URLPrefix: "/contents",
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Now: now,
+				Private: func(station string) bool { return private && station == "proseka" },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "public"
+			if private {
+				want = "private"
+			}
+			mux := http.NewServeMux()
+			mux.Handle("GET /stations/{name}/podcast.xml", p.FeedHandler())
+			mux.Handle("GET /stations/{name}/episodes/{file}", p.EpisodeHandler())
+			for _, target := range []string{"/stations/proseka/podcast.xml", "/stations/proseka/episodes/" + wantIDs[2] + ".m4a"} {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+				if cc := rec.Header().Get("Cache-Control"); rec.Code != http.StatusOK || !strings.HasPrefix(cc, want+",") {
+					t.Errorf("private = %t: %s = %d with Cache-Control %q", private, target, rec.Code, cc)
+				}
+			}
+		}
+
+		// episodes that fall out of the feed are deleted
+		if err := newPublisher(2).Build(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "proseka", wantIDs[2]+".m4a")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("oldest episode was not deleted: %v", err)
+		}
+	})
+
+	t.Run("escaped paths", func(t *testing.T) {
+		// 日本語や空白を含むフォルダのセグメントは URI がパーセントエンコードされている
+		dir := filepath.Join("music", "プロセカ 曲")
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "0.ts"), ts, 0644); err != nil {
+			t.Fatal(err)
+		}
+		uri := "/contents/" + (&url.URL{Path: "music/プロセカ 曲/0.ts"}).EscapedPath()
+		if !strings.Contains(uri, "%20") {
+			t.Fatalf("uri %q is not escaped", uri)
+		}
+		p, err := NewPublisher(testConfig(EnclosureAAC, 0), PublisherConfig{
+			Archive: fakeArchive{seg("2026-10-01T09:00:00Z", uri), seg("2026-10-01T09:00:10Z", uri)},
+			Dir:     t.TempDir(), Root: root, URLPrefix: "/contents",
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Now: now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Build(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		episodes, err := p.Episodes(context.Background(), "proseka")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(episodes) != 1 || episodes[0].Duration != 2*duration {
+			t.Errorf("episodes = %+v, want one episode with both segments", episodes)
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"valid", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "https://radio.example.com/a.png", "category": "Music",
+			"shows": [{"id": "night", "title": "Night", "start": "21:00", "duration": "1h", "days": ["Mon"], "timezone": "Asia/Tokyo"}]}}}`, false},
+		{"relative base url", `{"base_url": "/radio", "stations": {}}`, true},
+		{"missing artwork", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "category": "Music"}}}`, true},
+		{"hls enclosure", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "a", "category": "Music", "enclosure": "hls"}}}`, true},
+		{"unknown enclosure", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "a", "category": "Music", "enclosure": "mp3"}}}`, true},
+		{"invalid start", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "a", "category": "Music",
+			"shows": [{"id": "night", "title": "Night", "start": "25:00", "duration": "1h"}]}}}`, true},
+		{"unknown day", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "a", "category": "Music",
+			"shows": [{"id": "night", "title": "Night", "start": "21:00", "duration": "1h", "days": ["someday"]}]}}}`, true},
+		{"duplicate show", `{"base_url": "https://radio.example.com", "stations": {"proseka": {"title": "t", "description": "d", "language": "ja", "artwork": "a", "category": "Music",
+			"shows": [{"id": "night", "title": "Night", "start": "21:00", "duration": "1h"}, {"id": "night", "title": "Night", "start": "22:00", "duration": "1h"}]}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "podcast.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("error %v is not ErrInvalidConfig", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package podcast
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/mpegts"
+)
+
+var ErrUnknownStation = errors.New("no podcast for station")
+
+const (
+	// maxLookback is how far back airings are looked up in the archive
+	maxLookback = 30 * 24 * time.Hour
+	// airedGrace gives the archive time to record the last segments of an airing
+	airedGrace    = time.Minute
+	buildInterval = time.Minute
+)
+
+var episodeFilePattern = regexp.MustCompile(`^([a-z0-9][a-z0-9-]*-\d{8}T\d{4}Z)\.m4a$`)
+
+// Archive is where the segments of past airings are looked up; *store.Store implements it
+type Archive interface {
+	ArchiveSegments(ctx context.Context, station string, start time.Time, end time.Time) ([]hls.PublishedSegment, error)
+}
+
+// PublisherConfig configures a Publisher
+type PublisherConfig struct {
+	Archive Archive
+	// Dir is where the .m4a files of episodes are assembled, one directory per station
+	Dir string
+	// Root is the contents root on disk, served under URLPrefix. Archived segments outside
+	// of it, such as filler, are left out of episodes.
+	Root      string
+	URLPrefix string
+	Logger    *slog.Logger
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time
+	// Private reports whether station requires listeners to authenticate, in which case
+	// feeds and episodes are not cached by shared caches. Every station is public when nil.
+	Private func(station string) bool
+}
+
+// Episode is an airing published in a feed. Episodes are kept next to their .m4a files,
+// so they outlive the retention of the archive.
+type Episode struct {
+	ID          string    `json:"id"`
+	Show        string    `json:"show"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Artwork     string    `json:"artwork,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// Duration is the audio published, in seconds
+	Duration float64 `json:"duration_seconds"`
+	// URL is the path of the enclosure; Length its size in bytes, 0 when unknown
+	URL    string `json:"url"`
+	Length int64  `json:"length"`
+	Type   string `json:"type"`
+}
+
+// Publisher turns the airings of scheduled shows in the archive into podcast feeds
+type Publisher struct {
+	config  Config
+	pc      PublisherConfig
+	logger  *slog.Logger
+	now     func() time.Time
+	feedTTL time.Duration
+}
+
+func NewPublisher(config Config, pc PublisherConfig) (*Publisher, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if pc.Archive == nil {
+		return nil, fmt.Errorf("%w: no archive", ErrInvalidConfig)
+	}
+	if len(config.Stations) > 0 && (pc.Dir == "" || pc.Root == "" || pc.URLPrefix == "") {
+		return nil, fmt.Errorf("%w: assembling episodes needs a directory and the contents root", ErrInvalidConfig)
+	}
+	if pc.Logger == nil {
+		pc.Logger = slog.Default()
+	}
+	if pc.Now == nil {
+		pc.Now = time.Now
+	}
+	return &Publisher{config: config, pc: pc, logger: pc.Logger, now: pc.Now, feedTTL: 5 * time.Minute}, nil
+}
+
+// airings returns the airings of every show of feed that ended within maxLookback, newest first
+func (p *Publisher) airings(feed FeedConfig) []Airing {
+	to := p.now().Add(-airedGrace)
+	var airings []Airing
+	for i := range feed.Shows {
+		airings = append(airings, feed.Shows[i].airings(to.Add(-maxLookback), to)...)
+	}
+	sort.Slice(airings, func(i, j int) bool { return airings[i].Start.After(airings[j].Start) })
+	return airings
+}
+
+func newEpisode(feed FeedConfig, a Airing) Episode {
+	return Episode{
+		ID:          a.EpisodeID(),
+		Show:        a.Show.ID,
+		Title:       a.Show.Title + " " + a.Start.In(a.Show.location).Format("2006-01-02"),
+		Description: cmp.Or(a.Show.Description, feed.Description),
+		Artwork:     a.Show.Artwork,
+		Start:       a.Start,
+		End:         a.End,
+	}
+}
+
+// Episodes returns the latest episodes of station assembled by Build, newest first
+func (p *Publisher) Episodes(ctx context.Context, station string) ([]Episode, error) {
+	feed, ok := p.config.Stations[station]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownStation, station)
+	}
+	built, err := p.builtEpisodes(station)
+	if err != nil {
+		return nil, err
+	}
+	episodes := []Episode{}
+	for _, e := range built {
+		if len(episodes) == feed.Episodes {
+			break
+		}
+		// 以前の .aac のエピソードは Apple Podcasts が受け付けないので載せない
+		if e.Type == m4aContentType {
+			episodes = append(episodes, e)
+		}
+	}
+	return episodes, nil
+}
+
+// builtEpisodes reads the episodes assembled by Build, newest first
+func (p *Publisher) builtEpisodes(station string) ([]Episode, error) {
+	files, err := filepath.Glob(filepath.Join(p.pc.Dir, station, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	episodes := []Episode{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var e Episode
+		if err := json.Unmarshal(data, &e); err != nil {
+			p.logger.Warn("skipping unreadable podcast episode", "path", file, "error", err)
+			continue
+		}
+		episodes = append(episodes, e)
+	}
+	sort.Slice(episodes, func(i, j int) bool { return episodes[i].Start.After(episodes[j].Start) })
+	return episodes, nil
+}
+
+// Build assembles the .m4a files of the airings that are archived but not built yet, and
+// deletes the files of episodes that fell out of their feed
+func (p *Publisher) Build(ctx context.Context) error {
+	var errs []error
+	for station, feed := range p.config.Stations {
+		if err := p.buildStation(ctx, station, feed); err != nil {
+			errs = append(errs, fmt.Errorf("station %s: %w", station, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Publisher) buildStation(ctx context.Context, station string, feed FeedConfig) error {
+	dir := filepath.Join(p.pc.Dir, station)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	built := 0
+	for _, a := range p.airings(feed) {
+		if built == feed.Episodes {
+			break
+		}
+		id := a.EpisodeID()
+		if p.built(dir, id) {
+			built++
+			continue
+		}
+		segs, err := p.pc.Archive.ArchiveSegments(ctx, station, a.Start, a.End)
+		if err != nil {
+			return err
+		}
+		if len(segs) == 0 {
+			continue
+		}
+		e := newEpisode(feed, a)
+		ok, err := p.assemble(dir, &e, segs)
+		if err != nil {
+			return fmt.Errorf("episode %s: %w", id, err)
+		}
+		if ok {
+			built++
+			p.logger.Info("built podcast episode", "station", station, "episode", id, "duration_seconds", e.Duration, "bytes", e.Length)
+		}
+	}
+	return p.prune(dir, feed.Episodes)
+}
+
+// built reports whether the episode id has been assembled. Episodes assembled as .aac by
+// earlier versions are built again while the archive still has them.
+func (p *Publisher) built(dir string, id string) bool {
+	for _, file := range []string{id + ".json", id + ".m4a"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// assemble concatenates the audio of segs into the .m4a file of e and writes its episode
+// file. It reports false when none of the segments had audio.
+func (p *Publisher) assemble(dir string, e *Episode, segs []hls.PublishedSegment) (bool, error) {
+	// ADTS を一旦つなげてから、フレームの大きさがわかった所で MP4 に詰め直す
+	adts, err := os.CreateTemp(dir, e.ID+".*.aac.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(adts.Name())
+	defer adts.Close()
+
+	samples, rate := 0, 0
+	for _, seg := range segs {
+		file, ok := hls.ContentsPath(p.pc.Root, p.pc.URLPrefix, seg.SourceURI)
+		if !ok {
+			// フィラーなどコンテンツ外のセグメントは入れない
+			continue
+		}
+		rate, err = copySegment(adts, file, &samples, rate)
+		if err != nil {
+			p.logger.Warn("skipping segment of podcast episode", "episode", e.ID, "path", file, "error", err)
+		}
+	}
+	if samples == 0 || rate == 0 {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(dir, e.ID+".*.m4a.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if samples, rate, err = writeM4A(tmp, adts); err != nil {
+		return false, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	e.Duration = float64(samples) / float64(rate)
+	e.Length = info.Size()
+	e.URL = path.Join("/stations", filepath.Base(dir), "episodes", e.ID+".m4a")
+	e.Type = m4aContentType
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, e.ID+".m4a")); err != nil {
+		return false, err
+	}
+	// エピソードファイルは最後に書く。これがあれば組み立て済み
+	return true, os.WriteFile(filepath.Join(dir, e.ID+".json"), meta, 0644)
+}
+
+// copySegment appends the audio of a TS file to w, adding to samples. It returns the sample
+// rate, which must stay the same across the segments of an episode.
+func copySegment(w io.Writer, file string, samples *int, rate int) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return rate, err
+	}
+	defer f.Close()
+	report, err := mpegts.Inspect(f)
+	if err != nil {
+		return rate, err
+	}
+	if rate != 0 && report.Audio.SampleRate != rate {
+		return rate, fmt.Errorf("sample rate %d differs from %d", report.Audio.SampleRate, rate)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return rate, err
+	}
+	n, err := mpegts.CopyADTS(w, f)
+	*samples += n
+	return report.Audio.SampleRate, err
+}
+
+// prune deletes all but the latest keep episodes in dir
+func (p *Publisher) prune(dir string, keep int) error {
+	episodes, err := p.builtEpisodes(filepath.Base(dir))
+	if err != nil || len(episodes) <= keep {
+		return err
+	}
+	for _, e := range episodes[keep:] {
+		for _, file := range []string{e.ID + ".json", e.ID + ".m4a", e.ID + ".aac"} {
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		p.logger.Info("deleted podcast episode", "episode", e.ID)
+	}
+	return nil
+}
+
+// Run builds episodes every minute until ctx is done
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(buildInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.Build(ctx); err != nil {
+			p.logger.Error("failed to build podcast episodes", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cacheScope is the Cache-Control directive that says who may cache the responses of station
+func (p *Publisher) cacheScope(station string) string {
+	if p.pc.Private != nil && p.pc.Private(station) {
+		return "private"
+	}
+	return "public"
+}
+
+// FeedHandler serves GET /stations/{name}/podcast.xml
+func (p *Publisher) FeedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		station := r.PathValue("name")
+		feed, err := p.Feed(r.Context(), station)
+		if errors.Is(err, ErrUnknownStation) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			p.logger.Error("failed to render podcast feed", "station", station, "error", err)
+			http.Error(w, "Failed to render feed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", p.cacheScope(station), int(p.feedTTL.Seconds())))
+		_, _ = w.Write(feed)
+	})
+}
+
+// EpisodeHandler serves GET /stations/{name}/episodes/{file}, the assembled .m4a files.
+// Range requests are supported, which podcast apps rely on for seeking.
+func (p *Publisher) EpisodeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		station := r.PathValue("name")
+		_, ok := p.config.Stations[station]
+		m := episodeFilePattern.FindStringSubmatch(r.PathValue("file"))
+		if !ok || m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(filepath.Join(p.pc.Dir, station, m[0]))
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to open episode", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "Failed to open episode", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", m4aContentType)
+		w.Header().Set("Cache-Control", p.cacheScope(station)+", max-age=86400")
+		http.ServeContent(w, r, m[0], info.ModTime(), f)
+	})
+}
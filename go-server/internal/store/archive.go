@@ -0,0 +1,299 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+const (
+	archiveQueueSize     = 256
+	archivePruneInterval = time.Hour
+	// maxArchiveRange bounds the VOD playlists served from the archive
+	maxArchiveRange = 24 * time.Hour
+)
+
+// RecordSegment appends a published segment to the archive, under the hour it was published in
+func (s *Store) RecordSegment(ctx context.Context, seg hls.PublishedSegment) error {
+	uri := seg.SourceURI
+	if uri == "" {
+		uri = seg.URI
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO archive_segments (station, hour, media_sequence, content_id, uri, init_uri, duration, discontinuity, filler, published_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		seg.Station, seg.PublishedAt.Truncate(time.Hour).Unix(), seg.MediaSequence, seg.ContentID, uri, seg.SourceInitURI,
+		seg.Duration, seg.Discontinuity, seg.Filler, seg.PublishedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to archive segment %d: %w", seg.MediaSequence, err)
+	}
+	return nil
+}
+
+// ArchiveSegments returns the archived segments of station published in [start, end), oldest first
+func (s *Store) ArchiveSegments(ctx context.Context, station string, start time.Time, end time.Time) ([]hls.PublishedSegment, error) {
+	// hour で索引を絞ってから published_at で切り出す
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT media_sequence, content_id, uri, init_uri, duration, discontinuity, filler, published_at
+		FROM archive_segments
+		WHERE station = ? AND hour >= ? AND hour < ? AND published_at >= ? AND published_at < ?
+		ORDER BY published_at, id`,
+		station, start.Truncate(time.Hour).Unix(), end.Unix(), start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive: %w", err)
+	}
+	defer rows.Close()
+
+	segs := []hls.PublishedSegment{}
+	for rows.Next() {
+		seg := hls.PublishedSegment{Station: station}
+		var publishedAt int64
+		if err := rows.Scan(&seg.MediaSequence, &seg.ContentID, &seg.SourceURI, &seg.SourceInitURI, &seg.Duration, &seg.Discontinuity, &seg.Filler, &publishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan archive: %w", err)
+		}
+		seg.URI = seg.SourceURI
+		seg.PublishedAt = time.UnixMilli(publishedAt).UTC()
+		segs = append(segs, seg)
+	}
+	return segs, rows.Err()
+}
+
+// ArchiveHour summarizes one hour of the archive of a station
+type ArchiveHour struct {
+	Start    time.Time `json:"start"`
+	Segments int64     `json:"segments"`
+	Seconds  float64   `json:"seconds"`
+	// ContentIDs are the contents that started in this hour, in the order they aired
+	ContentIDs []string `json:"content_ids"`
+}
+
+// ArchiveHours returns the archived hours of station starting in [from, to), oldest first
+func (s *Store) ArchiveHours(ctx context.Context, station string, from time.Time, to time.Time) ([]ArchiveHour, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT hour, content_id, duration, discontinuity AND NOT filler
+		FROM archive_segments
+		WHERE station = ? AND hour >= ? AND hour < ?
+		ORDER BY hour, published_at, id`,
+		station, from.Truncate(time.Hour).Unix(), to.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive hours: %w", err)
+	}
+	defer rows.Close()
+
+	hours := []ArchiveHour{}
+	for rows.Next() {
+		var hour int64
+		var contentID string
+		var duration float64
+		var contentStart bool
+		if err := rows.Scan(&hour, &contentID, &duration, &contentStart); err != nil {
+			return nil, fmt.Errorf("failed to scan archive hours: %w", err)
+		}
+		start := time.Unix(hour, 0).UTC()
+		if len(hours) == 0 || !hours[len(hours)-1].Start.Equal(start) {
+			hours = append(hours, ArchiveHour{Start: start, ContentIDs: []string{}})
+		}
+		h := &hours[len(hours)-1]
+		h.Segments++
+		h.Seconds += duration
+		if contentStart {
+			h.ContentIDs = append(h.ContentIDs, contentID)
+		}
+	}
+	return hours, rows.Err()
+}
+
+// PruneArchive deletes the archived hours that ended before before and returns the number
+// of segments deleted
+func (s *Store) PruneArchive(ctx context.Context, before time.Time) (int64, error) {
+	// 時間単位で消すので、途中まで欠けた時間帯は残らない
+	res, err := s.db.ExecContext(ctx, `DELETE FROM archive_segments WHERE hour <= ?`,
+		before.Add(-time.Hour).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune archive: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ArchiveRecorder archives every segment a station publishes. Like HistoryRecorder it
+// writes on a separate goroutine, and it prunes hours older than the retention.
+type ArchiveRecorder struct {
+	store     *Store
+	retention time.Duration
+	logger    *slog.Logger
+	queue     chan hls.PublishedSegment
+}
+
+// NewArchiveRecorder returns a recorder keeping retention of archive; zero keeps everything
+func NewArchiveRecorder(s *Store, retention time.Duration, logger *slog.Logger) *ArchiveRecorder {
+	return &ArchiveRecorder{
+		store:     s,
+		retention: retention,
+		logger:    logger,
+		queue:     make(chan hls.PublishedSegment, archiveQueueSize),
+	}
+}
+
+// SegmentPublished implements hls.SegmentObserver
+func (r *ArchiveRecorder) SegmentPublished(seg hls.PublishedSegment) {
+	select {
+	case r.queue <- seg:
+	default:
+		r.logger.Warn("archive queue is full, dropping segment", "station", seg.Station, "media_sequence", seg.MediaSequence)
+	}
+}
+
+// Run writes queued segments and prunes the archive every hour until ctx is done, then
+// writes the segments still queued
+func (r *ArchiveRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(archivePruneInterval)
+	defer ticker.Stop()
+	r.prune(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			ctx = context.WithoutCancel(ctx)
+			for {
+				select {
+				case seg := <-r.queue:
+					r.record(ctx, seg)
+				default:
+					return
+				}
+			}
+		case seg := <-r.queue:
+			r.record(ctx, seg)
+		case <-ticker.C:
+			r.prune(ctx)
+		}
+	}
+}
+
+func (r *ArchiveRecorder) record(ctx context.Context, seg hls.PublishedSegment) {
+	if err := r.store.RecordSegment(ctx, seg); err != nil {
+		r.logger.Error("failed to archive segment", "station", seg.Station, "media_sequence", seg.MediaSequence, "error", err)
+	}
+}
+
+func (r *ArchiveRecorder) prune(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+	n, err := r.store.PruneArchive(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		r.logger.Error("failed to prune archive", "error", err)
+		return
+	}
+	if n > 0 {
+		r.logger.Info("pruned archive", "segments", n, "retention", r.retention)
+	}
+}
+
+// ArchivePlaylistHandler serves GET /stations/{name}/archive.m3u8?start=...&end=... as a VOD
+// playlist of the segments published in [start, end). start and end accept RFC 3339 or unix
+// seconds and may span up to 24 hours. Segment URIs are passed through rewriter, if not nil.
+func (s *Store) ArchivePlaylistHandler(rewriter hls.URLRewriter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end time.Time
+		for name, t := range map[string]*time.Time{"start": &start, "end": &end} {
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				http.Error(w, "missing "+name, http.StatusBadRequest)
+				return
+			}
+			parsed, err := parseTime(v)
+			if err != nil {
+				http.Error(w, "invalid "+name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+		if !end.After(start) || end.Sub(start) > maxArchiveRange {
+			http.Error(w, "end must be after start and within 24 hours of it", http.StatusBadRequest)
+			return
+		}
+
+		segs, err := s.ArchiveSegments(r.Context(), r.PathValue("name"), start, end)
+		if err != nil {
+			http.Error(w, "Failed to query archive", http.StatusInternalServerError)
+			return
+		}
+		if len(segs) == 0 {
+			http.Error(w, "Nothing archived in this range", http.StatusNotFound)
+			return
+		}
+		c, err := hls.FormatVOD(segs, rewriter)
+		if err != nil {
+			http.Error(w, "Failed to format playlist", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", hls.PlaylistContentType)
+		// 終わった範囲は変わらないが、署名の期限があるので長くは持たせない
+		if end.Before(time.Now()) {
+			w.Header().Set("Cache-Control", "private, max-age=300")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		_, _ = w.Write(c.Bytes())
+	})
+}
+
+// ArchiveIndexHandler serves GET /api/stations/{name}/archive?from=...&to=..., the archived
+// hours with the URL of their replay. The default is the last 24 hours.
+func (s *Store) ArchiveIndexHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		station := r.PathValue("name")
+
+		to := time.Now()
+		from := to.Add(-24 * time.Hour)
+		for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+			if v := r.URL.Query().Get(name); v != "" {
+				parsed, err := parseTime(v)
+				if err != nil {
+					http.Error(w, "invalid "+name+": "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+
+		hours, err := s.ArchiveHours(r.Context(), station, from, to)
+		if err != nil {
+			http.Error(w, "Failed to query archive", http.StatusInternalServerError)
+			return
+		}
+		type hourEntry struct {
+			ArchiveHour
+			URL string `json:"url"`
+		}
+		entries := make([]hourEntry, 0, len(hours))
+		for _, h := range hours {
+			entries = append(entries, hourEntry{h, ArchiveURL(station, h.Start, h.Start.Add(time.Hour))})
+		}
+		body, err := json.Marshal(struct {
+			Station string      `json:"station"`
+			Hours   []hourEntry `json:"hours"`
+		}{station, entries})
+		if err != nil {
+			http.Error(w, "Failed to encode archive", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
+
+// ArchiveURL is the path of the VOD playlist of station for [start, end)
+func ArchiveURL(station string, start time.Time, end time.Time) string {
+	q := url.Values{}
+	q.Set("start", start.UTC().Format(time.RFC3339))
+	q.Set("end", end.UTC().Format(time.RFC3339))
+	return "/stations/" + url.PathEscape(station) + "/archive.m3u8?" + q.Encode()
+}
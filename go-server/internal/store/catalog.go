@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+// Store implements hls.Catalog
+var _ hls.Catalog = (*Store)(nil)
+
+const selectTracks = `
+SELECT t.id, t.title, t.artist, t.length, t.m3u8, t.type, COALESCE(group_concat(g.tag, char(31)), '')
+FROM tracks t LEFT JOIN track_tags g ON g.track_id = t.id`
+
+func (s *Store) List() []hls.Track {
+	tracks, err := s.queryTracks(context.Background(), selectTracks+` GROUP BY t.id ORDER BY t.id`)
+	s.recordErr(err)
+	return tracks
+}
+
+func (s *Store) Get(id string) (hls.Track, error) {
+	tracks, err := s.queryTracks(context.Background(), selectTracks+` WHERE t.id = ? GROUP BY t.id`, id)
+	if err != nil {
+		return hls.Track{}, err
+	}
+	if len(tracks) == 0 {
+		return hls.Track{}, fmt.Errorf("%w: %s", hls.ErrTrackNotFound, id)
+	}
+	return tracks[0], nil
+}
+
+func (s *Store) Search(query string) []hls.Track {
+	pattern := "%" + escapeLike(query) + "%"
+	tracks, err := s.queryTracks(context.Background(), selectTracks+`
+		WHERE t.id LIKE ?1 ESCAPE '\' OR t.title LIKE ?1 ESCAPE '\' OR t.artist LIKE ?1 ESCAPE '\'
+		GROUP BY t.id ORDER BY t.id`, pattern)
+	s.recordErr(err)
+	return tracks
+}
+
+func (s *Store) queryTracks(ctx context.Context, query string, args ...any) ([]hls.Track, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []hls.Track
+	for rows.Next() {
+		var t hls.Track
+		var tags string
+		if err := rows.Scan(&t.ID, &t.Title, &t.Artist, &t.Length, &t.M3U8, &t.Type, &tags); err != nil {
+			return nil, fmt.Errorf("failed to scan track: %w", err)
+		}
+		if tags != "" {
+			t.Tags = strings.Split(tags, "\x1f")
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// PutTrack inserts or replaces a track and its tags
+func (s *Store) PutTrack(ctx context.Context, t hls.Track) error {
+	if err := s.withTx(ctx, func(tx *sql.Tx) error { return putTrack(ctx, tx, t) }); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// ImportTracks puts all tracks in a single transaction, e.g. to seed the store from index.json
+func (s *Store) ImportTracks(ctx context.Context, tracks []hls.Track) error {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, t := range tracks {
+			if err := putTrack(ctx, tx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// DeleteTrack removes a track; deleting a missing track returns hls.ErrTrackNotFound
+func (s *Store) DeleteTrack(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM tracks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete track %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", hls.ErrTrackNotFound, id)
+	}
+	s.notify()
+	return nil
+}
+
+func putTrack(ctx context.Context, tx *sql.Tx, t hls.Track) error {
+	if t.ID == "" {
+		return errors.New("track id is empty")
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO tracks (id, title, artist, length, m3u8, type) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			title = excluded.title, artist = excluded.artist, length = excluded.length,
+			m3u8 = excluded.m3u8, type = excluded.type`,
+		t.ID, t.Title, t.Artist, t.Length, t.M3U8, t.Type)
+	if err != nil {
+		return fmt.Errorf("failed to put track %s: %w", t.ID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM track_tags WHERE track_id = ?`, t.ID); err != nil {
+		return fmt.Errorf("failed to clear tags of %s: %w", t.ID, err)
+	}
+	for _, tag := range t.Tags {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO track_tags (track_id, tag) VALUES (?, ?)`, t.ID, tag); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", t.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) withTx(ctx context.Context, f func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+	historyQueueSize    = 64
+)
+
+// Play is one entry of the play history
+type Play struct {
+	ID            int64     `json:"id"`
+	Station       string    `json:"station"`
+	ContentID     string    `json:"content_id"`
+	Title         string    `json:"title,omitempty"`
+	Artist        string    `json:"artist,omitempty"`
+	MediaSequence int       `json:"media_sequence"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// RecordPlay appends a play to the history
+func (s *Store) RecordPlay(ctx context.Context, p Play) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO play_history (station, content_id, media_sequence, started_at) VALUES (?, ?, ?, ?)`,
+		p.Station, p.ContentID, p.MediaSequence, p.StartedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to record play of %s: %w", p.ContentID, err)
+	}
+	return nil
+}
+
+// History returns the plays of station started at or after since, newest first
+func (s *Store) History(ctx context.Context, station string, since time.Time, limit int) ([]Play, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT h.id, h.station, h.content_id, COALESCE(t.title, ''), COALESCE(t.artist, ''), h.media_sequence, h.started_at
+		FROM play_history h LEFT JOIN tracks t ON t.id = h.content_id
+		WHERE h.station = ? AND h.started_at >= ?
+		ORDER BY h.started_at DESC, h.id DESC
+		LIMIT ?`,
+		station, since.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	plays := []Play{}
+	for rows.Next() {
+		var p Play
+		var startedAt int64
+		if err := rows.Scan(&p.ID, &p.Station, &p.ContentID, &p.Title, &p.Artist, &p.MediaSequence, &startedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		p.StartedAt = time.UnixMilli(startedAt).UTC()
+		plays = append(plays, p)
+	}
+	return plays, rows.Err()
+}
+
+// HistoryRecorder writes a play every time a station publishes the first segment of a content.
+// Writes happen on a separate goroutine so that a slow disk never stalls the live playlist.
+type HistoryRecorder struct {
+	store  *Store
+	logger *slog.Logger
+	queue  chan Play
+}
+
+func NewHistoryRecorder(s *Store, logger *slog.Logger) *HistoryRecorder {
+	return &HistoryRecorder{
+		store:  s,
+		logger: logger,
+		queue:  make(chan Play, historyQueueSize),
+	}
+}
+
+// SegmentPublished implements hls.SegmentObserver
+func (r *HistoryRecorder) SegmentPublished(seg hls.PublishedSegment) {
+	if !seg.ContentStart() {
+		return
+	}
+	play := Play{
+		Station:       seg.Station,
+		ContentID:     seg.ContentID,
+		MediaSequence: seg.MediaSequence,
+		StartedAt:     seg.PublishedAt,
+	}
+	select {
+	case r.queue <- play:
+	default:
+		r.logger.Warn("play history queue is full, dropping play", "station", seg.Station, "content_id", seg.ContentID)
+	}
+}
+
+// Run writes queued plays until ctx is done, then writes the plays still queued
+func (r *HistoryRecorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			ctx = context.WithoutCancel(ctx)
+			for {
+				select {
+				case play := <-r.queue:
+					r.record(ctx, play)
+				default:
+					return
+				}
+			}
+		case play := <-r.queue:
+			r.record(ctx, play)
+		}
+	}
+}
+
+func (r *HistoryRecorder) record(ctx context.Context, play Play) {
+	if err := r.store.RecordPlay(ctx, play); err != nil {
+		r.logger.Error("failed to record play", "station", play.Station, "content_id", play.ContentID, "error", err)
+	}
+}
+
+// HistoryHandler serves GET /api/stations/{name}/history?since=...&limit=...
+// since accepts RFC 3339 or unix seconds and defaults to 24 hours ago.
+func (s *Store) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		station := r.PathValue("name")
+
+		since := time.Now().Add(-24 * time.Hour)
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+
+		limit := defaultHistoryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxHistoryLimit)
+		}
+
+		plays, err := s.History(r.Context(), station, since, limit)
+		if err != nil {
+			http.Error(w, "Failed to query history", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := json.Marshal(struct {
+			Station string `json:"station"`
+			Plays   []Play `json:"plays"`
+		}{station, plays})
+		if err != nil {
+			http.Error(w, "Failed to encode history", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
+
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/listeners"
+)
+
+// RecordSession implements listeners.Sink
+func (s *Store) RecordSession(ctx context.Context, session listeners.Session) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO listener_sessions (station, session_id, subject, started_at, ended_at, requests) VALUES (?, ?, ?, ?, ?, ?)`,
+		session.Station, session.ID, session.Subject, session.StartedAt.UnixMilli(), session.LastSeen.UnixMilli(), session.Requests)
+	if err != nil {
+		return fmt.Errorf("failed to record listener session %s: %w", session.ID, err)
+	}
+	return nil
+}
+
+// DailyListeners aggregates the listener sessions that started on one day (UTC)
+type DailyListeners struct {
+	Date             string  `json:"date"` // YYYY-MM-DD
+	Sessions         int64   `json:"sessions"`
+	UniqueListeners  int64   `json:"unique_listeners"`
+	ListeningSeconds float64 `json:"listening_seconds"`
+	// PeakHourSessions is the number of sessions started in the busiest hour of the day
+	PeakHourSessions int64 `json:"peak_hour_sessions"`
+}
+
+// ListenerReport returns per-day listener figures of station for sessions started in [from, to)
+func (s *Store) ListenerReport(ctx context.Context, station string, from time.Time, to time.Time) ([]DailyListeners, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH sessions AS (
+			SELECT session_id, started_at, ended_at,
+				date(started_at / 1000, 'unixepoch') AS day,
+				strftime('%H', started_at / 1000, 'unixepoch') AS hour
+			FROM listener_sessions
+			WHERE station = ? AND started_at >= ? AND started_at < ?
+		),
+		hourly AS (
+			SELECT day, COUNT(*) AS n FROM sessions GROUP BY day, hour
+		)
+		SELECT s.day, COUNT(*), COUNT(DISTINCT s.session_id), SUM(s.ended_at - s.started_at),
+			(SELECT MAX(n) FROM hourly h WHERE h.day = s.day)
+		FROM sessions s
+		GROUP BY s.day
+		ORDER BY s.day`,
+		station, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listener report: %w", err)
+	}
+	defer rows.Close()
+
+	days := []DailyListeners{}
+	for rows.Next() {
+		var d DailyListeners
+		var listenedMillis int64
+		if err := rows.Scan(&d.Date, &d.Sessions, &d.UniqueListeners, &listenedMillis, &d.PeakHourSessions); err != nil {
+			return nil, fmt.Errorf("failed to scan listener report: %w", err)
+		}
+		d.ListeningSeconds = float64(listenedMillis) / 1000
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// ListenerReportHandler serves GET /api/stations/{name}/listeners/daily?from=...&to=...
+// from and to accept RFC 3339 or unix seconds; the default is the last 7 days.
+func (s *Store) ListenerReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		station := r.PathValue("name")
+
+		to := time.Now()
+		from := to.AddDate(0, 0, -7)
+		for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+			if v := r.URL.Query().Get(name); v != "" {
+				parsed, err := parseTime(v)
+				if err != nil {
+					http.Error(w, "invalid "+name+": "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+
+		days, err := s.ListenerReport(r.Context(), station, from, to)
+		if err != nil {
+			http.Error(w, "Failed to query listener report", http.StatusInternalServerError)
+			return
+		}
+		body, err := json.Marshal(struct {
+			Station string           `json:"station"`
+			Days    []DailyListeners `json:"days"`
+		}{station, days})
+		if err != nil {
+			http.Error(w, "Failed to encode listener report", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
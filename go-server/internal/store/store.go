@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	_ "modernc.org/sqlite" // pure-Go SQLite driver
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tracks (
+	id     TEXT PRIMARY KEY,
+	title  TEXT NOT NULL DEFAULT '',
+	artist TEXT NOT NULL DEFAULT '',
+	length INTEGER NOT NULL DEFAULT 0,
+	m3u8   TEXT NOT NULL DEFAULT '',
+	type   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS track_tags (
+	track_id TEXT NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+	tag      TEXT NOT NULL,
+	PRIMARY KEY (track_id, tag)
+);
+
+-- catalog_version is bumped by triggers on every catalog change, including
+-- changes made by other processes, so that Watch can poll a single row
+CREATE TABLE IF NOT EXISTS catalog_version (
+	id      INTEGER PRIMARY KEY CHECK (id = 0),
+	version INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO catalog_version (id, version) VALUES (0, 0);
+
+CREATE TRIGGER IF NOT EXISTS tracks_insert AFTER INSERT ON tracks
+	BEGIN UPDATE catalog_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS tracks_update AFTER UPDATE ON tracks
+	BEGIN UPDATE catalog_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS tracks_delete AFTER DELETE ON tracks
+	BEGIN UPDATE catalog_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS track_tags_insert AFTER INSERT ON track_tags
+	BEGIN UPDATE catalog_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS track_tags_delete AFTER DELETE ON track_tags
+	BEGIN UPDATE catalog_version SET version = version + 1; END;
+
+CREATE TABLE IF NOT EXISTS play_history (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	station        TEXT NOT NULL,
+	content_id     TEXT NOT NULL,
+	media_sequence INTEGER NOT NULL,
+	started_at     INTEGER NOT NULL -- unix milliseconds
+);
+CREATE INDEX IF NOT EXISTS play_history_station_started ON play_history (station, started_at);
+
+CREATE TABLE IF NOT EXISTS listener_sessions (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	station     TEXT NOT NULL,
+	session_id  TEXT NOT NULL,
+	subject     TEXT NOT NULL DEFAULT '',
+	started_at  INTEGER NOT NULL, -- unix milliseconds
+	ended_at    INTEGER NOT NULL, -- unix milliseconds of the last request
+	requests    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS listener_sessions_station_started ON listener_sessions (station, started_at);
+
+-- archive_segments is the broadcast archive, indexed by the hour each segment was published in
+CREATE TABLE IF NOT EXISTS archive_segments (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	station        TEXT NOT NULL,
+	hour           INTEGER NOT NULL, -- unix seconds of the start of the hour
+	media_sequence INTEGER NOT NULL,
+	content_id     TEXT NOT NULL,
+	uri            TEXT NOT NULL, -- before encryption and URL rewriting
+	init_uri       TEXT NOT NULL DEFAULT '', -- EXT-X-MAP of fMP4 segments, before URL rewriting
+	duration       REAL NOT NULL,
+	discontinuity  INTEGER NOT NULL,
+	filler         INTEGER NOT NULL,
+	published_at   INTEGER NOT NULL -- unix milliseconds
+);
+CREATE INDEX IF NOT EXISTS archive_segments_station_hour ON archive_segments (station, hour, published_at);
+`
+
+// addedColumns are columns added to tables after they were first created. CREATE TABLE IF
+// NOT EXISTS leaves the tables of older databases as they are, so Open adds the missing ones.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"archive_segments", "init_uri", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func addColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store is an embedded SQLite database holding the track catalog, play history, listener
+// sessions and the broadcast archive
+type Store struct {
+	db           *sql.DB
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	watchers  map[chan struct{}]struct{}
+	lastErr   error
+	formatter hls.ContentFormatter
+}
+
+// Open opens (creating if needed) the database at path. Watch polls it for catalog
+// changes made by other processes every pollInterval (zero disables polling).
+func Open(path string, pollInterval time.Duration) (*Store, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database %s: %w", path, err)
+	}
+	if err := addColumns(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database %s: %w", path, err)
+	}
+	return &Store{
+		db:           db,
+		pollInterval: pollInterval,
+		watchers:     make(map[chan struct{}]struct{}),
+	}, nil
+}
+
+// SetFormatter sets how the tracks of this catalog are resolved to files and URLs
+func (s *Store) SetFormatter(f hls.ContentFormatter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.formatter = f
+}
+
+// Formatter returns the formatter set with SetFormatter, or nil for the default
+func (s *Store) Formatter() hls.ContentFormatter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.formatter
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) recordErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+// Err returns the error of the last catalog read, or nil if it succeeded
+func (s *Store) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Reload checks that the database is reachable; reads always go to the database
+func (s *Store) Reload() error {
+	err := s.db.Ping()
+	s.recordErr(err)
+	return err
+}
+
+func (s *Store) catalogVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM catalog_version WHERE id = 0`).Scan(&version)
+	return version, err
+}
+
+// Watch returns a channel that receives a value whenever the catalog changes.
+// The channel is closed when ctx is done.
+func (s *Store) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	last, _ := s.catalogVersion(ctx)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.watchers, ch)
+			close(ch)
+			s.mu.Unlock()
+		}()
+
+		if s.pollInterval <= 0 {
+			<-ctx.Done()
+			return
+		}
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				version, err := s.catalogVersion(ctx)
+				if err != nil || version == last {
+					continue
+				}
+				last = version
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// notify wakes up watchers after a change made through this Store
+func (s *Store) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
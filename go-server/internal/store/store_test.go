@@ -0,0 +1,460 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	hls "github.com/furudenipa/hls-radio-server/go-server/internal/hls"
+	"github.com/furudenipa/hls-radio-server/go-server/internal/listeners"
+)
+
+func openTestStore(t *testing.T, poll time.Duration) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "radio.db"), poll)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoreCatalog(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch := s.Watch(ctx)
+	err := s.ImportTracks(ctx, []hls.Track{
+		{ID: "1", Title: "Tell Your World", Artist: "livetune", Length: 260, Type: "music", Tags: []string{"miku", "classic"}},
+		{ID: "2", Title: "Hello, Worker", Artist: "KEI", Length: 245, Type: "music"},
+		{ID: "3", Title: "100%_Ticket", Artist: "unknown", Length: 200, Type: "music"},
+	})
+	if err != nil {
+		t.Fatalf("ImportTracks() error = %v", err)
+	}
+	select {
+	case <-watch:
+	case <-time.After(time.Second):
+		t.Fatal("Watch() was not notified after ImportTracks")
+	}
+
+	if got := len(s.List()); got != 3 {
+		t.Fatalf("List() returned %d tracks, want 3", got)
+	}
+
+	got, err := s.Get("1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Title != "Tell Your World" || !slices.Equal(got.Tags, []string{"classic", "miku"}) {
+		t.Errorf("Get() = %+v", got)
+	}
+	if _, err := s.Get("404"); !errors.Is(err, hls.ErrTrackNotFound) {
+		t.Errorf("Get() of missing track error = %v, want ErrTrackNotFound", err)
+	}
+
+	searches := []struct {
+		query string
+		want  []string
+	}{
+		{"world", []string{"1"}},
+		{"kei", []string{"2"}},
+		{"100%", []string{"3"}},
+		{"_", []string{"3"}},
+		{"nothing", nil},
+	}
+	for _, tt := range searches {
+		var ids []string
+		for _, tr := range s.Search(tt.query) {
+			ids = append(ids, tr.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	if err := s.PutTrack(ctx, hls.Track{ID: "1", Title: "Tell Your World", Tags: []string{"miku"}}); err != nil {
+		t.Fatalf("PutTrack() error = %v", err)
+	}
+	if got, _ := s.Get("1"); !slices.Equal(got.Tags, []string{"miku"}) {
+		t.Errorf("tags after PutTrack() = %v, want [miku]", got.Tags)
+	}
+
+	if err := s.DeleteTrack(ctx, "2"); err != nil {
+		t.Fatalf("DeleteTrack() error = %v", err)
+	}
+	if err := s.DeleteTrack(ctx, "2"); !errors.Is(err, hls.ErrTrackNotFound) {
+		t.Errorf("second DeleteTrack() error = %v, want ErrTrackNotFound", err)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestStoreWatchExternalChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radio.db")
+	s, err := Open(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch := s.Watch(ctx)
+
+	// 別プロセスからの書き込みを模して、別のハンドルで更新する
+	other, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer other.Close()
+	if err := other.PutTrack(context.Background(), hls.Track{ID: "1", Title: "Melt"}); err != nil {
+		t.Fatalf("PutTrack() error = %v", err)
+	}
+
+	select {
+	case <-watch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch() did not notice a change made through another handle")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+	if err := s.PutTrack(ctx, hls.Track{ID: "1", Title: "Tell Your World", Artist: "livetune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := NewHistoryRecorder(s, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		recorder.Run(runCtx)
+		close(done)
+	}()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	published := []hls.PublishedSegment{
+		{Station: "proseka", ContentID: "1", Discontinuity: true, MediaSequence: 10, PublishedAt: base},
+		{Station: "proseka", ContentID: "1", MediaSequence: 11, PublishedAt: base.Add(10 * time.Second)},
+		{Station: "proseka", ContentID: "filler", Discontinuity: true, Filler: true, MediaSequence: 12, PublishedAt: base.Add(20 * time.Second)},
+		{Station: "proseka", ContentID: "2", Discontinuity: true, MediaSequence: 13, PublishedAt: base.Add(30 * time.Second)},
+		{Station: "classic", ContentID: "1", Discontinuity: true, MediaSequence: 1, PublishedAt: base},
+	}
+	for _, seg := range published {
+		recorder.SegmentPublished(seg)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var plays []Play
+	for time.Now().Before(deadline) {
+		var err error
+		plays, err = s.History(ctx, "proseka", base, 10)
+		if err != nil {
+			t.Fatalf("History() error = %v", err)
+		}
+		if len(plays) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(plays) != 2 {
+		t.Fatalf("History() returned %d plays, want 2: %+v", len(plays), plays)
+	}
+	if plays[0].ContentID != "2" || plays[0].MediaSequence != 13 {
+		t.Errorf("newest play = %+v, want content 2 at sequence 13", plays[0])
+	}
+	if plays[1].Title != "Tell Your World" || !plays[1].StartedAt.Equal(base) {
+		t.Errorf("oldest play = %+v, want Tell Your World at %v", plays[1], base)
+	}
+
+	if plays, _ := s.History(ctx, "proseka", base.Add(time.Second), 10); len(plays) != 1 {
+		t.Errorf("History() since filter returned %d plays, want 1", len(plays))
+	}
+	if plays, _ := s.History(ctx, "proseka", base, 1); len(plays) != 1 {
+		t.Errorf("History() limit returned %d plays, want 1", len(plays))
+	}
+}
+
+func TestRecordersWriteQueueOnCancel(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	history := NewHistoryRecorder(s, logger)
+	archive := NewArchiveRecorder(s, 0, logger)
+
+	base := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		seg := hls.PublishedSegment{Station: "proseka", ContentID: strconv.Itoa(i), SourceURI: "/contents/" + strconv.Itoa(i) + "/0.ts",
+			Duration: 10, Discontinuity: true, MediaSequence: i, PublishedAt: base.Add(time.Duration(i) * 10 * time.Second)}
+		history.SegmentPublished(seg)
+		archive.SegmentPublished(seg)
+	}
+	// 終了時にキューに残っている分も書き出してから戻る
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	history.Run(canceled)
+	archive.Run(canceled)
+
+	if plays, err := s.History(ctx, "proseka", base, 100); err != nil || len(plays) != 10 {
+		t.Errorf("History() = %d plays, %v; want 10", len(plays), err)
+	}
+	if segs, err := s.ArchiveSegments(ctx, "proseka", base, base.Add(time.Hour)); err != nil || len(segs) != 10 {
+		t.Errorf("ArchiveSegments() = %d segments, %v; want 10", len(segs), err)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"1", "2", "3"} {
+		play := Play{Station: "proseka", ContentID: id, MediaSequence: i, StartedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.RecordPlay(ctx, play); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/stations/{name}/history", s.HistoryHandler())
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantPlays int
+	}{
+		{"rfc3339 since", "?since=2025-01-01T12:00:00Z", http.StatusOK, 3},
+		{"unix since with limit", "?since=1735732860&limit=1", http.StatusOK, 1},
+		{"default since excludes old plays", "", http.StatusOK, 0},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest, 0},
+		{"invalid limit", "?since=2025-01-01T12:00:00Z&limit=-1", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stations/proseka/history"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Station string `json:"station"`
+				Plays   []Play `json:"plays"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid json: %v", err)
+			}
+			if body.Station != "proseka" || len(body.Plays) != tt.wantPlays {
+				t.Errorf("got station %q with %d plays, want proseka with %d", body.Station, len(body.Plays), tt.wantPlays)
+			}
+		})
+	}
+}
+
+func TestListenerReport(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+
+	day1 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, session := range []listeners.Session{
+		{ID: "a", Station: "proseka", StartedAt: day1, LastSeen: day1.Add(30 * time.Minute), Requests: 300},
+		{ID: "b", Station: "proseka", StartedAt: day1.Add(10 * time.Minute), LastSeen: day1.Add(20 * time.Minute), Requests: 100},
+		{ID: "a", Station: "proseka", StartedAt: day1.Add(5 * time.Hour), LastSeen: day1.Add(5*time.Hour + time.Minute), Requests: 10},
+		{ID: "c", Station: "proseka", StartedAt: day2, LastSeen: day2.Add(time.Minute), Requests: 10},
+		{ID: "d", Station: "other", StartedAt: day1, LastSeen: day1.Add(time.Hour), Requests: 600},
+	} {
+		if err := s.RecordSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	days, err := s.ListenerReport(ctx, "proseka", day1.Add(-time.Hour), day2.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DailyListeners{
+		{Date: "2026-10-01", Sessions: 3, UniqueListeners: 2, ListeningSeconds: 41 * 60, PeakHourSessions: 2},
+		{Date: "2026-10-02", Sessions: 1, UniqueListeners: 1, ListeningSeconds: 60, PeakHourSessions: 1},
+	}
+	if !slices.Equal(days, want) {
+		t.Errorf("ListenerReport() = %+v, want %+v", days, want)
+	}
+
+	rec := httptest.NewRecorder()
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/stations/{name}/listeners/daily", s.ListenerReportHandler())
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stations/proseka/listeners/daily?from=2026-10-02T00:00:00Z&to=2026-10-03T00:00:00Z", nil))
+	var resp struct {
+		Station string           `json:"station"`
+		Days    []DailyListeners `json:"days"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Station != "proseka" || !slices.Equal(resp.Days, want[1:]) {
+		t.Errorf("handler response = %+v", resp)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, 0)
+
+	hour := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	published := []hls.PublishedSegment{
+		{Station: "proseka", ContentID: "1", URI: "/contents/1/0.ts?token=x", SourceURI: "/contents/1/0.ts", Duration: 10, Discontinuity: true, MediaSequence: 5, PublishedAt: hour.Add(59 * time.Minute)},
+		{Station: "proseka", ContentID: "1", URI: "/contents/1/1.ts?token=x", SourceURI: "/contents/1/1.ts", Duration: 10, MediaSequence: 6, PublishedAt: hour.Add(time.Hour)},
+		{Station: "proseka", ContentID: "filler", URI: "/silence.ts", Duration: 2, Discontinuity: true, Filler: true, MediaSequence: 7, PublishedAt: hour.Add(time.Hour + 10*time.Second)},
+		{Station: "proseka", ContentID: "2", SourceURI: "/contents/2/0.m4s", SourceInitURI: "/contents/2/init.mp4", Duration: 8, Discontinuity: true, MediaSequence: 8, PublishedAt: hour.Add(2 * time.Hour)},
+		{Station: "other", ContentID: "3", SourceURI: "/contents/3/0.ts", Duration: 10, Discontinuity: true, MediaSequence: 0, PublishedAt: hour},
+	}
+	for _, seg := range published {
+		if err := s.RecordSegment(ctx, seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hours, err := s.ArchiveHours(ctx, "proseka", hour, hour.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 3 || hours[1].Segments != 2 || hours[1].Seconds != 12 || len(hours[1].ContentIDs) != 0 ||
+		!slices.Equal(hours[0].ContentIDs, []string{"1"}) || !hours[2].Start.Equal(hour.Add(2*time.Hour)) {
+		t.Errorf("ArchiveHours() = %+v", hours)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /stations/{name}/archive.m3u8", s.ArchivePlaylistHandler(nil))
+	mux.Handle("GET /api/stations/{name}/archive", s.ArchiveIndexHandler())
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantURIs []string
+	}{
+		{"range across hours", ArchiveURL("proseka", hour.Add(30*time.Minute), hour.Add(time.Hour+5*time.Second)), http.StatusOK,
+			[]string{"/contents/1/0.ts", "/contents/1/1.ts"}},
+		{"unix seconds", "/stations/proseka/archive.m3u8?start=" + strconv.FormatInt(hour.Add(time.Hour).Unix(), 10) + "&end=" + strconv.FormatInt(hour.Add(3*time.Hour).Unix(), 10), http.StatusOK,
+			[]string{"/contents/1/1.ts", "/silence.ts", "/contents/2/0.m4s"}},
+		{"nothing archived", ArchiveURL("proseka", hour.Add(-time.Hour), hour), http.StatusNotFound, nil},
+		{"missing end", "/stations/proseka/archive.m3u8?start=2026-10-01T09:00:00Z", http.StatusBadRequest, nil},
+		{"end before start", ArchiveURL("proseka", hour, hour.Add(-time.Minute)), http.StatusBadRequest, nil},
+		{"range too long", ArchiveURL("proseka", hour, hour.Add(25*time.Hour)), http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			body := rec.Body.String()
+			if rec.Header().Get("Content-Type") != hls.PlaylistContentType ||
+				!strings.Contains(body, "#EXT-X-PLAYLIST-TYPE:VOD\n") || !strings.HasSuffix(body, "#EXT-X-ENDLIST\n") {
+				t.Errorf("not a VOD playlist:\n%s", body)
+			}
+			var uris []string
+			for _, line := range strings.Split(body, "\n") {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					uris = append(uris, line)
+				}
+			}
+			if !slices.Equal(uris, tt.wantURIs) {
+				t.Errorf("segments = %q, want %q", uris, tt.wantURIs)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stations/proseka/archive?from=2026-10-01T10:00:00Z&to=2026-10-01T11:00:00Z", nil))
+	var index struct {
+		Hours []struct {
+			Start time.Time `json:"start"`
+			URL   string    `json:"url"`
+		} `json:"hours"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Hours) != 1 || index.Hours[0].URL != ArchiveURL("proseka", hour.Add(time.Hour), hour.Add(2*time.Hour)) {
+		t.Errorf("archive index = %+v", index)
+	}
+
+	// only hours that ended before the cutoff are pruned
+	n, err := s.PruneArchive(ctx, hour.Add(2*time.Hour+30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("PruneArchive() deleted %d segments, want 4", n)
+	}
+	if segs, _ := s.ArchiveSegments(ctx, "proseka", hour, hour.Add(3*time.Hour)); len(segs) != 1 || segs[0].ContentID != "2" || segs[0].SourceInitURI != "/contents/2/init.mp4" {
+		t.Errorf("segments after pruning = %+v", segs)
+	}
+}
+
+func TestOpenAddsColumns(t *testing.T) {
+	// a database created before archive_segments had init_uri
+	path := filepath.Join(t.TempDir(), "radio.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE archive_segments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, station TEXT NOT NULL, hour INTEGER NOT NULL,
+		media_sequence INTEGER NOT NULL, content_id TEXT NOT NULL, uri TEXT NOT NULL, duration REAL NOT NULL,
+		discontinuity INTEGER NOT NULL, filler INTEGER NOT NULL, published_at INTEGER NOT NULL);
+		INSERT INTO archive_segments (station, hour, media_sequence, content_id, uri, duration, discontinuity, filler, published_at)
+		VALUES ('proseka', 0, 0, '1', '/contents/1/0.ts', 10, 1, 0, 0);`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s.Close()
+	// the second Open finds the column already added
+	if s, err = Open(path, 0); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	at := time.UnixMilli(1000).UTC()
+	if err := s.RecordSegment(ctx, hls.PublishedSegment{Station: "proseka", ContentID: "2", SourceURI: "/contents/2/0.m4s", SourceInitURI: "/contents/2/init.mp4", Duration: 6, MediaSequence: 1, PublishedAt: at}); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := s.ArchiveSegments(ctx, "proseka", time.UnixMilli(0), at.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 2 || segs[0].SourceInitURI != "" || segs[1].SourceInitURI != "/contents/2/init.mp4" {
+		t.Errorf("ArchiveSegments() = %+v", segs)
+	}
+}
@@ -0,0 +1,84 @@
+package urlsign
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/furudenipa/hls-radio-server/go-server/internal/metrics"
+)
+
+// OriginalURIHeader carries the URI of the request being authorized, set in nginx with
+// proxy_set_header X-Original-URI $request_uri
+const OriginalURIHeader = "X-Original-URI"
+
+type result int
+
+const (
+	resultOK result = iota
+	resultMissing
+	resultExpired
+	resultInvalid
+	resultUnknownKey
+	numResults
+)
+
+var resultNames = [numResults]string{"ok", "missing", "expired", "invalid", "unknown_key"}
+
+func resultOf(err error) result {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, ErrMissingToken):
+		return resultMissing
+	case errors.Is(err, ErrExpired):
+		return resultExpired
+	case errors.Is(err, ErrUnknownKey):
+		return resultUnknownKey
+	default:
+		return resultInvalid
+	}
+}
+
+// AuthRequestHandler serves nginx auth_request subrequests: 204 when the URI in
+// X-Original-URI carries a valid token, 403 otherwise
+func (s *Signer) AuthRequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri := r.Header.Get(OriginalURIHeader)
+		if uri == "" {
+			http.Error(w, "missing "+OriginalURIHeader, http.StatusBadRequest)
+			return
+		}
+		if err := s.Verify(uri); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Middleware rejects requests to next whose URL is not validly signed
+func (s *Signer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Verify(r.URL.RequestURI()); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Collect implements metrics.Collector
+func (s *Signer) Collect() []metrics.Metric {
+	signed := metrics.Metric{
+		Name: "hlsradio_urls_signed_total", Help: "URLs signed.", Type: metrics.TypeCounter,
+		Samples: []metrics.Sample{{Value: float64(s.signed.Load())}},
+	}
+	verified := metrics.Metric{Name: "hlsradio_url_verifications_total", Help: "Signed URL verifications by result.", Type: metrics.TypeCounter}
+	for r := result(0); r < numResults; r++ {
+		verified.Samples = append(verified.Samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "result", Value: resultNames[r]}},
+			Value:  float64(s.verified[r].Load()),
+		})
+	}
+	return []metrics.Metric{signed, verified}
+}
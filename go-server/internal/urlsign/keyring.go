@@ -0,0 +1,77 @@
+package urlsign
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// minKeySize is the smallest accepted HMAC secret in bytes
+const minKeySize = 16
+
+// Keyring holds the signing keys by ID. New URLs are signed with the current key; URLs
+// signed with any other key in the ring still verify, which allows rotating keys without
+// invalidating URLs that listeners already hold.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring returns a keyring that signs with keys[current]
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+	copied := make(map[string][]byte, len(keys))
+	for id, secret := range keys {
+		if id == "" {
+			return nil, errors.New("key id is empty")
+		}
+		if len(secret) < minKeySize {
+			return nil, fmt.Errorf("key %q is shorter than %d bytes", id, minKeySize)
+		}
+		copied[id] = append([]byte(nil), secret...)
+	}
+	return &Keyring{current: current, keys: copied}, nil
+}
+
+// keyringFile is the JSON layout read by LoadKeyring:
+//
+//	{"current": "2025-02", "keys": {"2025-02": "<base64>", "2025-01": "<base64>"}}
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a keyring from a JSON file with base64 encoded secrets
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring %s: %w", path, err)
+	}
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal keyring %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q in %s is not base64: %w", id, path, err)
+		}
+		keys[id] = secret
+	}
+	return NewKeyring(f.Current, keys)
+}
+
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+func (k *Keyring) key(id string) ([]byte, bool) {
+	secret, ok := k.keys[id]
+	return secret, ok
+}
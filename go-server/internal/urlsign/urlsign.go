@@ -0,0 +1,198 @@
+// Package urlsign signs segment and playlist URLs with expiring HMAC tokens and
+// verifies them, either in front of handlers or as an nginx auth_request endpoint.
+package urlsign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Query parameters added to signed URLs
+const (
+	ParamExpires   = "exp"
+	ParamKeyID     = "kid"
+	ParamSignature = "sig"
+)
+
+var (
+	ErrMissingToken     = errors.New("url is not signed")
+	ErrExpired          = errors.New("signed url has expired")
+	ErrExpiresTooFar    = errors.New("signed url expires too far in the future")
+	ErrUnknownKey       = errors.New("signed url uses an unknown key")
+	ErrInvalidSignature = errors.New("signed url has an invalid signature")
+)
+
+const (
+	defaultTTL  = time.Hour
+	defaultSkew = 30 * time.Second
+)
+
+// Config configures a Signer
+type Config struct {
+	// TTL is how long signed URLs stay valid. It must cover the segment buffer and
+	// the live window, since segments are signed when they are queued.
+	TTL time.Duration
+	// Skew is the clock difference tolerated between the signing and verifying hosts
+	Skew time.Duration
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time
+}
+
+func DefaultConfig() Config {
+	return Config{TTL: defaultTTL, Skew: defaultSkew}
+}
+
+// Signer signs and verifies URLs with the keys of its keyring
+type Signer struct {
+	config  Config
+	keyring atomic.Pointer[Keyring]
+	logger  *slog.Logger
+
+	signed   atomic.Int64
+	verified [numResults]atomic.Int64
+}
+
+func New(keyring *Keyring, config Config) *Signer {
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+	if config.Skew < 0 {
+		config.Skew = 0
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	s := &Signer{config: config, logger: slog.Default()}
+	s.keyring.Store(keyring)
+	return s
+}
+
+// SetLogger sets the logger used for signing failures and keyring reloads
+func (s *Signer) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// SetKeyring swaps in a new keyring, e.g. after rotating keys
+func (s *Signer) SetKeyring(k *Keyring) {
+	s.keyring.Store(k)
+}
+
+// Sign appends an expiry, key ID and signature to rawURL. Only the path is signed, so
+// the URL can be served from any origin.
+func (s *Signer) Sign(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %q: %w", rawURL, err)
+	}
+	k := s.keyring.Load()
+	secret, _ := k.key(k.current)
+	expires := s.config.Now().Add(s.config.TTL).Unix()
+
+	q := u.Query()
+	q.Set(ParamExpires, strconv.FormatInt(expires, 10))
+	q.Set(ParamKeyID, k.current)
+	q.Set(ParamSignature, signature(secret, k.current, expires, u.Path))
+	u.RawQuery = q.Encode()
+	s.signed.Add(1)
+	return u.String(), nil
+}
+
+// RewriteURL implements hls.URLRewriter. URLs that cannot be parsed are published
+// unsigned, which verification then rejects.
+func (s *Signer) RewriteURL(uri string) string {
+	signed, err := s.Sign(uri)
+	if err != nil {
+		s.logger.Error("failed to sign url", "uri", uri, "error", err)
+		return uri
+	}
+	return signed
+}
+
+// Verify checks the token of a signed URL or request URI
+func (s *Signer) Verify(rawURL string) error {
+	err := s.verify(rawURL)
+	s.verified[resultOf(err)].Add(1)
+	return err
+}
+
+func (s *Signer) verify(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	q := u.Query()
+	expParam, kid, sig := q.Get(ParamExpires), q.Get(ParamKeyID), q.Get(ParamSignature)
+	if expParam == "" || kid == "" || sig == "" {
+		return ErrMissingToken
+	}
+	expires, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad expiry %q", ErrInvalidSignature, expParam)
+	}
+
+	secret, ok := s.keyring.Load().key(kid)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, kid, expires, u.Path))) {
+		return ErrInvalidSignature
+	}
+
+	// 署名側と検証側の時計のずれを Skew まで許容する
+	now := s.config.Now()
+	expiry := time.Unix(expires, 0)
+	if now.After(expiry.Add(s.config.Skew)) {
+		return fmt.Errorf("%w at %s", ErrExpired, expiry.UTC().Format(time.RFC3339))
+	}
+	if expiry.After(now.Add(s.config.TTL + s.config.Skew)) {
+		return fmt.Errorf("%w: %s", ErrExpiresTooFar, expiry.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// signature is the base64url HMAC-SHA256 of the key ID, expiry and path
+func signature(secret []byte, kid string, expires int64, path string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%d\n%s", kid, expires, path)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WatchKeyring reloads the keyring from path whenever the file changes, until ctx is done.
+// A keyring that fails to load is logged and the previous one is kept.
+func (s *Signer) WatchKeyring(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			k, err := LoadKeyring(path)
+			if err != nil {
+				s.logger.Error("failed to reload url signing keys", "path", path, "error", err)
+				continue
+			}
+			s.SetKeyring(k)
+			s.logger.Info("reloaded url signing keys", "path", path, "current", k.Current())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package urlsign
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	key1 = []byte("0123456789abcdef0123456789abcdef")
+	key2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func testKeyring(t *testing.T, current string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, map[string][]byte{"k1": key1, "k2": key2})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return k
+}
+
+// clock returns a Now function fixed at base+offset
+func clock(base time.Time, offset time.Duration) func() time.Time {
+	return func() time.Time { return base.Add(offset) }
+}
+
+func TestVerify(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	const uri = "/contents/music/1/seg0.ts"
+
+	tests := []struct {
+		name string
+		// signerOffset and verifierOffset shift each host's clock from base
+		signerOffset   time.Duration
+		verifierOffset time.Duration
+		tamper         func(signed string) string
+		rotate         bool
+		wantErr        error
+	}{
+		{name: "fresh url"},
+		{name: "just before expiry", verifierOffset: time.Hour - time.Second},
+		{name: "expired", verifierOffset: time.Hour + time.Minute, wantErr: ErrExpired},
+		{name: "verifier clock ahead within skew", verifierOffset: time.Hour + 20*time.Second},
+		{name: "verifier clock ahead beyond skew", verifierOffset: time.Hour + 40*time.Second, wantErr: ErrExpired},
+		{name: "signer clock ahead within skew", signerOffset: 20 * time.Second},
+		{name: "signer clock ahead beyond skew", signerOffset: 2 * time.Minute, wantErr: ErrExpiresTooFar},
+		{
+			name: "tampered path",
+			tamper: func(s string) string {
+				return strings.Replace(s, "seg0.ts", "seg1.ts", 1)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "extended expiry",
+			tamper: func(s string) string {
+				u, _ := url.Parse(s)
+				q := u.Query()
+				q.Set(ParamExpires, "4102444800")
+				u.RawQuery = q.Encode()
+				return u.String()
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "unknown key",
+			tamper: func(s string) string {
+				return strings.Replace(s, "kid=k1", "kid=k9", 1)
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "missing token",
+			tamper:  func(s string) string { return uri },
+			wantErr: ErrMissingToken,
+		},
+		{name: "signed before rotation", rotate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := New(testKeyring(t, "k1"), Config{TTL: time.Hour, Skew: 30 * time.Second, Now: clock(base, tt.signerOffset)})
+			verifier := New(testKeyring(t, "k1"), Config{TTL: time.Hour, Skew: 30 * time.Second, Now: clock(base, tt.verifierOffset)})
+
+			signed, err := signer.Sign(uri)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			if tt.tamper != nil {
+				signed = tt.tamper(signed)
+			}
+			if tt.rotate {
+				verifier.SetKeyring(testKeyring(t, "k2"))
+			}
+
+			err = verifier.Verify(signed)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Verify(%q) error = %v", signed, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify(%q) error = %v, want %v", signed, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignKeepsURL(t *testing.T) {
+	s := New(testKeyring(t, "k2"), DefaultConfig())
+	signed := s.RewriteURL("https://cdn.example.com/radio/music/1/seg0.ts?v=2")
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "cdn.example.com" || u.Path != "/radio/music/1/seg0.ts" || u.Query().Get("v") != "2" || u.Query().Get(ParamKeyID) != "k2" {
+		t.Errorf("RewriteURL() = %q", signed)
+	}
+	// CDN の URL でもパスだけで検証できる
+	if err := s.Verify(u.RequestURI()); err != nil {
+		t.Errorf("Verify() of the request URI error = %v", err)
+	}
+}
+
+func TestAuthRequestHandler(t *testing.T) {
+	s := New(testKeyring(t, "k1"), DefaultConfig())
+	signed := s.RewriteURL("/contents/music/1/seg0.ts")
+
+	tests := []struct {
+		name     string
+		uri      string
+		wantCode int
+	}{
+		{"valid", signed, http.StatusNoContent},
+		{"unsigned", "/contents/music/1/seg0.ts", http.StatusForbidden},
+		{"no header", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/segment", nil)
+			if tt.uri != "" {
+				req.Header.Set(OriginalURIHeader, tt.uri)
+			}
+			rec := httptest.NewRecorder()
+			s.AuthRequestHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+
+	metrics := s.Collect()
+	if got := metrics[1].Samples[resultOK].Value; got != 1 {
+		t.Errorf("ok verifications = %v, want 1", got)
+	}
+	if got := metrics[1].Samples[resultMissing].Value; got != 1 {
+		t.Errorf("missing verifications = %v, want 1", got)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	enc := base64.StdEncoding.EncodeToString
+	content := `{"current":"k2","keys":{"k1":"` + enc(key1) + `","k2":"` + enc(key2) + `"}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	if k.Current() != "k2" {
+		t.Errorf("Current() = %q, want k2", k.Current())
+	}
+
+	if _, err := NewKeyring("k1", map[string][]byte{"k1": []byte("short")}); err == nil {
+		t.Error("NewKeyring() should reject short keys")
+	}
+	if _, err := NewKeyring("k3", map[string][]byte{"k1": key1}); err == nil {
+		t.Error("NewKeyring() should reject a missing current key")
+	}
+}